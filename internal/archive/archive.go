@@ -0,0 +1,461 @@
+// Package archive reads and writes .aviary archives: a whole work in a single
+// zip file, so that it can be handed to someone offline. An archive holds
+//
+//	manifest.json      see [Manifest]
+//	parts/<part>.mus3  every device's part of the work's musical log.
+//	blobs/<design>     any files uploaded into the work, see [BlobName].
+//	snap.png           the work's snapshot, if it has one.
+//	credits.md         the credits for the library artwork that the work
+//	credits.json       uses, see [Manifest.Attribution].
+package archive
+
+import (
+	"archive/zip"
+	"cmp"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"runtime.link/api/xray"
+	"the.quetzal.community/aviary/internal/library"
+	"the.quetzal.community/aviary/internal/musical"
+)
+
+// Extension of an archive file.
+const Extension = ".aviary"
+
+// Version of the archive format written by [Writer], archives with a later
+// version are refused by [NewReader].
+const Version = 1
+
+// Manifest describes the work in an archive.
+type Manifest struct {
+	Version int              `json:"version"`
+	Work    string           `json:"work"`             // musical.WorkID, base64 (raw URL) encoded, as named in the user's saves.
+	Origin  string           `json:"origin,omitempty"` // work that this one was forked from, see musical.Provenance.
+	Authors []musical.Author `json:"authors"`          // every author that contributed to the work.
+	Designs []Design         `json:"designs"`          // every design that the work imports.
+	Parts   []File           `json:"parts"`
+	Blobs   []File           `json:"blobs,omitempty"`
+	Snap    *File            `json:"snap,omitempty"`
+}
+
+// Design imported by the work, by its resource URI.
+type Design struct {
+	URI     string `json:"uri"`
+	Author  string `json:"author,omitempty"`  // library author, see library.Author.
+	License string `json:"license,omitempty"` // license of the library author, see library.License.
+}
+
+// File within the archive.
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// WorkID decodes the manifest's work.
+func (manifest Manifest) WorkID() (musical.WorkID, error) {
+	var work musical.WorkID
+	id, err := base64.RawURLEncoding.DecodeString(manifest.Work)
+	if err != nil || len(id) != len(work) {
+		return work, fmt.Errorf("invalid work %q", manifest.Work)
+	}
+	copy(work[:], id)
+	return work, nil
+}
+
+// Attribution reports on the library artwork that the work uses, and the
+// obligations of its licenses, by the author and license recorded for each
+// design when the archive was written.
+func (manifest Manifest) Attribution() library.Report {
+	uses := make([]library.Use, len(manifest.Designs))
+	for i, design := range manifest.Designs {
+		uses[i] = library.Use{URI: design.URI, Author: design.Author, License: design.License}
+	}
+	return library.AttributeUses(uses)
+}
+
+// BlobName is the name of the file uploaded into the design, as archived, and
+// as stored in the user data directory.
+func BlobName(design musical.Design) string {
+	return fmt.Sprintf("%d-%d", design.Author, design.Number)
+}
+
+// contents collects what a musical log refers to, for the manifest.
+type contents struct {
+	musical.Stubbed
+
+	origin  musical.WorkID
+	authors map[musical.Author]struct{}
+	designs map[string]struct{}
+	uploads map[musical.Design]struct{}
+}
+
+func (c *contents) Member(req musical.Member) error {
+	if req.Origin != (musical.WorkID{}) && c.origin == (musical.WorkID{}) {
+		c.origin = req.Origin
+	}
+	c.authors[req.Author] = struct{}{}
+	return nil
+}
+func (c *contents) Upload(file musical.Upload) error {
+	c.uploads[file.Design] = struct{}{}
+	return nil
+}
+func (c *contents) Import(uri musical.Import) error {
+	c.designs[uri.Import] = struct{}{}
+	return nil
+}
+func (c *contents) Change(con musical.Change) error {
+	c.authors[con.Author] = struct{}{}
+	return nil
+}
+func (c *contents) Action(rel musical.Action) error {
+	c.authors[rel.Author] = struct{}{}
+	return nil
+}
+func (c *contents) Sculpt(ats musical.Sculpt) error {
+	c.authors[ats.Author] = struct{}{}
+	return nil
+}
+
+// Writer writes an archive, the manifest is written by Close.
+type Writer struct {
+	zip      *zip.Writer
+	manifest Manifest
+	contents contents
+	names    map[string]bool
+}
+
+// NewWriter starts an archive of the work, written to w.
+func NewWriter(w io.Writer, work musical.WorkID) *Writer {
+	return &Writer{
+		zip: zip.NewWriter(w),
+		manifest: Manifest{
+			Version: Version,
+			Work:    base64.RawURLEncoding.EncodeToString(work[:]),
+		},
+		contents: contents{
+			authors: make(map[musical.Author]struct{}),
+			designs: make(map[string]struct{}),
+			uploads: make(map[musical.Design]struct{}),
+		},
+		names: make(map[string]bool),
+	}
+}
+
+// add copies r into the archive at path.
+func (w *Writer) add(path, name string, r io.Reader) (File, error) {
+	if err := component(name); err != nil {
+		return File{}, err
+	}
+	if w.names[path] {
+		return File{}, xray.New(fmt.Errorf("duplicate %s in archive", path))
+	}
+	w.names[path] = true
+	entry, err := w.zip.Create(path)
+	if err != nil {
+		return File{}, xray.New(err)
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(entry, hash), r)
+	if err != nil {
+		return File{}, xray.New(err)
+	}
+	return File{Name: name, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// AddPart adds a part of the work's musical log, which must be a complete
+// .mus3 log (starting with its magic header).
+func (w *Writer) AddPart(part string, mus3 io.Reader) error {
+	pr, pw := io.Pipe()
+	replayed := make(chan error, 1)
+	go func() {
+		_, err := musical.Replay(pr, &w.contents)
+		io.Copy(io.Discard, pr)
+		replayed <- err
+	}()
+	file, err := w.add("parts/"+part+".mus3", part, io.TeeReader(mus3, pw))
+	pw.Close()
+	if rerr := <-replayed; err == nil && rerr != nil {
+		err = fmt.Errorf("part %s: %w", part, rerr)
+	}
+	if err != nil {
+		return err
+	}
+	w.manifest.Parts = append(w.manifest.Parts, file)
+	return nil
+}
+
+// AddBlob adds a file uploaded into the work.
+func (w *Writer) AddBlob(name string, r io.Reader) error {
+	file, err := w.add("blobs/"+name, name, r)
+	if err != nil {
+		return err
+	}
+	w.manifest.Blobs = append(w.manifest.Blobs, file)
+	return nil
+}
+
+// AddSnap adds the work's snapshot, a PNG image.
+func (w *Writer) AddSnap(png io.Reader) error {
+	file, err := w.add("snap.png", "snap.png", png)
+	if err != nil {
+		return err
+	}
+	w.manifest.Snap = &file
+	return nil
+}
+
+// Uploads returns the designs that the parts added so far upload files into,
+// to be added with AddBlob under their BlobName.
+func (w *Writer) Uploads() []musical.Design {
+	uploads := make([]musical.Design, 0, len(w.contents.uploads))
+	for design := range w.contents.uploads {
+		uploads = append(uploads, design)
+	}
+	slices.SortFunc(uploads, func(a, b musical.Design) int {
+		return cmp.Or(cmp.Compare(a.Author, b.Author), cmp.Compare(a.Number, b.Number))
+	})
+	return uploads
+}
+
+// Manifest returns the manifest of what has been added so far.
+func (w *Writer) Manifest() Manifest {
+	manifest := w.manifest
+	if w.contents.origin != (musical.WorkID{}) {
+		manifest.Origin = base64.RawURLEncoding.EncodeToString(w.contents.origin[:])
+	}
+	manifest.Authors = make([]musical.Author, 0, len(w.contents.authors))
+	for author := range w.contents.authors {
+		manifest.Authors = append(manifest.Authors, author)
+	}
+	slices.Sort(manifest.Authors)
+	manifest.Designs = make([]Design, 0, len(w.contents.designs))
+	for uri := range w.contents.designs {
+		author := library.Author(uri)
+		manifest.Designs = append(manifest.Designs, Design{
+			URI:     uri,
+			Author:  author,
+			License: library.License(author),
+		})
+	}
+	slices.SortFunc(manifest.Designs, func(a, b Design) int { return strings.Compare(a.URI, b.URI) })
+	return manifest
+}
+
+// Close writes the manifest and finishes the archive, it does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if len(w.manifest.Parts) == 0 {
+		return xray.New(errors.New("archive has no parts"))
+	}
+	manifest := w.Manifest()
+	data, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return xray.New(err)
+	}
+	credits, err := manifest.Attribution().JSON()
+	if err != nil {
+		return xray.New(err)
+	}
+	for _, file := range []struct {
+		path string
+		data []byte
+	}{
+		{"manifest.json", data},
+		{"credits.md", []byte(manifest.Attribution().Markdown())},
+		{"credits.json", credits},
+	} {
+		entry, err := w.zip.Create(file.path)
+		if err != nil {
+			return xray.New(err)
+		}
+		if _, err := entry.Write(file.data); err != nil {
+			return xray.New(err)
+		}
+	}
+	if err := w.zip.Close(); err != nil {
+		return xray.New(err)
+	}
+	return nil
+}
+
+// Reader reads a validated archive.
+type Reader struct {
+	Manifest Manifest
+
+	zip    *zip.Reader
+	closer io.Closer
+}
+
+// OpenReader opens and validates the archive at path.
+func OpenReader(path string) (*Reader, error) {
+	file, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	reader, err := newReader(&file.Reader)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	reader.closer = file
+	return reader, nil
+}
+
+// NewReader reads and validates the archive in r, of the given size. Every
+// file listed in the manifest must be present and intact, and every part must
+// be a valid musical log.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	file, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	return newReader(file)
+}
+
+func newReader(file *zip.Reader) (*Reader, error) {
+	reader := &Reader{zip: file}
+	manifest, err := reader.open("manifest.json")
+	if err != nil {
+		return nil, fmt.Errorf("not an %s archive: %w", Extension, err)
+	}
+	data, err := io.ReadAll(manifest)
+	manifest.Close()
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	if err := json.Unmarshal(data, &reader.Manifest); err != nil {
+		return nil, xray.New(err)
+	}
+	if err := reader.validate(); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+func (r *Reader) validate() error {
+	manifest := r.Manifest
+	if manifest.Version < 1 || manifest.Version > Version {
+		return fmt.Errorf("unsupported %s archive version %d", Extension, manifest.Version)
+	}
+	if _, err := manifest.WorkID(); err != nil {
+		return err
+	}
+	if len(manifest.Parts) == 0 {
+		return errors.New("archive has no parts")
+	}
+	seen := make(map[string]bool)
+	check := func(path string, file File) error {
+		if err := component(file.Name); err != nil {
+			return err
+		}
+		if seen[path] {
+			return fmt.Errorf("duplicate %s in archive", path)
+		}
+		seen[path] = true
+		data, err := r.open(path)
+		if err != nil {
+			return err
+		}
+		defer data.Close()
+		hash := sha256.New()
+		sink := io.Writer(hash)
+		replayed := make(chan error, 1)
+		var pw *io.PipeWriter
+		if strings.HasPrefix(path, "parts/") {
+			var pr *io.PipeReader
+			pr, pw = io.Pipe()
+			sink = io.MultiWriter(hash, pw)
+			go func() {
+				_, err := musical.Replay(pr, musical.Stubbed{})
+				io.Copy(io.Discard, pr)
+				replayed <- err
+			}()
+		} else {
+			replayed <- nil
+		}
+		size, err := io.Copy(sink, data)
+		if pw != nil {
+			pw.Close()
+		}
+		if err != nil {
+			return xray.New(err)
+		}
+		if err := <-replayed; err != nil {
+			return fmt.Errorf("%s is not a valid musical log: %w", path, err)
+		}
+		if sum := hex.EncodeToString(hash.Sum(nil)); size != file.Size || sum != file.SHA256 {
+			return fmt.Errorf("%s is corrupt (%d bytes, sha256 %s; want %d bytes, sha256 %s)", path, size, sum, file.Size, file.SHA256)
+		}
+		return nil
+	}
+	for _, part := range manifest.Parts {
+		if err := check("parts/"+part.Name+".mus3", part); err != nil {
+			return err
+		}
+	}
+	for _, blob := range manifest.Blobs {
+		if err := check("blobs/"+blob.Name, blob); err != nil {
+			return err
+		}
+	}
+	if manifest.Snap != nil {
+		if err := check("snap.png", *manifest.Snap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Reader) open(path string) (io.ReadCloser, error) {
+	file, err := r.zip.Open(path)
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	return file, nil
+}
+
+// Part opens the named part of the work's musical log.
+func (r *Reader) Part(name string) (io.ReadCloser, error) {
+	return r.open("parts/" + name + ".mus3")
+}
+
+// Blob opens the named file uploaded into the work.
+func (r *Reader) Blob(name string) (io.ReadCloser, error) {
+	return r.open("blobs/" + name)
+}
+
+// Snap opens the work's snapshot, fs.ErrNotExist if it has none.
+func (r *Reader) Snap() (io.ReadCloser, error) {
+	if r.Manifest.Snap == nil {
+		return nil, xray.New(fs.ErrNotExist)
+	}
+	return r.open("snap.png")
+}
+
+// Close closes the archive, if it was opened by OpenReader.
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// component checks that a name is a single path component, so that an archive
+// can't reach outside of where it's installed.
+func component(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid name %q in archive", name)
+	}
+	return nil
+}
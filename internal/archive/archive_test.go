@@ -0,0 +1,200 @@
+package archive_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"the.quetzal.community/aviary/internal/archive"
+	"the.quetzal.community/aviary/internal/library"
+	"the.quetzal.community/aviary/internal/musical"
+)
+
+// importEntry encodes a musical.Import entry, as musical's storage would.
+func importEntry(author musical.Author, number uint16, uri string) []byte {
+	buf := []byte{4} // import
+	buf = binary.LittleEndian.AppendUint16(buf, 1<<15|1<<0|1<<1)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(author))
+	buf = binary.LittleEndian.AppendUint16(buf, number)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(uri)))
+	return append(buf, uri...)
+}
+
+// uploadEntry encodes a musical.Upload entry, as musical's storage would (the
+// file itself isn't recorded).
+func uploadEntry(author musical.Author, number uint16) []byte {
+	buf := []byte{2} // upload
+	buf = binary.LittleEndian.AppendUint16(buf, 1<<15|1<<0)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(author))
+	return binary.LittleEndian.AppendUint16(buf, number)
+}
+
+func testParts(t *testing.T) (musical.WorkID, musical.WorkID, map[string][]byte) {
+	t.Helper()
+	origin, work := musical.WorkID{1, 2, 3}, musical.WorkID{4, 5, 6}
+	var laptop bytes.Buffer
+	if err := musical.Fork(&laptop, origin, work, 3, false); err != nil {
+		t.Fatal(err)
+	}
+	laptop.Write(importEntry(3, 1, "res://library/kenney/tree/oak.glb"))
+	laptop.Write(uploadEntry(3, 2))
+	tablet := append([]byte(musical.MagicHeader), importEntry(7, 1, "res://library/wildfire_games/wall/stone.glb")...)
+	return origin, work, map[string][]byte{
+		"laptop": laptop.Bytes(),
+		"tablet": tablet,
+	}
+}
+
+func TestArchive(t *testing.T) {
+	origin, work, parts := testParts(t)
+	var buf bytes.Buffer
+	w := archive.NewWriter(&buf, work)
+	for _, part := range []string{"laptop", "tablet"} {
+		if err := w.AddPart(part, bytes.NewReader(parts[part])); err != nil {
+			t.Fatalf("AddPart(%s): %v", part, err)
+		}
+	}
+	sketch := archive.BlobName(musical.Design{Author: 3, Number: 2})
+	if !slices.Equal(w.Uploads(), []musical.Design{{Author: 3, Number: 2}}) {
+		t.Errorf("Uploads = %v, want the sketch", w.Uploads())
+	}
+	if err := w.AddBlob(sketch, bytes.NewReader([]byte("glTF"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddSnap(bytes.NewReader([]byte("\x89PNG"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := archive.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	manifest := r.Manifest
+	if got, err := manifest.WorkID(); err != nil || got != work {
+		t.Errorf("WorkID = %v, %v, want %v", got, err, work)
+	}
+	if manifest.Origin != base64.RawURLEncoding.EncodeToString(origin[:]) {
+		t.Errorf("Origin = %q, want %v", manifest.Origin, origin)
+	}
+	if !slices.Equal(manifest.Authors, []musical.Author{3}) {
+		t.Errorf("Authors = %v, want [3]", manifest.Authors)
+	}
+	want := []archive.Design{
+		{URI: "res://library/kenney/tree/oak.glb", Author: "kenney", License: library.CCZero},
+		{URI: "res://library/wildfire_games/wall/stone.glb", Author: "wildfire_games", License: library.CCBYSA},
+	}
+	if !slices.Equal(manifest.Designs, want) {
+		t.Errorf("Designs = %+v, want %+v", manifest.Designs, want)
+	}
+	if credits := manifest.Attribution(); !credits.ShareAlike() || len(credits.Licenses) != 2 {
+		t.Errorf("Attribution = %+v, want the share-alike wall and the CC0 tree", credits)
+	}
+
+	dir := t.TempDir()
+	installed, err := r.Install(dir, "phone")
+	if err != nil || installed != work {
+		t.Fatalf("Install = %v, %v", installed, err)
+	}
+	name := base64.RawURLEncoding.EncodeToString(work[:])
+	log, err := os.ReadFile(filepath.Join(dir, "saves", name, "phone.mus3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLog := append(slices.Clone(parts["laptop"]), parts["tablet"][len(musical.MagicHeader):]...)
+	if !bytes.Equal(log, wantLog) {
+		t.Errorf("installed log = %q, want %q", log, wantLog)
+	}
+	if blob, err := os.ReadFile(filepath.Join(dir, "saves", name, "blobs", sketch)); err != nil || string(blob) != "glTF" {
+		t.Errorf("installed blob = %q, %v", blob, err)
+	}
+	if snap, err := os.ReadFile(filepath.Join(dir, "snaps", name+".png")); err != nil || string(snap) != "\x89PNG" {
+		t.Errorf("installed snap = %q, %v", snap, err)
+	}
+	if _, err := r.Install(dir, "phone"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("second Install = %v, want fs.ErrExist", err)
+	}
+
+	// exporting the installed work archives the same log, and the sketch
+	// that it uploads (but not files that it doesn't).
+	if err := os.WriteFile(filepath.Join(dir, "saves", name, "blobs", "7-9"), []byte("stray"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	localParts, err := archive.LocalParts(dir, work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported bytes.Buffer
+	if _, err := archive.Export(&exported, dir, work, localParts); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	r, err = archive.NewReader(bytes.NewReader(exported.Bytes()), int64(exported.Len()))
+	if err != nil {
+		t.Fatalf("NewReader of export: %v", err)
+	}
+	if len(r.Manifest.Parts) != 1 || r.Manifest.Parts[0].Name != "phone" || len(r.Manifest.Blobs) != 1 || r.Manifest.Blobs[0].Name != sketch || r.Manifest.Snap == nil {
+		t.Errorf("exported manifest = %+v", r.Manifest)
+	}
+	part, err := r.Part("phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer part.Close()
+	if data, err := io.ReadAll(part); err != nil || !bytes.Equal(data, wantLog) {
+		t.Errorf("exported part = %q, %v", data, err)
+	}
+}
+
+func TestArchiveRejectsCorruption(t *testing.T) {
+	_, work, parts := testParts(t)
+	var buf bytes.Buffer
+	w := archive.NewWriter(&buf, work)
+	if err := w.AddPart("laptop", bytes.NewReader(parts["laptop"])); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// such a short part deflates into literals, so it can be tampered with in
+	// place, leaving a valid log that no longer matches the manifest.
+	corrupt := bytes.Replace(buf.Bytes(), []byte("oak.glb"), []byte("elm.glb"), 1)
+	if bytes.Equal(corrupt, buf.Bytes()) {
+		t.Skip("part is compressed beyond recognition")
+	}
+	if _, err := archive.NewReader(bytes.NewReader(corrupt), int64(len(corrupt))); err == nil {
+		t.Error("NewReader accepted a corrupt part")
+	}
+
+	w = archive.NewWriter(io.Discard, work)
+	if err := w.AddPart("junk", bytes.NewReader([]byte("not a musical log"))); err == nil {
+		t.Error("AddPart accepted an invalid log")
+	}
+	if err := w.AddPart("../escape", bytes.NewReader(parts["laptop"])); err == nil {
+		t.Error("AddPart accepted a path")
+	}
+}
+
+func TestAttributionAsRecorded(t *testing.T) {
+	// kenney publishes under CC0, but this archive was written when the oak
+	// was under CC BY-SA, which is what its credits must still report.
+	manifest := archive.Manifest{Designs: []archive.Design{
+		{URI: "res://library/kenney/tree/oak.glb", Author: "kenney", License: library.CCBYSA},
+		{URI: "res://builtin/terrain"},
+	}}
+	credits := manifest.Attribution()
+	if !credits.ShareAlike() || len(credits.Licenses) != 1 || len(credits.Licenses[0].Authors) != 1 {
+		t.Fatalf("Attribution = %+v, want the oak under CC BY-SA", credits)
+	}
+	if credit := credits.Licenses[0].Authors[0]; credit.Author != "kenney" || !slices.Equal(credit.Designs, []string{"res://library/kenney/tree/oak.glb"}) {
+		t.Errorf("credit = %+v, want kenney for the oak", credit)
+	}
+}
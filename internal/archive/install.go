@@ -0,0 +1,210 @@
+package archive
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"runtime.link/api/xray"
+	"the.quetzal.community/aviary/internal/musical"
+)
+
+// The archive is installed into, and exported from, a user data directory,
+// laid out as
+//
+//	saves/<work>/<device>.mus3      each device's own part of the work.
+//	saves/<work>/cloud/<part>.mus3  cached copies of the other devices' parts.
+//	saves/<work>/blobs/<design>     files uploaded into the work, see BlobName.
+//	snaps/<work>.png                the work's snapshot.
+
+// Install registers the archived work as a local work in the user data
+// directory dir. Every part is copied, in order, into the given device's own
+// part of the work, so that it opens just like a work created on the device. A
+// work that already exists locally is left untouched, and reported as
+// fs.ErrExist.
+func (r *Reader) Install(dir, device string) (musical.WorkID, error) {
+	work, err := r.Manifest.WorkID()
+	if err != nil {
+		return work, err
+	}
+	if err := component(device); err != nil {
+		return work, err
+	}
+	name := base64.RawURLEncoding.EncodeToString(work[:])
+	saves := filepath.Join(dir, "saves")
+	if _, err := os.Stat(filepath.Join(saves, name)); err == nil {
+		return work, xray.New(fmt.Errorf("work %s: %w", name, fs.ErrExist))
+	}
+	if err := os.MkdirAll(saves, 0777); err != nil {
+		return work, xray.New(err)
+	}
+	// assemble the work beside where it belongs, so that a failed install
+	// never leaves a partial work behind.
+	tmp, err := os.MkdirTemp(saves, "."+name+".*")
+	if err != nil {
+		return work, xray.New(err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := r.installParts(filepath.Join(tmp, device+".mus3")); err != nil {
+		return work, err
+	}
+	for _, blob := range r.Manifest.Blobs {
+		if err := installFile(filepath.Join(tmp, "blobs", blob.Name), func() (io.ReadCloser, error) { return r.Blob(blob.Name) }); err != nil {
+			return work, err
+		}
+	}
+	if err := os.Rename(tmp, filepath.Join(saves, name)); err != nil {
+		return work, xray.New(err)
+	}
+	if r.Manifest.Snap != nil {
+		if err := installFile(filepath.Join(dir, "snaps", name+".png"), r.Snap); err != nil {
+			return work, err
+		}
+	}
+	return work, nil
+}
+
+// installParts concatenates every part into a single musical log at path.
+func (r *Reader) installParts(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return xray.New(err)
+	}
+	defer file.Close()
+	if _, err := io.WriteString(file, musical.MagicHeader); err != nil {
+		return xray.New(err)
+	}
+	for _, part := range r.Manifest.Parts {
+		mus3, err := r.Part(part.Name)
+		if err != nil {
+			return err
+		}
+		var header [len(musical.MagicHeader)]byte
+		_, err = io.ReadFull(mus3, header[:])
+		if err == nil {
+			_, err = io.Copy(file, mus3)
+		}
+		mus3.Close()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return xray.New(err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		return xray.New(err)
+	}
+	return nil
+}
+
+func installFile(path string, open func() (io.ReadCloser, error)) error {
+	src, err := open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return xray.New(err)
+	}
+	dst, err := os.Create(path)
+	if err != nil {
+		return xray.New(err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return xray.New(err)
+	}
+	if err := dst.Close(); err != nil {
+		return xray.New(err)
+	}
+	return nil
+}
+
+// Part of a work to export, opened when it is archived.
+type Part struct {
+	Name string
+	Open func() (io.ReadCloser, error)
+}
+
+// LocalParts lists the parts of the work stored in the user data directory
+// dir: each device's own part, under the device's name, followed by the
+// cached copies of the other devices' parts.
+func LocalParts(dir string, work musical.WorkID) ([]Part, error) {
+	name := base64.RawURLEncoding.EncodeToString(work[:])
+	saves := filepath.Join(dir, "saves", name)
+	var parts []Part
+	added := make(map[string]bool)
+	for _, dir := range []string{saves, filepath.Join(saves, "cloud")} {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.mus3"))
+		if err != nil {
+			return nil, xray.New(err)
+		}
+		slices.Sort(matches)
+		for _, path := range matches {
+			part := strings.TrimSuffix(filepath.Base(path), ".mus3")
+			if added[part] {
+				continue
+			}
+			added[part] = true
+			parts = append(parts, Part{Name: part, Open: func() (io.ReadCloser, error) {
+				file, err := os.Open(path)
+				if err != nil {
+					return nil, xray.New(err)
+				}
+				return file, nil
+			}})
+		}
+	}
+	return parts, nil
+}
+
+// Export writes an archive of the work to w, with the given parts, along with
+// the files uploaded into the work by the Upload records of those parts, and
+// the work's snapshot, as stored in the user data directory dir. Uploads made
+// on other devices (whose files are not shared with this one) are left out.
+// It returns the manifest of the archive.
+func Export(w io.Writer, dir string, work musical.WorkID, parts []Part) (Manifest, error) {
+	name := base64.RawURLEncoding.EncodeToString(work[:])
+	archive := NewWriter(w, work)
+	for _, part := range parts {
+		mus3, err := part.Open()
+		if err != nil {
+			return Manifest{}, err
+		}
+		err = archive.AddPart(part.Name, mus3)
+		mus3.Close()
+		if err != nil {
+			return Manifest{}, err
+		}
+	}
+	for _, design := range archive.Uploads() {
+		blob := BlobName(design)
+		err := addFile(filepath.Join(dir, "saves", name, "blobs", blob), func(r io.Reader) error { return archive.AddBlob(blob, r) })
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return Manifest{}, err
+		}
+	}
+	snap := filepath.Join(dir, "snaps", name+".png")
+	if _, err := os.Stat(snap); err == nil {
+		if err := addFile(snap, archive.AddSnap); err != nil {
+			return Manifest{}, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return Manifest{}, err
+	}
+	return archive.Manifest(), nil
+}
+
+func addFile(path string, add func(io.Reader) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return xray.New(err)
+	}
+	defer file.Close()
+	return add(file)
+}
@@ -0,0 +1,493 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"graphics.gd/classdb/ConfirmationDialog"
+	"graphics.gd/classdb/Control"
+	"graphics.gd/classdb/Engine"
+	"graphics.gd/classdb/GUI"
+	"graphics.gd/classdb/Label"
+	"graphics.gd/classdb/LineEdit"
+	"runtime.link/api/xray"
+	"the.quetzal.community/aviary/internal/ice/signalling"
+	"the.quetzal.community/aviary/internal/musical"
+)
+
+// Checkpoints are named versions of a work (see signalling.Checkpoint). Each
+// Ctrl+S records one, named after the time (Ctrl+Shift+S asks for a name),
+// listed in UserDataDir/saves/<work>/checkpoints.json and synced with the
+// cloud. A work can be opened as it was at a checkpoint (OpenCheckpoint), or
+// forked from one into a new work (forkCheckpoint).
+
+func checkpointsPath(name string) string {
+	return UserDataDir + "/saves/" + name + "/checkpoints.json"
+}
+
+// loadCheckpoints returns the checkpoints recorded locally for the work named
+// name, oldest first.
+func loadCheckpoints(name string) ([]signalling.Checkpoint, error) {
+	data, err := os.ReadFile(checkpointsPath(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	var checkpoints []signalling.Checkpoint
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		return nil, xray.New(err)
+	}
+	return checkpoints, nil
+}
+
+func storeCheckpoints(name string, checkpoints []signalling.Checkpoint) error {
+	data, err := json.Marshal(checkpoints)
+	if err != nil {
+		return xray.New(err)
+	}
+	if err := os.MkdirAll(UserDataDir+"/saves/"+name, 0777); err != nil {
+		return xray.New(err)
+	}
+	tmp := checkpointsPath(name) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return xray.New(err)
+	}
+	if err := os.Rename(tmp, checkpointsPath(name)); err != nil {
+		return xray.New(err)
+	}
+	return nil
+}
+
+// mergeCheckpoints combines lists of checkpoints by name, keeping the most
+// recent checkpoint of each name, oldest first.
+func mergeCheckpoints(lists ...[]signalling.Checkpoint) []signalling.Checkpoint {
+	named := make(map[string]signalling.Checkpoint)
+	for _, list := range lists {
+		for _, checkpoint := range list {
+			if existing, ok := named[checkpoint.Name]; !ok || checkpoint.Time.After(existing.Time) {
+				named[checkpoint.Name] = checkpoint
+			}
+		}
+	}
+	merged := make([]signalling.Checkpoint, 0, len(named))
+	for _, checkpoint := range named {
+		merged = append(merged, checkpoint)
+	}
+	slices.SortFunc(merged, func(a, b signalling.Checkpoint) int {
+		if c := a.Time.Compare(b.Time); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return merged
+}
+
+// syncCheckpoints merges the checkpoints of a work recorded locally with those
+// in the cloud, uploading any the cloud is missing. Without the cloud, the
+// local checkpoints are returned along with the error.
+func syncCheckpoints(community signalling.API, work musical.WorkID) ([]signalling.Checkpoint, error) {
+	name := base64.RawURLEncoding.EncodeToString(work[:])
+	local, err := loadCheckpoints(name)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cloud, err := community.CloudCheckpoints(ctx, signalling.WorkID(name))
+	if err != nil {
+		return local, xray.New(err)
+	}
+	merged := mergeCheckpoints(local, cloud)
+	var errs []error
+	for _, checkpoint := range merged {
+		synced := slices.ContainsFunc(cloud, func(existing signalling.Checkpoint) bool {
+			return existing.Name == checkpoint.Name && existing.Time.Equal(checkpoint.Time)
+		})
+		if !synced {
+			if err := community.InsertCheckpoint(ctx, signalling.WorkID(name), checkpoint); err != nil {
+				errs = append(errs, xray.New(err))
+			}
+		}
+	}
+	if err := storeCheckpoints(name, merged); err != nil {
+		errs = append(errs, err)
+	}
+	return merged, errors.Join(errs...)
+}
+
+// checkpointOffsets returns the length of each part of the work named name:
+// this device's own local part, and the other devices' parts as the cloud lists
+// them (less any merged into ours, see reclaimStale), or, offline, as they are
+// cached (see cloudManifest).
+func checkpointOffsets(community signalling.API, cloud bool, name string) (map[signalling.PartID]int64, error) {
+	outbox := openOutbox(name, UserState.Device)
+	local, err := os.Stat(UserDataDir + "/saves/" + name + "/" + UserState.Device + ".mus3")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, xray.New(err)
+	}
+	offsets := make(map[signalling.PartID]int64)
+	if cloud {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		parts, err := community.CloudParts(ctx, signalling.WorkID(name))
+		cancel()
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, xray.New(err)
+		}
+		for part, stat := range parts {
+			if !outbox.merged(part, stat) {
+				offsets[part] = stat.Size
+			}
+		}
+	} else {
+		manifest := cloudManifestOf(name)
+		manifest.mutex.Lock()
+		for part, cached := range manifest.Parts {
+			if !cached.Deleted { // merged into ours.
+				offsets[part] = cached.Size
+			}
+		}
+		manifest.mutex.Unlock()
+	}
+	if local != nil { // or else, we've yet to contribute to it.
+		offsets[outbox.Part] = local.Size()
+	}
+	return offsets, nil
+}
+
+// startCheckpoint starts to record a checkpoint of the work as it was saved,
+// named after the time of the save, and sends it once the length of each part
+// is known (see checkpointOffsets), or else reports why not, and sends nothing.
+func (world *Client) startCheckpoint(thumbnail []byte, saved time.Time) <-chan signalling.Checkpoint {
+	name := base64.RawURLEncoding.EncodeToString(world.record[:])
+	cloud := UserState.Aviary.TogetherUntil.After(saved)
+	ready := make(chan signalling.Checkpoint, 1)
+	go func() {
+		defer close(ready)
+		offsets, err := checkpointOffsets(world.signalling, cloud, name)
+		if err != nil {
+			Engine.Raise(fmt.Errorf("failed to record checkpoint: %w", err))
+			return
+		}
+		ready <- signalling.Checkpoint{
+			Name:      saved.Format("2006-01-02 15:04:05"),
+			Time:      saved,
+			Offsets:   offsets,
+			Thumbnail: thumbnail,
+		}
+	}()
+	return ready
+}
+
+// takeCheckpoint records a checkpoint of the save just made, named after the
+// time of the save (see apiCheckpoint).
+func (world *Client) takeCheckpoint(thumbnail []byte) {
+	ready := world.startCheckpoint(thumbnail, time.Now())
+	go func() {
+		if checkpoint, ok := <-ready; ok {
+			world.apiCheckpoint(checkpoint)
+		}
+	}()
+}
+
+// nameCheckpoint asks for a name for the checkpoint of the save just made,
+// with the time of the save as the default, and then records it (see
+// apiCheckpoint). The checkpoint is of the work as it was when saved, however
+// long the name takes to enter. Cancelling skips the checkpoint, not the save.
+func (world *Client) nameCheckpoint(thumbnail []byte) {
+	saved := time.Now()
+	ready := world.startCheckpoint(thumbnail, saved)
+	label := saved.Format("2006-01-02 15:04:05")
+	input := LineEdit.New()
+	input.SetText(label)
+	input.SetPlaceholderText(label)
+	input.SelectAll()
+	dialog := ConfirmationDialog.New()
+	dialog.AsWindow().SetTitle("Name this checkpoint")
+	dialog.AsAcceptDialog().SetOkButtonText("Save")
+	dialog.SetCancelButtonText("Skip")
+	dialog.AsNode().AddChild(input.AsNode())
+	dialog.AsAcceptDialog().RegisterTextEnter(input)
+	dialog.AsAcceptDialog().OnConfirmed(func() {
+		label := strings.TrimSpace(input.Text())
+		dialog.AsNode().QueueFree()
+		go func() {
+			checkpoint, ok := <-ready
+			if !ok {
+				return
+			}
+			if label != "" {
+				checkpoint.Name = label
+			}
+			world.apiCheckpoint(checkpoint)
+		}()
+	})
+	dialog.AsAcceptDialog().OnCanceled(func() { dialog.AsNode().QueueFree() })
+	world.AsNode().AddChild(dialog.AsNode())
+	dialog.AsWindow().PopupCentered()
+	input.AsControl().GrabFocus()
+}
+
+// apiCheckpoint records a checkpoint of the work being edited, and uploads it
+// when cloud saves are available.
+func (world *Client) apiCheckpoint(checkpoint signalling.Checkpoint) {
+	name := base64.RawURLEncoding.EncodeToString(world.record[:])
+	existing, err := loadCheckpoints(name)
+	if err != nil {
+		Engine.Raise(err)
+	}
+	if err := storeCheckpoints(name, mergeCheckpoints(existing, []signalling.Checkpoint{checkpoint})); err != nil {
+		Engine.Raise(fmt.Errorf("failed to record checkpoint: %w", err))
+		return
+	}
+	if !UserState.Aviary.TogetherUntil.After(time.Now()) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := world.signalling.InsertCheckpoint(ctx, signalling.WorkID(name), checkpoint); err != nil {
+		// not lost: the next syncCheckpoints uploads it.
+		log.Println("aviary: failed to upload checkpoint:", err)
+	}
+}
+
+// checkpointPrefix opens the part of a work, as it was at the checkpoint (the
+// part's magic header included): from the local part, or the verified cached
+// copy (see cloudManifest.kept), when they hold enough of it, or else from the
+// cloud (when available).
+func checkpointPrefix(community signalling.API, cloud bool, name string, part signalling.PartID, offset int64) (io.ReadCloser, error) {
+	var paths []string
+	if part == openOutbox(name, UserState.Device).Part {
+		local := UserDataDir + "/saves/" + name + "/" + UserState.Device + ".mus3"
+		if stat, err := os.Stat(local); err == nil && stat.Size() >= offset {
+			paths = append(paths, local)
+		}
+	}
+	if path, size, ok := cloudManifestOf(name).kept(name, part); ok && size >= offset {
+		paths = append(paths, path)
+	}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.NewSectionReader(file, 0, offset), file}, nil
+	}
+	if !cloud {
+		return nil, fmt.Errorf("part %s of the checkpoint is not available offline", part)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	body, err := community.LookupSave(ctx, signalling.WorkID(name), part)
+	if err != nil {
+		cancel()
+		return nil, xray.New(err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(body, offset), closerFunc(func() error {
+		defer cancel()
+		return body.Close()
+	})}, nil
+}
+
+type closerFunc func() error
+
+func (fn closerFunc) Close() error { return fn() }
+
+// checkpointParts opens every part of a work as it was at the checkpoint, each
+// with its magic header skipped, in the order that they should be replayed: our
+// own part first, like OpenCloud.
+func checkpointParts(community signalling.API, cloud bool, work musical.WorkID, checkpoint signalling.Checkpoint) ([]io.Reader, []io.Closer, error) {
+	name := base64.RawURLEncoding.EncodeToString(work[:])
+	own := openOutbox(name, UserState.Device).Part
+	parts := slices.Sorted(func(yield func(signalling.PartID) bool) {
+		for part := range checkpoint.Offsets {
+			if !yield(part) {
+				return
+			}
+		}
+	})
+	slices.SortStableFunc(parts, func(a, b signalling.PartID) int {
+		switch {
+		case a == own && b != own:
+			return -1
+		case b == own && a != own:
+			return 1
+		}
+		return 0
+	})
+	var readers []io.Reader
+	var closers []io.Closer
+	closeAll := func() {
+		for _, closer := range closers {
+			closer.Close()
+		}
+	}
+	for _, part := range parts {
+		offset := checkpoint.Offsets[part]
+		if offset <= int64(len(musical.MagicHeader)) {
+			continue
+		}
+		prefix, err := checkpointPrefix(community, cloud, name, part, offset)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		closers = append(closers, prefix)
+		var header [len(musical.MagicHeader)]byte
+		if _, err := io.ReadFull(prefix, header[:]); err != nil {
+			closeAll()
+			return nil, nil, xray.New(err)
+		}
+		if string(header[:]) != musical.MagicHeader {
+			closeAll()
+			return nil, nil, xray.New(errors.New("invalid musical.Users3DScene file"))
+		}
+		readers = append(readers, prefix)
+	}
+	return readers, closers, nil
+}
+
+// OpenCheckpoint opens a work as it was at the checkpoint, replaying each of
+// its parts only up to the checkpoint's offset. It is read-only (writes fail
+// with errCheckpointReadOnly), since edits would land after everything
+// recorded since; fork the checkpoint to carry on from it.
+func OpenCheckpoint(community signalling.API, cloud bool, work musical.WorkID, checkpoint signalling.Checkpoint) (fs.File, error) {
+	readers, closers, err := checkpointParts(community, cloud, work, checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(len(musical.MagicHeader))
+	for _, offset := range checkpoint.Offsets {
+		size += max(offset-int64(len(musical.MagicHeader)), 0)
+	}
+	return &checkpointFile{
+		name:    checkpoint.Name,
+		size:    size,
+		time:    checkpoint.Time,
+		reader:  bufio.NewReaderSize(io.MultiReader(append([]io.Reader{strings.NewReader(musical.MagicHeader)}, readers...)...), decodeReadBuffer),
+		closers: closers,
+	}, nil
+}
+
+// showCheckpoint shows across the top of the UI that the work is open at the
+// checkpoint, and so can't be edited.
+func (ui *UI) showCheckpoint(checkpoint signalling.Checkpoint) {
+	banner := Label.New()
+	banner.SetText(fmt.Sprintf("Viewing checkpoint %q, read-only: edits are not saved. Shift+click it in the flight planner to fork an editable copy.", checkpoint.Name))
+	banner.SetHorizontalAlignment(GUI.HorizontalAlignmentCenter)
+	banner.AsControl().AddThemeFontSizeOverride("font_size", 18)
+	banner.AsControl().SetMouseFilter(Control.MouseFilterIgnore)
+	banner.AsControl().SetAnchorsPreset(Control.PresetTopWide)
+	banner.AsControl().SetOffsetTop(8)
+	ui.AsNode().AddChild(banner.AsNode())
+}
+
+// errCheckpointReadOnly is returned for edits made to a work opened at a
+// checkpoint.
+var errCheckpointReadOnly = errors.New("a work opened at a checkpoint is read-only, fork the checkpoint to edit it")
+
+// checkpointFile is the read-only view of a work at a checkpoint.
+type checkpointFile struct {
+	name    string
+	size    int64
+	time    time.Time
+	reader  io.Reader
+	closers []io.Closer
+}
+
+func (cf *checkpointFile) Stat() (fs.FileInfo, error) { return cf, nil }
+func (cf *checkpointFile) Name() string               { return cf.name }
+func (cf *checkpointFile) Size() int64                { return cf.size }
+func (cf *checkpointFile) Mode() fs.FileMode          { return 0444 }
+func (cf *checkpointFile) ModTime() time.Time         { return cf.time }
+func (cf *checkpointFile) IsDir() bool                { return false }
+func (cf *checkpointFile) Sys() any                   { return nil }
+
+func (cf *checkpointFile) Read(p []byte) (int, error) { return cf.reader.Read(p) }
+
+// Write refuses edits, see OpenCheckpoint.
+func (cf *checkpointFile) Write(p []byte) (int, error) { return 0, errCheckpointReadOnly }
+
+func (cf *checkpointFile) Close() error {
+	var errs []error
+	for _, closer := range cf.closers {
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// forkCheckpoint copies a work, as it was at the checkpoint, into a new work
+// that this device can carry on editing, returning the new work. Every part is
+// copied into this device's own part of the new work (which is uploaded like
+// any other once cloud saves are available), see musical.Fork, reattributing
+// them to this device if rewrite is true, and the checkpoint's thumbnail
+// becomes the new work's snapshot.
+func forkCheckpoint(community signalling.API, cloud bool, work musical.WorkID, checkpoint signalling.Checkpoint, rewrite bool) (musical.WorkID, error) {
+	var fork musical.WorkID
+	if _, err := rand.Read(fork[:]); err != nil {
+		return fork, xray.New(err)
+	}
+	readers, closers, err := checkpointParts(community, cloud, work, checkpoint)
+	if err != nil {
+		return fork, err
+	}
+	defer func() {
+		for _, closer := range closers {
+			closer.Close()
+		}
+	}()
+	name := base64.RawURLEncoding.EncodeToString(fork[:])
+	if err := os.MkdirAll(UserDataDir+"/saves/"+name, 0777); err != nil {
+		return fork, xray.New(err)
+	}
+	path := UserDataDir + "/saves/" + name + "/" + UserState.Device + ".mus3"
+	tmp, err := os.CreateTemp(UserDataDir+"/saves/"+name, UserState.Device+".*.tmp")
+	if err != nil {
+		return fork, xray.New(err)
+	}
+	defer os.Remove(tmp.Name())
+	for i, reader := range readers {
+		readers[i] = io.MultiReader(strings.NewReader(musical.MagicHeader), reader)
+	}
+	if err := musical.Fork(tmp, work, fork, deviceAuthor(UserState.Device), rewrite, readers...); err != nil {
+		tmp.Close()
+		return fork, err
+	}
+	if err := tmp.Close(); err != nil {
+		return fork, xray.New(err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fork, xray.New(err)
+	}
+	// Upload the copy on the next open, as it was never written through
+	// CloudBacked.
+	if _, err := openOutbox(name, UserState.Device).wrote(); err != nil {
+		Engine.Raise(err)
+	}
+	if len(checkpoint.Thumbnail) > 0 {
+		if err := os.MkdirAll(UserDataDir+"/snaps", 0777); err == nil {
+			if err := os.WriteFile(UserDataDir+"/snaps/"+name+".png", checkpoint.Thumbnail, 0666); err != nil {
+				Engine.Raise(xray.New(err))
+			}
+		}
+	}
+	return fork, nil
+}
@@ -227,6 +227,10 @@ type Client struct {
 	record musical.WorkID
 	space  musical.UsersSpace3D
 
+	// checkpoint, if set, opens the record as it was at this checkpoint,
+	// see OpenCheckpoint.
+	checkpoint *signalling.Checkpoint
+
 	// lastTiming is a strictly-increasing per-client counter used to stamp every
 	// committed Sculpt's Timing, giving each stroke a stable (Author, Timing)
 	// identity that a Revert sculpt references for undo/redo. Seeded from the
@@ -239,6 +243,14 @@ type Client struct {
 
 	clients chan musical.Networking
 
+	// hosting records that a join code has been handed out, so that sharing
+	// again rotates it (see apiHost), access holds the room password the host
+	// checks joiners against (see musical.Networking.Access), and limit the
+	// most joiners it admits (see musical.Networking.Limit).
+	hosting atomic.Bool
+	access  atomic.Pointer[string]
+	limit   atomic.Int64
+
 	clientReady sync.WaitGroup
 
 	load_last_save bool
@@ -528,6 +540,13 @@ var UserState struct {
 	// authors publishing under a hidden license are filtered out of the
 	// design explorer. Empty means everything is shown.
 	HiddenLicenses []string
+
+	// RoomPassword, when set, is the numeric room password that joiners
+	// must enter after the join code before they are assigned an author,
+	// and MaxJoiners (when non-zero) limits how many may be admitted at once.
+	// Both are set from the Settings menu and apply to the next code shared.
+	RoomPassword string
+	MaxJoiners   int
 }
 
 // UserDataDir is the value of OS.GetUserDataDir() captured once early on the
@@ -554,6 +573,15 @@ func NewClientLoading(record musical.WorkID) *Client {
 	return client
 }
 
+// NewClientAtCheckpoint opens a work as it was at one of its checkpoints.
+func NewClientAtCheckpoint(record musical.WorkID, checkpoint signalling.Checkpoint) *Client {
+	var client = NewClient()
+	client.record = record
+	client.checkpoint = &checkpoint
+	client.load_last_save = false
+	return client
+}
+
 func (world *Client) saveUserState() {
 	userfile := FileAccess.Open(OS.GetConfigDir()+"/user.json", FileAccess.Write)
 	buf, err := json.Marshal(UserState)
@@ -581,18 +609,29 @@ func (world *Client) loadUserState() {
 	}
 }
 
-func (world *Client) apiJoin(code networking.Code) {
+// apiJoin joins the room with the given code, presenting the room password (if
+// the host requires one).
+func (world *Client) apiJoin(code networking.Code, password string) {
 	world.clientReady.Wait()
 	err := world.network.Join(code, world.updates)
+	world.reportConnectivity(&world.network, err == nil)
 	if err != nil {
 		Engine.Raise(fmt.Errorf("failed to join room %s: %w", code, err))
 		return
 	}
-	space, err := musical.Join(musical.Networking{
+	// Follow the host's successor nominations, so the session carries on (with
+	// us resuming as host, or rejoined to whoever does) if the host leaves.
+	space, err := musical.JoinHandover(musical.Networking{
 		Instructions: networkingVia{&world.network, world.updates},
 		MediaUploads: stubbedNetwork{},
 		ErrorReports: musicalImpl{world},
-	}, musical.WorkID{}, musicalImpl{world})
+		Access:       password,
+	}, musicalImpl{world}, musical.Handover{
+		Storage: newSessionStorage(),
+		Standby: world.apiStandby,
+		Rejoin:  world.apiRejoin,
+		Server:  strings.TrimSpace("Aviary " + version),
+	})
 	if err != nil {
 		Engine.Raise(fmt.Errorf("failed to join musical room %s: %w", code, err))
 		return
@@ -604,20 +643,58 @@ func (world *Client) apiJoin(code networking.Code) {
 	}))
 }
 
+// reportConnectivity shows the diagnosis of a connection on the online status
+// indicator, so that a failing join says why it failed.
+func (world *Client) reportConnectivity(network *networking.Connectivity, online bool) {
+	reason := network.Stats().Diagnosis()
+	Callable.Defer(Callable.New(func() {
+		if world.ui != nil && world.ui.CloudControl != nil {
+			world.ui.CloudControl.set_online_status_indicator(online)
+			world.ui.CloudControl.set_online_status_reason(reason)
+		}
+	}))
+}
+
+// apiHost shares the scene under a join code, applying the room password and
+// joiner limit from UserState. Once hosting, sharing again rotates to a fresh
+// code, so that the previous one no longer admits anyone.
 func (world *Client) apiHost() (networking.Code, error) {
+	password := UserState.RoomPassword
+	world.access.Store(&password)
+	world.limit.Store(int64(UserState.MaxJoiners))
+	if world.hosting.Load() {
+		code, err := world.network.Rotate()
+		if err != nil {
+			return "", fmt.Errorf("failed to rotate room code: %w", err)
+		}
+		return code, nil
+	}
 	code, err := world.network.Host(world.updates, func(client networking.Client) {
+		var access string
+		if password := world.access.Load(); password != nil {
+			access = *password
+		}
 		world.clients <- musical.Networking{
 			Instructions: networkingFor{client},
 			MediaUploads: stubbedNetwork{},
 			ErrorReports: musicalImpl{world},
+			Access:       access,
+			Limit:        int(world.limit.Load()),
 		}
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to host room: %w", err)
 	}
+	world.hosting.Store(true)
 	return code, nil
 }
 
+// apiRevoke stops admitting joiners with the shared join code; those already
+// joined stay connected.
+func (world *Client) apiRevoke() {
+	world.network.Revoke()
+}
+
 // Ready does a bunch of dependency injection and setup.
 func (world *Client) Ready() {
 	profMark("Client.Ready: begin")
@@ -657,6 +734,11 @@ func (world *Client) Ready() {
 	}
 
 	world.signalling = api.Import[signalling.API](rest.API, SignallingHost, rest.Header("Authorization", "Bearer "+UserState.Secret))
+	// AVIARY_SIGNALLING_DIR keeps cloud saves and snapshots in a local
+	// directory instead, to exercise them offline.
+	if dir := os.Getenv("AVIARY_SIGNALLING_DIR"); dir != "" {
+		world.signalling = signalling.Local(dir, UserState.Aviary)
+	}
 
 	if !world.joining {
 		clients_iter := func(yield func(musical.Networking) bool) {
@@ -2233,6 +2315,20 @@ func (world *Client) UnhandledInput(event InputEvent.Instance) {
 		}
 		if event.AsInputEvent().IsPressed() && event.Keycode() == Input.KeyS && Input.IsKeyPressed(Input.KeyCtrl) && !event.AsInputEvent().IsEcho() {
 			AnimateTheSceneBeingSaved(world, world.record)
+			// Each save is also a checkpoint, named after the time, or
+			// with Shift, as the user names it (but a work opened at a
+			// checkpoint isn't saved, see OpenCheckpoint).
+			if world.checkpoint == nil {
+				thumbnail := Viewport.Get(world.AsNode()).GetTexture().AsTexture2D().GetImage()
+				if thumbnail.GetWidth() > 0 {
+					thumbnail.Resize(256, max(1, 256*thumbnail.GetHeight()/thumbnail.GetWidth()))
+				}
+				if Input.IsKeyPressed(Input.KeyShift) {
+					world.nameCheckpoint(thumbnail.SavePngToBuffer())
+				} else {
+					world.takeCheckpoint(thumbnail.SavePngToBuffer())
+				}
+			}
 			go func() {
 				name := base64.RawURLEncoding.EncodeToString(world.record[:])
 				file, err := os.Open(UserDataDir + "/snaps/" + name + ".png")
@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"iter"
+	"os"
+	"sync"
+
+	"the.quetzal.community/aviary/internal/musical"
+	"the.quetzal.community/aviary/internal/networking"
+)
+
+// sessionStorage records a joined session so that, should this client be
+// nominated as the host's successor, it can resume hosting with the full log
+// (see musical.Handover). It lives apart from the user's own save parts under
+// UserDataDir/sessions, since the log is the host's work, not ours. The first
+// Open of a work in this process starts a fresh log; later opens (resuming, and
+// catching up the joiners that rejoin us) read it back.
+type sessionStorage struct {
+	mutex  *sync.Mutex
+	opened map[musical.WorkID]bool
+}
+
+func newSessionStorage() sessionStorage {
+	return sessionStorage{
+		mutex:  new(sync.Mutex),
+		opened: make(map[musical.WorkID]bool),
+	}
+}
+
+func (ss sessionStorage) Open(work musical.WorkID) (fs.File, error) {
+	name := base64.RawURLEncoding.EncodeToString(work[:])
+	if err := os.MkdirAll(UserDataDir+"/sessions", 0777); err != nil {
+		return nil, err
+	}
+	ss.mutex.Lock()
+	flag := os.O_RDWR | os.O_CREATE
+	if !ss.opened[work] {
+		flag |= os.O_TRUNC
+		ss.opened[work] = true
+	}
+	ss.mutex.Unlock()
+	return os.OpenFile(UserDataDir+"/sessions/"+name+".mus3", flag, 0666)
+}
+
+// apiStandby hosts a fresh join code for when this client is nominated as the
+// successor of the session it joined. Joiners only arrive on it once the host
+// has been lost and they rejoin us.
+func (world *Client) apiStandby() (string, iter.Seq[musical.Networking], error) {
+	network := &networking.Connectivity{
+		Authentication: world.network.Authentication,
+		Raise:          world.network.Raise,
+		Print:          world.network.Print,
+	}
+	clients := make(chan musical.Networking)
+	code, err := network.Host(nil, func(client networking.Client) {
+		clients <- musical.Networking{
+			Instructions: networkingFor{client},
+			MediaUploads: stubbedNetwork{},
+			ErrorReports: musicalImpl{world},
+		}
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to stand by as successor: %w", err)
+	}
+	return string(code), func(yield func(musical.Networking) bool) {
+		for client := range clients {
+			if !yield(client) {
+				return
+			}
+		}
+	}, nil
+}
+
+// apiRejoin joins the successor's join code, after the host has been lost.
+func (world *Client) apiRejoin(code string) (musical.Networking, error) {
+	network := &networking.Connectivity{
+		Authentication: world.network.Authentication,
+		Raise:          world.network.Raise,
+		Print:          world.network.Print,
+	}
+	updates := make(chan []byte, 20)
+	if err := network.Join(networking.Code(code), updates); err != nil {
+		return musical.Networking{}, fmt.Errorf("failed to rejoin room %s: %w", code, err)
+	}
+	return musical.Networking{
+		Instructions: networkingVia{network, updates},
+		MediaUploads: stubbedNetwork{},
+		ErrorReports: musicalImpl{world},
+	}, nil
+}
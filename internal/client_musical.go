@@ -7,6 +7,7 @@ import (
 	"io/fs"
 	"os"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -115,6 +116,9 @@ func (world musicalImpl) Open(space musical.WorkID) (fs.File, error) {
 
 func (world musicalImpl) openStorage(space musical.WorkID) (fs.File, error) {
 	name := base64.RawURLEncoding.EncodeToString(space[:])
+	if world.checkpoint != nil && space == world.record {
+		return OpenCheckpoint(world.signalling, UserState.Aviary.TogetherUntil.After(time.Now()), space, *world.checkpoint)
+	}
 	if UserState.Aviary.TogetherUntil.After(time.Now()) {
 		fmt.Println("opening cloud save for", name)
 		return OpenCloud(world.signalling, space)
@@ -122,11 +126,99 @@ func (world musicalImpl) openStorage(space musical.WorkID) (fs.File, error) {
 	if err := os.MkdirAll(UserDataDir+"/saves/"+name, 0777); err != nil {
 		return nil, err
 	}
-	file, err := os.OpenFile(UserDataDir+"/saves/"+name+"/"+UserState.Device+".mus3", os.O_RDWR|os.O_CREATE, 0666)
+	file, journal, err := openDevicePart(name)
 	if err != nil {
 		return nil, err
 	}
-	return file, nil
+	if journal == nil {
+		return readOnlyFile{file}, nil
+	}
+	return &journalFile{File: file, name: name, journal: journal}, nil
+}
+
+// journalSyncDelay bounds how long a committed record can sit in the page
+// cache before it is synced to disk, so that bursts of records (a sculpt
+// stroke, a paste) share a single fsync.
+const journalSyncDelay = time.Second
+
+// deviceParts are this device's parts of the works that are open for writing,
+// by name, so that each is only ever appended to through a single journal, and
+// only recovered (see musical.Recover) the first time it is opened.
+var deviceParts = struct {
+	sync.Mutex
+	journals  map[string]*musical.Journal
+	recovered map[string]bool
+}{
+	journals:  make(map[string]*musical.Journal),
+	recovered: make(map[string]bool),
+}
+
+// openDevicePart opens this device's part of the work, for records to be
+// appended to it through the returned journal, truncating any partial record
+// left at its end by a crash, the first time it is opened by this process.
+// If the part is already open for writing, it is opened read-only instead (to
+// catch up a joiner), without a journal, as only the first open may repair
+// or append to it.
+func openDevicePart(name string) (*os.File, *musical.Journal, error) {
+	path := UserDataDir + "/saves/" + name + "/" + UserState.Device + ".mus3"
+	deviceParts.Lock()
+	defer deviceParts.Unlock()
+	if deviceParts.journals[name] != nil {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return file, nil, nil
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, nil, err
+	}
+	var size int64
+	if deviceParts.recovered[name] {
+		stat, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		size = stat.Size()
+	} else {
+		if size, err = musical.Recover(file); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		deviceParts.recovered[name] = true
+	}
+	journal := musical.NewJournal(file, size, journalSyncDelay)
+	deviceParts.journals[name] = journal
+	return file, journal, nil
+}
+
+// closeDevicePart closes the journal of this device's part of the work named
+// name, so that it can be opened for writing again.
+func closeDevicePart(name string, journal *musical.Journal) error {
+	deviceParts.Lock()
+	if deviceParts.journals[name] == journal {
+		delete(deviceParts.journals, name)
+	}
+	deviceParts.Unlock()
+	return journal.Close()
+}
+
+// journalFile is a device part that appends records through its journal.
+type journalFile struct {
+	*os.File
+	name    string
+	journal *musical.Journal
+}
+
+func (jf *journalFile) Write(p []byte) (int, error) { return jf.journal.Write(p) }
+func (jf *journalFile) Close() error                { return closeDevicePart(jf.name, jf.journal) }
+
+// readOnlyFile hides any io.Writer of the file, so that musical storage does
+// not persist to it.
+type readOnlyFile struct {
+	fs.File
 }
 
 // trackLoadProgress wraps the initial-replay .mus3 file so the loading screen
@@ -217,7 +309,17 @@ func (world musicalImpl) Member(req musical.Member) error {
 	return nil
 }
 
-func (world musicalImpl) Upload(file musical.Upload) error { return nil }
+// Upload keeps the uploaded file, when there is one (it isn't recorded in the
+// log, nor sent to other members), see saveUpload.
+func (world musicalImpl) Upload(file musical.Upload) error {
+	if file.Upload == nil {
+		return nil
+	}
+	if err := saveUpload(world.record, file); err != nil {
+		Engine.Raise(err)
+	}
+	return nil
+}
 func (world musicalImpl) Sculpt(brush musical.Sculpt) error {
 	world.enqueue(func() {
 		defer timeIn(&bucketSculpt)()
@@ -297,6 +399,7 @@ func (client *Client) instantiateDesign(design musical.Design) Node3D.Instance {
 }
 
 func (world musicalImpl) Import(uri musical.Import) error {
+	libraryPrefetch.Import(uri)
 	world.enqueue(func() {
 		defer timeIn(&bucketImport)()
 		if _, ok := world.loaded[uri.Import]; ok {
@@ -366,6 +469,9 @@ func (world musicalImpl) Import(uri musical.Import) error {
 	return nil
 }
 func (world musicalImpl) Change(con musical.Change) error {
+	if con.Design != (musical.Design{}) {
+		libraryPrefetch.Placed(con.Design)
+	}
 	world.enqueue(func() {
 		defer timeIn(&bucketChange)()
 		world.entity_ids[con.Entity.Author] = max(world.entity_ids[con.Entity.Author], con.Entity.Number)
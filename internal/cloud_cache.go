@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"graphics.gd/classdb/Engine"
+	"runtime.link/api/xray"
+	"the.quetzal.community/aviary/internal/ice/signalling"
+)
+
+// cloudManifest records the other devices' parts of a work that are cached in
+// UserDataDir/saves/<work>/cloud, so that a part is only downloaded again once
+// the cloud reports it changed, and a cached part is verified before use. Parts
+// are downloaded to a temporary file, and only renamed into place (and added to
+// the manifest) once complete, so an interrupted download never leaves a
+// half-written part behind.
+type cloudManifest struct {
+	mutex sync.Mutex
+	dir   string
+
+	Parts map[signalling.PartID]cachedPart `json:"parts"`
+}
+
+// cachedPart describes a cached cloud part.
+type cachedPart struct {
+	Size int64     `json:"size"` // as reported by the cloud.
+	Time time.Time `json:"time"` // as reported by the cloud.
+	Hash string    `json:"hash"` // SHA-256 of the cached file.
+
+	// Deleted marks a part deleted from the cloud (see reclaimStale), whose
+	// cached copy is kept for the checkpoints made before it was.
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// cloudCache is the canonical path of a cached cloud part.
+func cloudCache(name string, part signalling.PartID) string {
+	return UserDataDir + "/saves/" + name + "/cloud/" + string(part) + ".mus3"
+}
+
+// cloudManifests are the manifests of the works opened by this process, by
+// name. There is one per work, shared by every open of the work (like
+// uploaderOf), so that none of them saves over the entries of another.
+var cloudManifests = struct {
+	sync.Mutex
+	works map[string]*cloudManifest
+}{works: make(map[string]*cloudManifest)}
+
+// cloudManifestOf returns the manifest of the parts cached for the work named
+// name. The first time it is asked for by this process, it clears away any
+// downloads that were interrupted (none can be under way yet), along with the
+// copy of a part named after this device that was cached beside its own part
+// before there was a cloud directory (see removeLegacyCaches).
+func cloudManifestOf(name string) *cloudManifest {
+	cloudManifests.Lock()
+	defer cloudManifests.Unlock()
+	if m, ok := cloudManifests.works[name]; ok {
+		return m
+	}
+	dir := UserDataDir + "/saves/" + name + "/cloud"
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		Engine.Raise(xray.New(err))
+	}
+	if stale, err := filepath.Glob(dir + "/*.tmp"); err == nil {
+		for _, path := range stale {
+			os.Remove(path)
+		}
+	}
+	os.Remove(UserDataDir + "/saves/" + name + "/" + UserState.Device + ".cloud.mus3")
+	m := loadCloudManifest(dir)
+	cloudManifests.works[name] = m
+	return m
+}
+
+// removeLegacyCaches removes the copies of the other devices' parts that were
+// cached beside this device's own part before there was a cloud directory, so
+// that they are neither left behind, nor taken for parts of this device's (see
+// archive.LocalParts).
+func removeLegacyCaches(name string, parts map[signalling.PartID]signalling.Part) {
+	for part := range parts {
+		if string(part) == UserState.Device {
+			continue // this device's own part.
+		}
+		err := os.Remove(UserDataDir + "/saves/" + name + "/" + string(part) + ".mus3")
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			Engine.Raise(xray.New(err))
+		}
+	}
+}
+
+// loadCloudManifest loads the manifest in dir.
+func loadCloudManifest(dir string) *cloudManifest {
+	m := &cloudManifest{
+		dir:   dir,
+		Parts: make(map[signalling.PartID]cachedPart),
+	}
+	data, err := os.ReadFile(m.dir + "/manifest.json")
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			Engine.Raise(xray.New(err))
+		}
+		return m
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		Engine.Raise(xray.New(err))
+	}
+	if m.Parts == nil {
+		m.Parts = make(map[signalling.PartID]cachedPart)
+	}
+	return m
+}
+
+// save persists the manifest, the mutex must be held.
+func (m *cloudManifest) save() error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return xray.New(err)
+	}
+	tmp := m.dir + "/manifest.json.tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return xray.New(err)
+	}
+	if err := os.Rename(tmp, m.dir+"/manifest.json"); err != nil {
+		return xray.New(err)
+	}
+	return nil
+}
+
+// cached returns the path of the cached copy of the part, if it is still the
+// version the cloud reports, and it is intact. Otherwise, the part is dropped
+// from the manifest, to be downloaded again.
+func (m *cloudManifest) cached(name string, part signalling.PartID, cloud signalling.Part) (string, bool) {
+	m.mutex.Lock()
+	entry, ok := m.Parts[part]
+	m.mutex.Unlock()
+	if !ok || entry.Deleted || entry.Size != cloud.Size || !entry.Time.Equal(cloud.Time) {
+		return "", false
+	}
+	if path, ok := entry.intact(name, part); ok {
+		return path, true
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.Parts, part)
+	if err := m.save(); err != nil {
+		Engine.Raise(err)
+	}
+	return "", false
+}
+
+// commit moves a completed download into place as the cached copy of the part.
+func (m *cloudManifest) commit(name string, part signalling.PartID, tmp string, entry cachedPart) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := os.Rename(tmp, cloudCache(name, part)); err != nil {
+		os.Remove(tmp)
+		return xray.New(err)
+	}
+	m.Parts[part] = entry
+	return m.save()
+}
+
+// kept returns the path and size of the cached copy of the part, whatever the
+// cloud now holds of it (as a checkpoint needs), if it is intact.
+func (m *cloudManifest) kept(name string, part signalling.PartID) (string, int64, bool) {
+	m.mutex.Lock()
+	entry, ok := m.Parts[part]
+	m.mutex.Unlock()
+	if !ok {
+		return "", 0, false
+	}
+	path, ok := entry.intact(name, part)
+	return path, entry.Size, ok
+}
+
+// deleted marks the part as deleted from the cloud, keeping its cached copy.
+func (m *cloudManifest) deleted(part signalling.PartID) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	entry, ok := m.Parts[part]
+	if !ok || entry.Deleted {
+		return nil
+	}
+	entry.Deleted = true
+	m.Parts[part] = entry
+	return m.save()
+}
+
+// intact returns the path of the cached copy of the part, if it is the one
+// that the entry describes.
+func (entry cachedPart) intact(name string, part signalling.PartID) (string, bool) {
+	path := cloudCache(name, part)
+	hash, size, err := hashFile(path)
+	return path, err == nil && hash == entry.Hash && size == entry.Size
+}
+
+// hashFile returns the SHA-256 and size of the file at path.
+func hashFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
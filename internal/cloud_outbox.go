@@ -0,0 +1,230 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"graphics.gd/classdb/Engine"
+	"runtime.link/api/xray"
+	"the.quetzal.community/aviary/internal/ice/signalling"
+)
+
+// cloudOutbox durably records the upload state of this device's part of a work,
+// in UserDataDir/saves/<work>/<device>.outbox, so that an upload that fails is
+// retried (with backoff, across restarts) and so that a cloud part that changed
+// behind our back (e.g. the same device restored on two machines) is noticed
+// instead of overwritten.
+type cloudOutbox struct {
+	mutex sync.Mutex
+	path  string
+
+	Part      signalling.PartID `json:"part"`                // cloud part the local part uploads to.
+	Pending   bool              `json:"pending,omitempty"`   // the local part has writes that are not uploaded yet.
+	Attempts  int               `json:"attempts,omitempty"`  // failed uploads since the last one that succeeded.
+	Retry     time.Time         `json:"retry,omitzero"`      // earliest time to retry a failed upload.
+	Uploading int64             `json:"uploading,omitempty"` // size being uploaded, in case we exit before it is acknowledged.
+	Size      int64             `json:"size,omitempty"`      // size of the cloud part as last acknowledged, 0 if unknown.
+	Time      time.Time         `json:"time,omitzero"`       // time of the cloud part as last acknowledged.
+
+	// Merged lists the size of other devices' parts whose records have been
+	// merged into the local part (see reclaimStale), until they are deleted
+	// from the cloud, so that they are neither read nor merged twice.
+	Merged map[signalling.PartID]int64 `json:"merged,omitempty"`
+}
+
+// cloudRetryBackoff is the delay before retrying a failed upload, doubling with
+// each attempt up to cloudRetryMax.
+const (
+	cloudRetryBackoff = time.Minute
+	cloudRetryMax     = time.Hour
+)
+
+// outboxes are the outboxes loaded by this process, by path, so that there is
+// only one for each part.
+var outboxes = struct {
+	sync.Mutex
+	loaded map[string]*cloudOutbox
+}{loaded: make(map[string]*cloudOutbox)}
+
+// openOutbox returns the outbox for the local part of the work named name, which
+// uploads to the device's own part until a conflict forks it, loading it the
+// first time it is opened by this process.
+func openOutbox(name, device string) *cloudOutbox {
+	path := UserDataDir + "/saves/" + name + "/" + device + ".outbox"
+	outboxes.Lock()
+	defer outboxes.Unlock()
+	if box, ok := outboxes.loaded[path]; ok {
+		return box
+	}
+	box := &cloudOutbox{
+		path: path,
+		Part: signalling.PartID(device),
+	}
+	outboxes.loaded[path] = box
+	data, err := os.ReadFile(box.path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			Engine.Raise(xray.New(err))
+		}
+		return box
+	}
+	if err := json.Unmarshal(data, box); err != nil {
+		Engine.Raise(xray.New(err))
+	}
+	if box.Part == "" {
+		box.Part = signalling.PartID(device)
+	}
+	return box
+}
+
+// save persists the outbox, the mutex must be held.
+func (box *cloudOutbox) save() error {
+	data, err := json.Marshal(box)
+	if err != nil {
+		return xray.New(err)
+	}
+	tmp := box.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return xray.New(err)
+	}
+	if err := os.Rename(tmp, box.path); err != nil {
+		return xray.New(err)
+	}
+	return nil
+}
+
+// target returns the part to upload to, and the size of the cloud part that
+// has been acknowledged (0 if unknown, in which case the whole part must be
+// uploaded).
+func (box *cloudOutbox) target() (signalling.PartID, int64) {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	return box.Part, box.Size
+}
+
+// uploading records that size bytes are about to be uploaded, so that should
+// we exit before acknowledging them, the cloud part growing to that size is
+// recognised as our own doing.
+func (box *cloudOutbox) uploading(size int64) error {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	box.Uploading = size
+	return box.save()
+}
+
+// wrote records that the local part has writes to upload, reporting whether
+// they were already pending.
+func (box *cloudOutbox) wrote() (bool, error) {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	if box.Pending {
+		return true, nil
+	}
+	box.Pending = true
+	return false, box.save()
+}
+
+// pending reports whether there are writes to upload, and how long to wait
+// before trying.
+func (box *cloudOutbox) pending() (bool, time.Duration) {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	return box.Pending, max(time.Until(box.Retry), 0)
+}
+
+// acknowledged records a successful upload of size bytes, which the cloud
+// reports as the part's time (zero if it could not be looked up).
+func (box *cloudOutbox) acknowledged(size int64, at time.Time) error {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	box.Pending = false
+	box.Attempts = 0
+	box.Retry = time.Time{}
+	box.Uploading = 0
+	box.Size = size
+	box.Time = at
+	return box.save()
+}
+
+// failed records a failed upload, returning how long to wait before retrying
+// and whether this is the first failure since the last successful upload.
+func (box *cloudOutbox) failed() (time.Duration, bool, error) {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	box.Attempts++
+	delay := min(cloudRetryBackoff<<min(box.Attempts-1, 16), cloudRetryMax)
+	box.Retry = time.Now().Add(delay)
+	return delay, box.Attempts == 1, box.save()
+}
+
+// diverged reports whether the cloud's copy of our part differs from what we
+// last uploaded to it, meaning that someone else has written to it. A part we
+// never uploaded to, or whose last upload is yet to be acknowledged, is adopted
+// as ours.
+func (box *cloudOutbox) diverged(cloud signalling.Part) bool {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	switch {
+	case box.Size == 0:
+	case box.Uploading != 0 && cloud.Size == box.Uploading:
+	case cloud.Size == box.Size && (box.Time.IsZero() || cloud.Time.Equal(box.Time)):
+	default:
+		return true
+	}
+	box.Size, box.Time = cloud.Size, cloud.Time
+	return false
+}
+
+// merging records that the records of the part, size bytes long, have been
+// merged into the local part, which has writes to upload.
+func (box *cloudOutbox) merging(part signalling.PartID, size int64) error {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	if box.Merged == nil {
+		box.Merged = make(map[signalling.PartID]int64)
+	}
+	box.Merged[part] = size
+	box.Pending = true
+	return box.save()
+}
+
+// merged reports whether the cloud's copy of the part has already been merged
+// into the local part.
+func (box *cloudOutbox) merged(part signalling.PartID, cloud signalling.Part) bool {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	size, ok := box.Merged[part]
+	return ok && size == cloud.Size
+}
+
+// deleted records that a merged part has been deleted from the cloud.
+func (box *cloudOutbox) deleted(part signalling.PartID) error {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	if _, ok := box.Merged[part]; !ok {
+		return nil
+	}
+	delete(box.Merged, part)
+	return box.save()
+}
+
+// fork moves our uploads to a fresh part, so that the diverged one is kept
+// alongside it rather than overwritten. The local part is uploaded in full.
+func (box *cloudOutbox) fork(device string) (signalling.PartID, error) {
+	var suffix [4]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return "", xray.New(err)
+	}
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	box.Part = signalling.PartID(device + "~" + hex.EncodeToString(suffix[:]))
+	box.Pending = true
+	box.Size, box.Time, box.Uploading = 0, time.Time{}, 0
+	return box.Part, box.save()
+}
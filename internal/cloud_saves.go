@@ -3,11 +3,14 @@ package internal
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"io"
 	"io/fs"
 	"log"
+	"net/http"
 	"os"
 	"strings"
 	"sync"
@@ -21,17 +24,51 @@ import (
 )
 
 type CloudBacked struct {
-	name string
-	size int64
+	*cloudUploader
 
-	lock sync.Mutex
-	sync atomic.Bool
+	size int64
 
 	reader io.Reader
 	writer io.Writer
 	closer func() error
+}
+
+// cloudUploader uploads this device's part of a work, keeping track of it in
+// the outbox. There is one per work (see uploaderOf), shared by every open of
+// the work, so that only one upload of the part is ever scheduled.
+type cloudUploader struct {
+	name string
+
+	lock sync.Mutex
+	sync atomic.Bool
 
 	community signalling.API
+
+	device string       // device whose local part this is
+	outbox *cloudOutbox // upload state of the local part
+}
+
+// cloudUploaders are the uploaders of the works opened by this process, by name.
+var cloudUploaders = struct {
+	sync.Mutex
+	works map[string]*cloudUploader
+}{works: make(map[string]*cloudUploader)}
+
+// uploaderOf returns the uploader of this device's part of the work named name.
+func uploaderOf(community signalling.API, name string) *cloudUploader {
+	cloudUploaders.Lock()
+	defer cloudUploaders.Unlock()
+	uploader, ok := cloudUploaders.works[name]
+	if !ok {
+		uploader = &cloudUploader{
+			name:      name,
+			community: community,
+			device:    UserState.Device,
+			outbox:    openOutbox(name, UserState.Device),
+		}
+		cloudUploaders.works[name] = uploader
+	}
+	return uploader
 }
 
 var ShuttingDown = make(chan struct{})
@@ -44,12 +81,26 @@ func OpenCloud(community signalling.API, work musical.WorkID) (fs.File, error) {
 		return nil, err
 	}
 
-	file, err := os.OpenFile(UserDataDir+"/saves/"+name+"/"+UserState.Device+".mus3", os.O_RDWR|os.O_CREATE, 0666)
+	file, journal, err := openDevicePart(name)
 	if err != nil {
 		return nil, err
 	}
+	release := func() {
+		if journal != nil {
+			closeDevicePart(name, journal)
+		} else {
+			file.Close()
+		}
+	}
 	var size int64
-	if stat, err := file.Stat(); err == nil {
+	if journal != nil {
+		size = journal.Size()
+	} else {
+		stat, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, xray.New(err)
+		}
 		size = stat.Size()
 	}
 
@@ -63,14 +114,14 @@ func OpenCloud(community signalling.API, work musical.WorkID) (fs.File, error) {
 	if size > 0 {
 		var header = make([]byte, len(musical.MagicHeader))
 		if _, err := io.ReadFull(file, header); err != nil {
-			file.Close()
+			release()
 			return nil, xray.New(err)
 		}
 		if string(header) != musical.MagicHeader {
-			file.Close()
+			release()
 			return nil, xray.New(errors.New("invalid musical.Users3DScene file"))
 		}
-	} else {
+	} else if journal != nil {
 		// Fresh local part: persist the magic header NOW so the on-disk file is a
 		// valid musical.Users3DScene from its first byte. The reader below prepends
 		// a *synthetic* header for the decoder, but the file itself must still
@@ -81,37 +132,60 @@ func OpenCloud(community signalling.API, work musical.WorkID) (fs.File, error) {
 		// server goroutine (network.go server.run) and hard-freezes the next edit.
 		// newStorage's own empty-file header write never fires here because
 		// CloudBacked.Size() reports len(MagicHeader)+0, never 0.
-		if _, err := file.Write([]byte(musical.MagicHeader)); err != nil {
-			file.Close()
+		if _, err := journal.Write([]byte(musical.MagicHeader)); err != nil {
+			release()
+			return nil, xray.New(err)
+		}
+		// the journal writes at its end, skip past the header for the reader.
+		if _, err := file.Seek(int64(len(musical.MagicHeader)), io.SeekStart); err != nil {
+			release()
 			return nil, xray.New(err)
 		}
 	}
+	if journal == nil {
+		return openCloudReplica(community, name, file, size), nil
+	}
 
-	lazy := &lazyCloudReader{community: community, work: name}
-
-	return &CloudBacked{
-		name: name,
+	fw := &CloudBacked{
+		cloudUploader: uploaderOf(community, name),
 		// Bytes known up front = synthetic header + local part; cloud parts are
 		// discovered lazily, so the loading bar fills on the local part and any
 		// cloud catch-up (usually small / already cached) streams in after.
 		size:   int64(len(musical.MagicHeader)) + size,
-		writer: file,
+		writer: journal,
 		// Buffer the local part: the decoder reads record-by-record via
 		// binary.Read (tiny reads), so an unbuffered file turns ~65k records
 		// into ~65k read syscalls. A 64K buffer collapses that to ~20 reads.
-		// The buffer wraps only the READER side; the writer (line above) keeps
-		// the raw *os.File, and saves only append AFTER the decode has read the
-		// part to EOF (file offset = EOF), so buffered read-ahead can't corrupt
-		// a later append. The bufio EOFs exactly at the local part's end, so the
-		// MultiReader still advances to `lazy` only then — the cloud round-trip
-		// stays deferred (unlike buffering the whole MultiReader, which could
+		// The buffer wraps only the READER side; the writer (line above) is the
+		// journal, which appends at the part's tracked end rather than at the
+		// file offset, so buffered read-ahead can't corrupt a later append.
+		// The bufio EOFs exactly at the local part's end, so the MultiReader
+		// still advances to `lazy` only then — the cloud round-trip stays
+		// deferred (unlike buffering the whole MultiReader, which could
 		// read across the boundary and trigger the fetch during the load start).
-		reader: io.MultiReader(strings.NewReader(musical.MagicHeader), bufio.NewReaderSize(file, decodeReadBuffer), lazy),
-		closer: func() error {
-			return file.Close()
-		},
-		community: community,
-	}, nil
+		closer: func() error { return closeDevicePart(name, journal) },
+	}
+	lazy := &lazyCloudReader{cloud: fw.cloudUploader}
+	fw.reader = io.MultiReader(strings.NewReader(musical.MagicHeader), bufio.NewReaderSize(file, decodeReadBuffer), lazy)
+	// Retry any upload that was still pending when we last exited.
+	if pending, delay := fw.outbox.pending(); pending {
+		fw.schedule(delay)
+	}
+	return fw, nil
+}
+
+// openCloudReplica opens a work that is already open for writing read-only
+// (to catch up a joiner), from file, this device's part of it, size bytes
+// long, positioned after its header.
+func openCloudReplica(community signalling.API, name string, file *os.File, size int64) fs.File {
+	replica := &CloudBacked{
+		cloudUploader: uploaderOf(community, name),
+		size:          int64(len(musical.MagicHeader)) + size,
+		closer:        file.Close,
+	}
+	lazy := &lazyCloudReader{cloud: replica.cloudUploader}
+	replica.reader = io.MultiReader(strings.NewReader(musical.MagicHeader), bufio.NewReaderSize(io.LimitReader(file, max(size-int64(len(musical.MagicHeader)), 0)), decodeReadBuffer), lazy)
+	return readOnlyFile{replica}
 }
 
 // decodeReadBuffer is the read-ahead buffer size wrapped around each save part
@@ -126,8 +200,7 @@ const decodeReadBuffer = 1 << 16 // 64 KiB
 // the round-trip overlaps that backlog. The per-part downloads stay lazy too
 // (see cloudReader). Read on a single goroutine (the musical decode), no locking.
 type lazyCloudReader struct {
-	community signalling.API
-	work      string
+	cloud *cloudUploader
 
 	once sync.Once
 	r    io.Reader
@@ -144,22 +217,41 @@ func (l *lazyCloudReader) Read(p []byte) (int, error) {
 
 func (l *lazyCloudReader) init() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	parts, err := l.community.CloudParts(ctx, signalling.WorkID(l.work))
+	parts, err := l.cloud.community.CloudParts(ctx, signalling.WorkID(l.cloud.name))
 	cancel()
 	if err != nil {
 		Engine.Raise(err) // not fatal: fall through with whatever parts we got.
 	}
 	var readers []io.Reader
+	manifest := cloudManifestOf(l.cloud.name)
+	removeLegacyCaches(l.cloud.name, parts)
 	for part, stat := range parts {
-		if part == signalling.PartID(UserState.Device) {
-			continue
+		if l.cloud.outbox.merged(part, stat) {
+			continue // already replayed as part of ours, see reclaimStale.
+		}
+		if own := l.cloud.outbox.Part; part == own {
+			if !l.cloud.outbox.diverged(stat) {
+				continue
+			}
+			// Someone else has written to our part (the same device
+			// restored on another machine): keep their part, reading it
+			// like any other, and upload ours to a fresh one instead.
+			forked, err := l.cloud.outbox.fork(l.cloud.device)
+			if err != nil {
+				Engine.Raise(err)
+			} else {
+				log.Printf("aviary: cloud part %s of %s was changed elsewhere, keeping it and uploading ours as %s", own, l.cloud.name, forked)
+				l.cloud.schedule(0)
+			}
 		}
 		readers = append(readers, &cloudReader{
-			community: l.community,
-			work:      signalling.WorkID(l.work),
+			community: l.cloud.community,
+			work:      signalling.WorkID(l.cloud.name),
 			part:      part,
 			size:      stat.Size,
 			time:      stat.Time,
+			name:      l.cloud.name,
+			manifest:  manifest,
 		})
 	}
 	// The local part's magic header is seeded eagerly in OpenCloud (fresh files)
@@ -174,66 +266,105 @@ func (l *lazyCloudReader) init() {
 	l.r = bufio.NewReaderSize(io.MultiReader(readers...), decodeReadBuffer)
 }
 
+// cloudReader streams another device's part of a work, from the local cache if
+// it is still current (see cloudManifest), or else from the cloud, caching it
+// as it is read.
 type cloudReader struct {
 	community signalling.API
 	work      signalling.WorkID
 	part      signalling.PartID
 	time      time.Time
 	size      int64
-	open      bool
+	name      string
+	manifest  *cloudManifest
 	read      io.Reader
-	shut      func()
+	shut      func(error) // called once the part has been read, with io.EOF, or why not
 }
 
 func (cr *cloudReader) Read(p []byte) (n int, err error) {
-	if !cr.open {
-		stat, err := os.Stat(UserDataDir + "/" + string(cr.work) + "/" + string(cr.part) + ".mus3")
-		if err != nil || stat.ModTime().Before(cr.time) || stat.Size() != cr.size {
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			file, err := cr.community.LookupSave(ctx, cr.work, cr.part)
-			if err != nil {
-				cancel()
-				return 0, err
-			}
-			cache, err := os.OpenFile(UserDataDir+"/saves/"+string(cr.work)+"/"+string(cr.part)+".mus3", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
-			if err != nil {
-				cancel()
-				return 0, err
-			}
-			cr.read = io.TeeReader(file, cache)
-			var header [len(musical.MagicHeader)]byte
-			n, err := io.ReadFull(cr.read, header[:])
-			if err != nil && !errors.Is(err, io.EOF) {
-				cancel()
-				return n, xray.New(err)
-			} else if err == nil {
-				if string(header[:]) != musical.MagicHeader {
-					cancel()
-					return n, xray.New(errors.New("invalid musical.Users3DScene file"))
-				}
-			}
-			cr.shut = func() {
-				file.Close()
-				cache.Close()
-				cancel()
-			}
-			cr.open = true
-		} else {
-			local, err := os.OpenFile(UserDataDir+"/saves/"+string(cr.work)+"/"+string(cr.part)+".mus3", os.O_RDONLY, 0666)
-			if err != nil {
-				return 0, err
-			}
-			cr.read = local
-			cr.open = true
+	if cr.read == nil {
+		if err := cr.open(); err != nil {
+			return 0, err
 		}
 	}
 	n, err = cr.read.Read(p)
-	if err == io.EOF {
-		cr.shut()
+	if err != nil && cr.shut != nil {
+		cr.shut(err)
+		cr.shut = nil
 	}
 	return n, err
 }
 
+// open starts reading the part, skipping past its magic header.
+func (cr *cloudReader) open() error {
+	if path, ok := cr.manifest.cached(cr.name, cr.part, signalling.Part{Size: cr.size, Time: cr.time}); ok {
+		local, err := os.Open(path)
+		if err == nil {
+			cr.read = local
+			cr.shut = func(error) { local.Close() }
+			return cr.header()
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	file, err := cr.community.LookupSave(ctx, cr.work, cr.part)
+	if err != nil {
+		cancel()
+		return err
+	}
+	tmp, err := os.CreateTemp(cr.manifest.dir, string(cr.part)+".*.tmp")
+	if err != nil {
+		file.Close()
+		cancel()
+		return xray.New(err)
+	}
+	hash := sha256.New()
+	cr.read = io.TeeReader(file, io.MultiWriter(tmp, hash))
+	cr.shut = func(reason error) {
+		file.Close()
+		cancel()
+		stat, err := tmp.Stat()
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		// Only a complete download, of the version the cloud listed, is kept.
+		if reason != io.EOF || err != nil || stat.Size() != cr.size {
+			os.Remove(tmp.Name())
+			return
+		}
+		if err := cr.manifest.commit(cr.name, cr.part, tmp.Name(), cachedPart{
+			Size: cr.size,
+			Time: cr.time,
+			Hash: hex.EncodeToString(hash.Sum(nil)),
+		}); err != nil {
+			Engine.Raise(err)
+		}
+	}
+	return cr.header()
+}
+
+// header reads and checks the part's magic header.
+func (cr *cloudReader) header() error {
+	var header [len(musical.MagicHeader)]byte
+	n, err := io.ReadFull(cr.read, header[:])
+	if err != nil && !errors.Is(err, io.EOF) {
+		cr.shut(err)
+		cr.shut = nil
+		return xray.New(err)
+	}
+	if err == nil && string(header[:]) != musical.MagicHeader {
+		err := errors.New("invalid musical.Users3DScene file")
+		cr.shut(err)
+		cr.shut = nil
+		return xray.New(err)
+	}
+	if n == 0 {
+		// an empty part, which has nothing more to read.
+		cr.shut(io.EOF)
+		cr.shut = nil
+	}
+	return nil
+}
+
 func (fw *CloudBacked) Stat() (fs.FileInfo, error) {
 	return fw, nil
 }
@@ -265,46 +396,141 @@ func (fw *CloudBacked) Read(p []byte) (n int, err error) {
 func (fw *CloudBacked) Write(p []byte) (n int, err error) {
 	fw.lock.Lock()
 	n, err = fw.writer.Write(p)
-	if fw.sync.CompareAndSwap(false, true) {
-		savePath := UserDataDir + "/saves/" + fw.name + "/" + UserState.Device + ".mus3"
-		device := UserState.Device
-		PendingSaves.Go(func() {
-			defer fw.sync.Store(false)
-			var shuttingDown bool
-			select {
-			case <-ShuttingDown:
-				shuttingDown = true
-			case <-time.After(10 * time.Minute):
-			}
-			raise := func(err error) {
-				if shuttingDown {
-					log.Println("aviary: cloud save error during shutdown:", err)
-					return
-				}
-				Engine.Raise(err)
-			}
-			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-			defer cancel()
+	if pending, err := fw.outbox.wrote(); err != nil {
+		Engine.Raise(err)
+	} else if !pending {
+		fw.schedule(10 * time.Minute)
+	}
+	fw.lock.Unlock()
+	fw.size += int64(n)
+	return n, err
+}
 
-			fw.lock.Lock()
-			defer fw.lock.Unlock()
+// schedule uploads the local part after the delay (or at shutdown, whichever
+// comes first), unless an upload is already scheduled. A failed upload stays in
+// the outbox, and is retried with backoff until it succeeds, or until the next
+// start if we are shutting down.
+func (fw *cloudUploader) schedule(delay time.Duration) {
+	if !fw.sync.CompareAndSwap(false, true) {
+		return
+	}
+	PendingSaves.Go(func() {
+		uploaded := fw.retry(delay)
+		fw.sync.Store(false)
+		// Writes made after the upload, but before we cleared sync, could
+		// not schedule their own.
+		if pending, _ := fw.outbox.pending(); uploaded && pending {
+			fw.schedule(10 * time.Minute)
+		}
+	})
+}
 
-			file, err := os.OpenFile(savePath, os.O_RDONLY, 0666)
-			if err != nil {
-				raise(err)
-				return
-			}
-			if stat, err := file.Stat(); err == nil && stat.Size() < int64(len(musical.MagicHeader)) {
-				return
+// retry uploads the local part after the delay, retrying with backoff until it
+// succeeds (reporting true) or fails during shutdown.
+func (fw *cloudUploader) retry(delay time.Duration) bool {
+	for {
+		var shuttingDown bool
+		select {
+		case <-ShuttingDown:
+			shuttingDown = true
+		case <-time.After(delay):
+		}
+		err := fw.upload()
+		if err == nil {
+			return true
+		}
+		retry, first, serr := fw.outbox.failed()
+		switch {
+		case shuttingDown:
+			log.Println("aviary: cloud save error during shutdown:", err)
+			return false
+		case first:
+			Engine.Raise(err)
+		default:
+			log.Printf("aviary: cloud save failed again (retrying in %v): %v", retry, err)
+		}
+		if serr != nil {
+			Engine.Raise(serr)
+		}
+		delay = retry
+	}
+}
+
+// upload sends the local part to the cloud, acknowledging it in the outbox.
+func (fw *cloudUploader) upload() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	fw.lock.Lock()
+	defer fw.lock.Unlock()
+
+	file, err := os.OpenFile(UserDataDir+"/saves/"+fw.name+"/"+fw.device+".mus3", os.O_RDONLY, 0666)
+	if err != nil {
+		return xray.New(err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return xray.New(err)
+	}
+	size := stat.Size()
+	if size < int64(len(musical.MagicHeader)) {
+		return fw.outbox.acknowledged(0, time.Time{})
+	}
+	work := signalling.WorkID(fw.name)
+	part, acked := fw.outbox.target()
+	if acked != 0 && acked != size {
+		// Before uploading to the part, check that nobody else has written
+		// to it since we last did.
+		if parts, err := fw.community.CloudParts(ctx, work); err == nil {
+			if cloud, ok := parts[part]; ok && fw.outbox.diverged(cloud) {
+				forked, err := fw.outbox.fork(fw.device)
+				if err != nil {
+					return err
+				}
+				log.Printf("aviary: cloud part %s of %s was changed elsewhere, keeping it and uploading ours as %s", part, fw.name, forked)
 			}
-			if err := fw.community.InsertSave(ctx, signalling.WorkID(fw.name), signalling.PartID(device), file); err != nil {
-				raise(err)
+		}
+		part, acked = fw.outbox.target()
+	}
+	if err := fw.outbox.uploading(size); err != nil {
+		return err
+	}
+	// Upload only what has been appended since the last acknowledged upload.
+	// The append is refused with 409 Conflict if the cloud part isn't the
+	// length we expect (another upload got there first), in which case the
+	// whole part is uploaded. Any other failure is retried as an append, from
+	// the same acknowledged offset.
+	var uploaded bool
+	if acked >= int64(len(musical.MagicHeader)) && acked <= size {
+		uploaded = acked == size
+		if !uploaded {
+			appended := io.NopCloser(io.NewSectionReader(file, acked, size-acked))
+			err := fw.community.AppendSave(ctx, work, part, acked, appended)
+			if err != nil && !conflicted(err) {
+				return xray.New(err)
 			}
-		})
+			uploaded = err == nil
+		}
 	}
-	fw.lock.Unlock()
-	fw.size += int64(n)
-	return n, err
+	if !uploaded {
+		if err := fw.community.InsertSave(ctx, work, part, io.NopCloser(io.NewSectionReader(file, 0, size))); err != nil {
+			return xray.New(err)
+		}
+	}
+	// Note the time the cloud gives the part, to tell whether anyone else
+	// writes to it before we next load it (see cloudOutbox.diverged).
+	var at time.Time
+	if parts, err := fw.community.CloudParts(ctx, work); err == nil && parts[part].Size == size {
+		at = parts[part].Time
+	}
+	return fw.outbox.acknowledged(size, at)
+}
+
+// conflicted reports whether the request failed with 409 Conflict.
+func conflicted(err error) bool {
+	var status interface{ StatusHTTP() int }
+	return errors.As(err, &status) && status.StatusHTTP() == http.StatusConflict
 }
 
 func (cb *CloudBacked) Close() error {
@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"time"
+
+	"runtime.link/api/xray"
+	"the.quetzal.community/aviary/internal/datasize"
+	"the.quetzal.community/aviary/internal/ice/signalling"
+	"the.quetzal.community/aviary/internal/musical"
+)
+
+// staleAfter is how long a device must have gone without syncing a work before
+// its parts of the work are considered stale, see staleParts.
+const staleAfter = 90 * 24 * time.Hour
+
+// describeUsage summarises the cloud storage taken up by a work, for display.
+func describeUsage(usage signalling.WorkUsage) string {
+	if len(usage.Parts) == 0 {
+		return "not in the cloud"
+	}
+	devices := "1 device"
+	if len(usage.Devices) != 1 {
+		devices = fmt.Sprintf("%d devices", len(usage.Devices))
+	}
+	return fmt.Sprintf("%s in the cloud, from %s, last synced %s",
+		datasize.ByteSize(usage.Size).HumanReadable(), devices, usage.Synced.Local().Format("2 Jan 2006 15:04"))
+}
+
+// staleParts returns the parts of the work written by devices that haven't
+// synced it for staleAfter (see signalling.WorkUsage.Stale), which can be
+// reclaimed with reclaimStale.
+func staleParts(usage signalling.WorkUsage) []signalling.PartID {
+	own := openOutbox(string(usage.Work), UserState.Device).Part
+	return usage.Stale(own, time.Now().Add(-staleAfter))
+}
+
+// reclaimStale merges the given stale parts of a work (see staleParts), as the
+// user confirmed them, into this device's own part, uploads it, and only then
+// deletes them from the cloud, returning the parts deleted. Any that are no
+// longer stale (their device has synced the work since) are left alone. The
+// work must not be open for editing. Should a stale device ever sync the work
+// again, it will upload its part anew.
+//
+// The cached copies of the deleted parts are kept, marked as deleted in the
+// cloud manifest, so that checkpoints made before they were merged still open
+// on this device (see checkpointPrefix).
+func reclaimStale(community signalling.API, work musical.WorkID, confirmed []signalling.PartID) ([]signalling.PartID, error) {
+	name := base64.RawURLEncoding.EncodeToString(work[:])
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	parts, err := community.CloudParts(ctx, signalling.WorkID(name))
+	cancel()
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	outbox := openOutbox(name, UserState.Device)
+	stale := slices.DeleteFunc(staleParts(signalling.WorkStorage(signalling.WorkID(name), parts)), func(part signalling.PartID) bool {
+		return !slices.Contains(confirmed, part)
+	})
+	if len(stale) == 0 {
+		return nil, nil
+	}
+	if err := mergeParts(community, name, outbox, parts, stale); err != nil {
+		return nil, err
+	}
+	if err := uploaderOf(community, name).upload(); err != nil {
+		// the merged records are uploaded the next time the work is open,
+		// and the stale parts can be deleted once they have been.
+		return nil, fmt.Errorf("failed to upload merged part: %w", err)
+	}
+	manifest := cloudManifestOf(name)
+	var deleted []signalling.PartID
+	var errs []error
+	for _, part := range stale {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := community.DeleteSave(ctx, signalling.WorkID(name), part)
+		cancel()
+		if err != nil {
+			errs = append(errs, xray.New(err))
+			continue
+		}
+		if err := outbox.deleted(part); err != nil {
+			errs = append(errs, err)
+		}
+		if err := manifest.deleted(part); err != nil {
+			errs = append(errs, err)
+		}
+		deleted = append(deleted, part)
+	}
+	log.Printf("aviary: merged and deleted %d stale cloud parts of %s", len(deleted), name)
+	return deleted, errors.Join(errs...)
+}
+
+// mergeParts appends the records of each of the given cloud parts of the work
+// named name to this device's part (unless they have been already), each part
+// as a single write, so that it is either merged whole or not at all.
+func mergeParts(community signalling.API, name string, outbox *cloudOutbox, parts map[signalling.PartID]signalling.Part, merge []signalling.PartID) error {
+	file, journal, err := openDevicePart(name)
+	if err != nil {
+		return xray.New(err)
+	}
+	if journal == nil {
+		file.Close()
+		return xray.New(errors.New("the work is open for editing"))
+	}
+	if journal.Size() == 0 {
+		if _, err := journal.Write([]byte(musical.MagicHeader)); err != nil {
+			closeDevicePart(name, journal)
+			return xray.New(err)
+		}
+	}
+	for _, part := range merge {
+		if outbox.merged(part, parts[part]) {
+			continue
+		}
+		if err := mergePart(community, name, part, parts[part].Size, journal); err != nil {
+			closeDevicePart(name, journal)
+			return fmt.Errorf("failed to merge part %s: %w", part, err)
+		}
+		if err := outbox.merging(part, parts[part].Size); err != nil {
+			closeDevicePart(name, journal)
+			return err
+		}
+	}
+	return closeDevicePart(name, journal)
+}
+
+// mergePart appends the records of the cloud part, size bytes long, through
+// the journal.
+func mergePart(community signalling.API, name string, part signalling.PartID, size int64, journal *musical.Journal) error {
+	if size <= int64(len(musical.MagicHeader)) {
+		return nil
+	}
+	prefix, err := checkpointPrefix(community, true, name, part, size)
+	if err != nil {
+		return err
+	}
+	defer prefix.Close()
+	records, err := io.ReadAll(prefix)
+	if err != nil {
+		return xray.New(err)
+	}
+	if int64(len(records)) != size || string(records[:len(musical.MagicHeader)]) != musical.MagicHeader {
+		return xray.New(errors.New("invalid musical.Users3DScene file"))
+	}
+	if _, err := journal.Write(records[len(musical.MagicHeader):]); err != nil {
+		return xray.New(err)
+	}
+	return nil
+}
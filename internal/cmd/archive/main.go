@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"runtime.link/api/xray"
+
+	"the.quetzal.community/aviary/internal/archive"
+	"the.quetzal.community/aviary/internal/library"
+	"the.quetzal.community/aviary/internal/musical"
+	"the.quetzal.community/aviary/internal/pck"
+)
+
+const usage = `usage:
+	archive export [userdata] [dst] [work...]
+		writes each work (every work, if none are named) saved in the
+		'userdata' directory to dst/<work>.aviary
+	archive import [userdata] [device] [src.aviary...]
+		registers each archive as a local work of 'device' in 'userdata'
+	archive check [src.aviary...]
+		validates each archive and prints its manifest
+	archive credits [-json] [src.aviary...]
+		prints the credits for the library artwork each archive uses`
+
+// export [userdata] [dst] [work...]
+func export(userdata, dst string, works ...string) error {
+	// credit the library authors as the client would (see library.Lookup).
+	for _, name := range []string{"preview.pck", "library.pck"} {
+		if err := loadAuthors(filepath.Join(userdata, name)); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if len(works) == 0 {
+		entries, err := os.ReadDir(filepath.Join(userdata, "saves"))
+		if err != nil {
+			return xray.New(err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				works = append(works, entry.Name())
+			}
+		}
+	}
+	if err := os.MkdirAll(dst, 0777); err != nil {
+		return xray.New(err)
+	}
+	for _, name := range works {
+		id, err := base64.RawURLEncoding.DecodeString(name)
+		if err != nil || len(id) != len(musical.WorkID{}) {
+			return fmt.Errorf("invalid work %q", name)
+		}
+		path := filepath.Join(dst, name+archive.Extension)
+		file, err := os.Create(path)
+		if err != nil {
+			return xray.New(err)
+		}
+		parts, err := archive.LocalParts(userdata, musical.WorkID(id))
+		if err == nil {
+			_, err = archive.Export(file, userdata, musical.WorkID(id), parts)
+		}
+		if cerr := file.Close(); err == nil && cerr != nil {
+			err = xray.New(cerr)
+		}
+		if err != nil {
+			os.Remove(path)
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Println(path)
+	}
+	return nil
+}
+
+// loadAuthors loads the manifest of library authors from the .pck at path, if
+// it exists.
+func loadAuthors(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return xray.New(err)
+	}
+	defer file.Close()
+	index, err := pck.Index(file)
+	if err != nil {
+		return err
+	}
+	_, err = library.LoadPCK(file, index)
+	return err
+}
+
+// import [userdata] [device] [src.aviary...]
+func install(userdata, device string, paths ...string) error {
+	for _, path := range paths {
+		r, err := archive.OpenReader(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		_, err = r.Install(userdata, device)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		fmt.Println(r.Manifest.Work)
+	}
+	return nil
+}
+
+// check [src.aviary...]
+func check(paths ...string) error {
+	for _, path := range paths {
+		r, err := archive.OpenReader(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		r.Close()
+		data, err := json.MarshalIndent(r.Manifest, "", "\t")
+		if err != nil {
+			return xray.New(err)
+		}
+		fmt.Printf("%s\n%s\n", path, data)
+	}
+	return nil
+}
+
+// credits [-json] [src.aviary...]
+func credits(asJSON bool, paths ...string) error {
+	for _, path := range paths {
+		r, err := archive.OpenReader(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		r.Close()
+		report := r.Manifest.Attribution()
+		if !asJSON {
+			fmt.Print(report.Markdown())
+			continue
+		}
+		data, err := report.JSON()
+		if err != nil {
+			return xray.New(err)
+		}
+		fmt.Printf("%s\n", data)
+	}
+	return nil
+}
+
+func main() {
+	var err error
+	switch {
+	case len(os.Args) >= 4 && os.Args[1] == "export":
+		err = export(os.Args[2], os.Args[3], os.Args[4:]...)
+	case len(os.Args) >= 5 && os.Args[1] == "import":
+		err = install(os.Args[2], os.Args[3], os.Args[4:]...)
+	case len(os.Args) >= 3 && os.Args[1] == "check":
+		err = check(os.Args[2:]...)
+	case len(os.Args) >= 4 && os.Args[1] == "credits" && os.Args[2] == "-json":
+		err = credits(true, os.Args[3:]...)
+	case len(os.Args) >= 3 && os.Args[1] == "credits":
+		err = credits(false, os.Args[2:]...)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
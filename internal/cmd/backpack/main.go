@@ -8,6 +8,7 @@ import (
 
 	"runtime.link/api/xray"
 
+	"the.quetzal.community/aviary/internal/library"
 	"the.quetzal.community/aviary/internal/pck"
 )
 
@@ -45,7 +46,10 @@ func backpack(src_pck, dst_pck string) error {
 		// the design explorer reads up-front, so they belong in
 		// preview.pck rather than being streamed from library.pck.
 		isRegion := strings.HasSuffix(path, ".region")
-		if _, ok := exist[path]; ok || !(strings.HasSuffix(path, ".import") || strings.HasSuffix(path, ".remap") || isKennyScene || isImportedIcon || isRegion) || strings.HasPrefix(path, "preview/") {
+		// The manifest of library authors (their licenses and how they
+		// ask to be credited), so that it's available offline.
+		isAuthors := path == library.ManifestPath
+		if _, ok := exist[path]; ok || !(strings.HasSuffix(path, ".import") || strings.HasSuffix(path, ".remap") || isKennyScene || isImportedIcon || isRegion || isAuthors) || strings.HasPrefix(path, "preview/") {
 			delete(index, path)
 		}
 	}
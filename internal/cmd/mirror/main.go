@@ -0,0 +1,272 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"runtime.link/api/xray"
+
+	"the.quetzal.community/aviary/internal/datasize"
+	"the.quetzal.community/aviary/internal/httpseek"
+	"the.quetzal.community/aviary/internal/library"
+	"the.quetzal.community/aviary/internal/pck"
+)
+
+const usage = `usage:
+	mirror [library.pck] [selection...]
+		downloads the community library into library.pck, so that it can
+		be played offline, with AVIARY_DOWNLOAD=0. The path is required,
+		for the game to play the mirror, it must be the library.pck in
+		Aviary's user data directory. Each selection is an author, or an
+		author/category, either of which may be *, and is downloaded
+		along with what it depends on (such as the materials that
+		designs share under author/texture). With no selection, the
+		whole library is downloaded.
+
+Run it again to bring the mirror up to date with the library. Keep
+$AVIARY_LIBRARY_BUDGET above its size, so that it isn't compacted
+away when playing online.`
+
+// community is the library.pck that the game downloads from.
+const community = "https://vpk.quetzal.community/library.pck"
+
+// Files up to batchSmall are downloaded together, in batches of up to
+// batchSize bytes (see httpseek.URL.ReadRanges), larger files one at a time.
+const (
+	batchSmall = 256 << 10
+	batchSize  = 32 << 20
+)
+
+// selected reports whether the resource at path is in the selection.
+func selected(resource string, selection []string) bool {
+	if len(selection) == 0 {
+		return true
+	}
+	author, category := library.Author("res://"+resource), library.Category(resource)
+	if author == "" {
+		return false
+	}
+	for _, pattern := range selection {
+		if ok, _ := path.Match(pattern, author); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, author+"/"+category); ok && category != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// mirrorer downloads files of the cloud pck into the slots reserved for them
+// in the local one.
+type mirrorer struct {
+	cloud *httpseek.URL
+	local *os.File
+
+	index map[string]pck.File // of the cloud
+	slots map[string]pck.File // of the local
+
+	changed bool // the cloud was republished while mirroring.
+
+	files, failed int
+	bytes         datasize.ByteSize
+}
+
+func mirror(dst string, selection ...string) error {
+	for _, pattern := range selection {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid selection %q: %w", pattern, err)
+		}
+	}
+	cloud, err := httpseek.New(community)
+	if err != nil {
+		return xray.New(err)
+	}
+	defer cloud.Close()
+	index, err := pck.Index(cloud)
+	if err != nil {
+		return xray.New(err)
+	}
+	local, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return xray.New(err)
+	}
+	defer local.Close()
+	stat, err := local.Stat()
+	if err != nil {
+		return xray.New(err)
+	}
+	if stat.Size() == 0 {
+		if err := pck.Create(local); err != nil {
+			return xray.New(err)
+		}
+	}
+	if _, err := local.Seek(0, io.SeekStart); err != nil {
+		return xray.New(err)
+	}
+	if err := pck.Append(local, index); err != nil {
+		return xray.New(err)
+	}
+	if _, err := local.Seek(0, io.SeekStart); err != nil {
+		return xray.New(err)
+	}
+	slots, err := pck.Index(local)
+	if err != nil {
+		return xray.New(err)
+	}
+	m := &mirrorer{cloud: cloud, local: local, index: index, slots: slots}
+	cloud.OnResourceModified(func(*httpseek.URL) { m.changed = true })
+	var want []string
+	for resource := range index {
+		if selected(resource, selection) {
+			want = append(want, resource)
+		}
+	}
+	if len(want) == 0 {
+		return fmt.Errorf("nothing in the library is selected by %q", selection)
+	}
+	// along with what those depend on, and in turn what that depends on.
+	seen := make(map[string]bool)
+	for _, resource := range want {
+		seen[resource] = true
+	}
+	for len(want) > 0 {
+		if err := m.fetch(want); err != nil {
+			return err
+		}
+		var deps []string
+		for _, resource := range want {
+			more, err := m.dependencies(resource)
+			if err != nil {
+				return err
+			}
+			for _, dep := range more {
+				if _, ok := index[dep]; ok && !seen[dep] {
+					seen[dep] = true
+					deps = append(deps, dep)
+				}
+			}
+		}
+		want = deps
+	}
+	fmt.Fprintf(os.Stderr, "\rmirror: downloaded %d files (%s)\n", m.files, m.bytes.HumanReadable())
+	if m.failed > 0 {
+		return fmt.Errorf("mirror: %d files failed to download, run mirror again to retry them", m.failed)
+	}
+	return nil
+}
+
+// dependencies of the resource, as mirrored: what an .import/.remap file
+// points to, and what a text scene or resource refers to in the library (along
+// with the .import/.remap files of those).
+func (m *mirrorer) dependencies(resource string) ([]string, error) {
+	slot := m.slots[resource]
+	if slot.Missing() {
+		return nil, nil
+	}
+	switch path.Ext(resource) {
+	case ".import", ".remap":
+		data, err := slot.Bytes(m.local)
+		if err != nil {
+			return nil, xray.New(err)
+		}
+		return library.ImportTargets(data), nil
+	case ".tscn", ".tres":
+		data, err := slot.Bytes(m.local)
+		if err != nil {
+			return nil, xray.New(err)
+		}
+		var deps []string
+		for _, dep := range library.SceneDependencies(data) {
+			if strings.HasPrefix(dep, "library/") {
+				deps = append(deps, dep, dep+".import", dep+".remap")
+			}
+		}
+		return deps, nil
+	}
+	return nil, nil
+}
+
+// fetch downloads the resources that are missing from the local pck.
+func (m *mirrorer) fetch(resources []string) error {
+	var small, large []string
+	for _, resource := range resources {
+		next, prev := m.slots[resource], m.index[resource]
+		switch {
+		case !next.Missing() || next.Hash != prev.Hash:
+		case prev.Stored() <= batchSmall:
+			small = append(small, resource)
+		default:
+			large = append(large, resource)
+		}
+	}
+	bySeek := func(a, b string) int { return cmp.Compare(m.index[a].Seek, m.index[b].Seek) }
+	slices.SortFunc(small, bySeek)
+	slices.SortFunc(large, bySeek)
+	for len(small) > 0 {
+		var (
+			ranges []httpseek.Range
+			size   int64
+			at     = make(map[int64]string)
+		)
+		for len(small) > 0 && (size == 0 || size+m.index[small[0]].Stored() <= batchSize) {
+			prev := m.index[small[0]]
+			ranges = append(ranges, httpseek.Range{Offset: prev.Seek, Length: prev.Stored()})
+			at[prev.Seek] = small[0]
+			size += prev.Stored()
+			small = small[1:]
+		}
+		for r, data := range m.cloud.ReadRanges(context.Background(), ranges) {
+			resource := at[r.Offset]
+			if data == nil {
+				// to try again on its own.
+				large = append(large, resource)
+				continue
+			}
+			m.remap(resource, httpseek.NewRangeReader(r, data))
+		}
+		if m.changed {
+			return errors.New("mirror: library.pck was republished while mirroring it, run mirror again")
+		}
+	}
+	for _, resource := range large {
+		m.remap(resource, m.cloud)
+		if m.changed {
+			return errors.New("mirror: library.pck was republished while mirroring it, run mirror again")
+		}
+	}
+	return nil
+}
+
+// remap the resource from src, a reader of the cloud pck.
+func (m *mirrorer) remap(resource string, src io.ReadSeeker) {
+	next, prev := m.slots[resource], m.index[resource]
+	if err := pck.Remap(m.local, src, next, prev); err != nil {
+		fmt.Fprintf(os.Stderr, "\rmirror: %s: %v\n", resource, err)
+		m.failed++
+		return
+	}
+	next.Flag &^= pck.FlagMissing
+	m.slots[resource] = next
+	m.files++
+	m.bytes += datasize.ByteSize(prev.Size)
+	fmt.Fprintf(os.Stderr, "\rmirror: %d files (%s)", m.files, m.bytes.HumanReadable())
+}
+
+func main() {
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err := mirror(os.Args[1], os.Args[2:]...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"runtime.link/api/xray"
+
+	"the.quetzal.community/aviary/internal/pck"
+)
+
+const usage = `usage:
+	pck ls [src.pck...]
+		lists the entries of each pck, with their size, hash and flags
+	pck cat [src.pck] [path...]
+		writes each entry to stdout
+	pck extract [src.pck] [dst] [path...]
+		writes each entry (every entry, if none are named) to dst/<path>
+	pck add [dst.pck] [root] [file...]
+		adds (or replaces) each file under 'root', at its path relative to it
+	pck rm [dst.pck] [path...]
+		removes each entry
+	pck verify [src.pck...]
+		checks the content of every entry against its hash
+	pck diff [a.pck] [b.pck]
+		lists the entries that are only in one pck, or that differ
+	pck compact [dst.pck]
+		rewrites the pck without the space reserved for missing entries
+
+Encrypted packs are read and written with the hex encoded key in
+$GODOT_SCRIPT_ENCRYPTION_KEY, as Godot exports them.`
+
+// key to encrypt and decrypt packs with, if one is set.
+func key() ([]byte, error) {
+	env := os.Getenv("GODOT_SCRIPT_ENCRYPTION_KEY")
+	if env == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(env)
+	if err != nil || len(key) != pck.KeySize {
+		return nil, fmt.Errorf("GODOT_SCRIPT_ENCRYPTION_KEY must be %d hex encoded bytes", pck.KeySize)
+	}
+	return key, nil
+}
+
+// pack is an open pck file.
+type pack struct {
+	pck.Pack
+	path string
+	file *os.File
+	key  []byte
+}
+
+func open(path string) (*pack, error) {
+	key, err := key()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	read, err := pck.Read(file, key)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &pack{Pack: read, path: path, file: file, key: key}, nil
+}
+
+func (p *pack) Close() error { return p.file.Close() }
+
+// lookup the entry at path, in the index of the pck.
+func (p *pack) lookup(path string) (pck.File, error) {
+	file, ok := p.Index()[strings.TrimPrefix(path, "res://")]
+	if !ok {
+		return pck.File{}, fmt.Errorf("%s: no entry %q", p.path, path)
+	}
+	return file, nil
+}
+
+// read the content of the file, from the pck, or from alongside it, if the pck
+// is sparse.
+func (p *pack) read(path string, file pck.File) ([]byte, error) {
+	var src io.ReadWriteSeeker = p.file
+	if file.Sparse {
+		sparse, err := os.Open(pck.SparsePath(p.path, path))
+		if err != nil {
+			return nil, xray.New(err)
+		}
+		defer sparse.Close()
+		if !file.Encrypted() {
+			return io.ReadAll(sparse)
+		}
+		src = sparse
+	}
+	if file.Encrypted() {
+		if p.key == nil {
+			return nil, fmt.Errorf("%s: %q is encrypted, set GODOT_SCRIPT_ENCRYPTION_KEY", p.path, path)
+		}
+		return file.Decrypt(src, p.key)
+	}
+	return file.Bytes(src)
+}
+
+// sorted paths of the index.
+func sorted(index map[string]pck.File) []string {
+	var paths []string
+	for path := range index {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// flags describes the flags of the file.
+func flags(file pck.File) string {
+	var flags []string
+	if file.Missing() {
+		flags = append(flags, "missing")
+	}
+	if file.Encrypted() {
+		flags = append(flags, "encrypted")
+	}
+	if file.Sparse {
+		flags = append(flags, "sparse")
+	}
+	return strings.Join(flags, ",")
+}
+
+// ls [src.pck...]
+func ls(packs ...string) error {
+	for _, path := range packs {
+		p, err := open(path)
+		if err != nil {
+			return err
+		}
+		p.Close()
+		fmt.Printf("%s: version %d, godot %d.%d.%d, %d entries\n", path, p.Version, p.Engine[0], p.Engine[1], p.Engine[2], len(p.Entries))
+		for _, entry := range p.Entries {
+			if entry.Flag&pck.FlagRemoval != 0 {
+				fmt.Printf("%10s %32s %s removed\n", "-", "", entry.Path)
+				continue
+			}
+			fmt.Println(strings.TrimRight(fmt.Sprintf("%10d %x %s %s", entry.Size, entry.Hash, entry.Path, flags(entry.File)), " "))
+		}
+	}
+	return nil
+}
+
+// cat [src.pck] [path...]
+func cat(src string, entries ...string) error {
+	p, err := open(src)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	for _, path := range entries {
+		file, err := p.lookup(path)
+		if err != nil {
+			return err
+		}
+		data, err := p.read(path, file)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stdout.Write(data); err != nil {
+			return xray.New(err)
+		}
+	}
+	return nil
+}
+
+// extract [src.pck] [dst] [path...]
+func extract(src, dst string, entries ...string) error {
+	p, err := open(src)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	index := p.Index()
+	if len(entries) == 0 {
+		entries = sorted(index)
+	}
+	for _, path := range entries {
+		file, err := p.lookup(path)
+		if err != nil {
+			return err
+		}
+		if file.Missing() {
+			fmt.Fprintf(os.Stderr, "pck: skipping missing %s\n", path)
+			continue
+		}
+		data, err := p.read(path, file)
+		if err != nil {
+			return err
+		}
+		out := filepath.Join(dst, filepath.FromSlash(strings.TrimPrefix(path, "res://")))
+		if rel, err := filepath.Rel(dst, out); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%s: entry %q is outside of %s", src, path, dst)
+		}
+		if err := os.MkdirAll(filepath.Dir(out), 0777); err != nil {
+			return xray.New(err)
+		}
+		if err := os.WriteFile(out, data, 0666); err != nil {
+			return xray.New(err)
+		}
+		fmt.Println(out)
+	}
+	return nil
+}
+
+// rewrite the pck at path, with the entries returned by edit, in place of its
+// own (which are read in full). Missing entries are passed to edit flagged as
+// [pck.FlagMissing], and are dropped by compact, otherwise they must be
+// returned as they are, to keep the space reserved for them (which only the
+// packs that [pck.Append] makes can do).
+func rewrite(path string, compact bool, edit func(sources []pck.Source) ([]pck.Source, error)) error {
+	p, err := open(path)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	var (
+		sources []pck.Source
+		missing = make(map[string]pck.File)
+	)
+	for _, entry := range p.Entries {
+		name := strings.TrimPrefix(entry.Path, "res://")
+		if entry.Missing() {
+			if compact {
+				continue
+			}
+			if p.Version != pck.Version3 || p.Flags&(pck.PackDirEncrypted|pck.PackSparseBundle) != 0 {
+				return fmt.Errorf("%s: %q is missing, compact the pck first", path, name)
+			}
+			missing[name] = entry.File
+			sources = append(sources, pck.Source{Path: name, Flag: entry.Flag})
+			continue
+		}
+		source := pck.Source{Path: name, Flag: entry.Flag}
+		if entry.Flag&pck.FlagRemoval == 0 {
+			if source.Data, err = p.read(name, entry.File); err != nil {
+				return err
+			}
+		}
+		sources = append(sources, source)
+	}
+	if sources, err = edit(sources); err != nil {
+		return err
+	}
+	kept := make(map[string]bool)
+	sources = slices.DeleteFunc(sources, func(s pck.Source) bool {
+		if s.Flag&pck.FlagMissing == 0 {
+			return false
+		}
+		kept[s.Path] = true
+		return true
+	})
+	for name := range missing {
+		if !kept[name] {
+			return fmt.Errorf("%s: %q is missing, compact the pck first", path, name)
+		}
+	}
+	if p.Flags&pck.PackDirEncrypted != 0 || slices.ContainsFunc(sources, func(s pck.Source) bool { return s.Flag&pck.FlagEncrypted != 0 }) {
+		if p.key == nil {
+			return fmt.Errorf("%s: is encrypted, set GODOT_SCRIPT_ENCRYPTION_KEY", path)
+		}
+	}
+	var buf bytes.Buffer
+	if err := pck.Write(&buf, p.Header, p.key, sources); err != nil {
+		return err
+	}
+	if p.Flags&pck.PackSparseBundle != 0 {
+		for _, source := range sources {
+			if source.Flag&pck.FlagRemoval != 0 {
+				continue
+			}
+			data := source.Data
+			if source.Flag&pck.FlagEncrypted != 0 {
+				if data, err = pck.Encrypt(data, p.key); err != nil {
+					return err
+				}
+			}
+			out := pck.SparsePath(path, source.Path)
+			if err := os.MkdirAll(filepath.Dir(out), 0777); err != nil {
+				return xray.New(err)
+			}
+			if err := os.WriteFile(out, data, 0666); err != nil {
+				return xray.New(err)
+			}
+		}
+	}
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return xray.New(err)
+	}
+	if _, err = tmp.Write(buf.Bytes()); err != nil {
+		err = xray.New(err)
+	} else if len(missing) > 0 {
+		if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+			err = xray.New(err)
+		} else {
+			err = pck.Append(tmp, missing)
+		}
+	}
+	if cerr := tmp.Close(); err == nil && cerr != nil {
+		err = xray.New(cerr)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// add [dst.pck] [root] [file...]
+func add(dst, root string, files ...string) error {
+	return rewrite(dst, false, func(sources []pck.Source) ([]pck.Source, error) {
+		for _, file := range files {
+			rel, err := filepath.Rel(root, file)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return nil, fmt.Errorf("%s is not under %s", file, root)
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, xray.New(err)
+			}
+			path := filepath.ToSlash(rel)
+			sources = slices.DeleteFunc(sources, func(s pck.Source) bool { return s.Path == path })
+			sources = append(sources, pck.Source{Path: path, Data: data})
+		}
+		return sources, nil
+	})
+}
+
+// rm [dst.pck] [path...]
+func rm(dst string, entries ...string) error {
+	return rewrite(dst, false, func(sources []pck.Source) ([]pck.Source, error) {
+		for _, path := range entries {
+			path = strings.TrimPrefix(path, "res://")
+			n := len(sources)
+			sources = slices.DeleteFunc(sources, func(s pck.Source) bool { return s.Path == path })
+			if len(sources) == n {
+				return nil, fmt.Errorf("%s: no entry %q", dst, path)
+			}
+		}
+		return sources, nil
+	})
+}
+
+// compact [dst.pck]
+func compact(dst string) error {
+	p, err := open(dst)
+	if err != nil {
+		return err
+	}
+	p.Close()
+	// pck.Compact streams the content of each file, rather than reading
+	// them all into memory, but only for the packs that pck.Append makes.
+	if p.Version < pck.Version3 || p.Flags&(pck.PackDirEncrypted|pck.PackSparseBundle) != 0 {
+		return rewrite(dst, true, func(sources []pck.Source) ([]pck.Source, error) {
+			return sources, nil
+		})
+	}
+	src, err := os.Open(dst)
+	if err != nil {
+		return xray.New(err)
+	}
+	defer src.Close()
+	tmp, err := os.Create(dst + ".tmp")
+	if err != nil {
+		return xray.New(err)
+	}
+	err = pck.Compact(tmp, src, nil)
+	if cerr := tmp.Close(); err == nil && cerr != nil {
+		err = xray.New(cerr)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// verify [src.pck...]
+func verify(packs ...string) error {
+	var failures int
+	for _, path := range packs {
+		p, err := open(path)
+		if err != nil {
+			return err
+		}
+		index := p.Index()
+		for _, name := range sorted(index) {
+			file := index[name]
+			switch {
+			case file.Missing():
+				fmt.Printf("%s: %s: missing\n", path, name)
+				continue
+			case file.Encrypted() && p.key == nil:
+				fmt.Printf("%s: %s: encrypted, skipped\n", path, name)
+				continue
+			}
+			data, err := p.read(name, file)
+			if err != nil {
+				fmt.Printf("%s: %s: %v\n", path, name, err)
+				failures++
+				continue
+			}
+			if hash := md5.Sum(data); hash != file.Hash {
+				fmt.Printf("%s: %s: hash %x, want %x\n", path, name, hash, file.Hash)
+				failures++
+			}
+		}
+		p.Close()
+	}
+	if failures > 0 {
+		return fmt.Errorf("pck: %d entries failed verification", failures)
+	}
+	return nil
+}
+
+// diff [a.pck] [b.pck]
+func diff(a_pck, b_pck string) error {
+	a, err := open(a_pck)
+	if err != nil {
+		return err
+	}
+	a.Close()
+	b, err := open(b_pck)
+	if err != nil {
+		return err
+	}
+	b.Close()
+	ai, bi := a.Index(), b.Index()
+	var differ bool
+	for _, path := range sorted(ai) {
+		next, ok := bi[path]
+		prev := ai[path]
+		switch {
+		case !ok:
+			fmt.Printf("- %s\n", path)
+		case next.Hash != prev.Hash || next.Size != prev.Size:
+			fmt.Printf("~ %s (%d %x -> %d %x)\n", path, prev.Size, prev.Hash, next.Size, next.Hash)
+		case next.Missing() != prev.Missing():
+			fmt.Printf("~ %s (%s -> %s)\n", path, flags(prev), flags(next))
+		default:
+			continue
+		}
+		differ = true
+	}
+	for _, path := range sorted(bi) {
+		if _, ok := ai[path]; !ok {
+			fmt.Printf("+ %s\n", path)
+			differ = true
+		}
+	}
+	if differ {
+		return errDiffer
+	}
+	return nil
+}
+
+// errDiffer exits with status 1 without a message, like diff(1).
+var errDiffer = errors.New("")
+
+func main() {
+	var err error
+	switch {
+	case len(os.Args) >= 3 && os.Args[1] == "ls":
+		err = ls(os.Args[2:]...)
+	case len(os.Args) >= 4 && os.Args[1] == "cat":
+		err = cat(os.Args[2], os.Args[3:]...)
+	case len(os.Args) >= 4 && os.Args[1] == "extract":
+		err = extract(os.Args[2], os.Args[3], os.Args[4:]...)
+	case len(os.Args) >= 5 && os.Args[1] == "add":
+		err = add(os.Args[2], os.Args[3], os.Args[4:]...)
+	case len(os.Args) >= 4 && os.Args[1] == "rm":
+		err = rm(os.Args[2], os.Args[3:]...)
+	case len(os.Args) >= 3 && os.Args[1] == "verify":
+		err = verify(os.Args[2:]...)
+	case len(os.Args) == 4 && os.Args[1] == "diff":
+		err = diff(os.Args[2], os.Args[3])
+	case len(os.Args) == 3 && os.Args[1] == "compact":
+		err = compact(os.Args[2])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if errors.Is(err, errDiffer) {
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
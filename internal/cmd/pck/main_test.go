@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"the.quetzal.community/aviary/internal/pck"
+)
+
+// testPack writes a pck of the files to dir/test.pck.
+func testPack(t *testing.T, dir string, files ...pck.Source) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := pck.Write(&buf, pck.Header{Version: pck.Version3, Engine: [3]uint32{4, 5, 1}, Flags: pck.PackRelFileBase}, nil, files); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "test.pck")
+	if err := os.WriteFile(path, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// entries of the pck at path, and their content.
+func entries(t *testing.T, path string) map[string]string {
+	t.Helper()
+	p, err := open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	content := make(map[string]string)
+	for name, file := range p.Index() {
+		data, err := p.read(name, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content[name] = string(data)
+	}
+	return content
+}
+
+func TestExtract(t *testing.T) {
+	src := testPack(t, t.TempDir(),
+		pck.Source{Path: "library/kenney/tree/oak.glb", Data: []byte("oak")},
+		pck.Source{Path: "icon.svg", Data: []byte("<svg/>")},
+	)
+	for _, dst := range []string{".", "out", "./out/", "out/../in"} {
+		t.Run(dst, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			if err := extract(src, dst); err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			for name, want := range map[string]string{"library/kenney/tree/oak.glb": "oak", "icon.svg": "<svg/>"} {
+				if got, err := os.ReadFile(filepath.Join(dst, name)); err != nil || string(got) != want {
+					t.Errorf("%s = %q, %v, want %q", name, got, err, want)
+				}
+			}
+		})
+	}
+	t.Run("one", func(t *testing.T) {
+		dst := t.TempDir()
+		if err := extract(src, dst, "res://icon.svg"); err != nil {
+			t.Fatalf("extract: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dst, "library")); !os.IsNotExist(err) {
+			t.Errorf("extracted more than the entry named")
+		}
+	})
+}
+
+func TestExtractOutside(t *testing.T) {
+	src := testPack(t, t.TempDir(), pck.Source{Path: "../escape.txt", Data: []byte("out")})
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "dst")
+	if err := extract(src, dst); err == nil {
+		t.Errorf("extracted an entry outside of dst")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escape.txt")); !os.IsNotExist(err) {
+		t.Errorf("wrote outside of dst")
+	}
+}
+
+func TestAddRm(t *testing.T) {
+	dir := t.TempDir()
+	dst := testPack(t, dir,
+		pck.Source{Path: "icon.svg", Data: []byte("<svg/>")},
+		pck.Source{Path: "library/kenney/tree/oak.glb", Data: []byte("oak")},
+	)
+	root := filepath.Join(dir, "root")
+	for name, data := range map[string]string{"library/kenney/tree/oak.glb": "new oak", "library/kenney/tree/elm.glb": "elm"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := add(dst, root, filepath.Join(root, "library/kenney/tree/oak.glb"), filepath.Join(root, "library/kenney/tree/elm.glb")); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	want := map[string]string{"icon.svg": "<svg/>", "library/kenney/tree/oak.glb": "new oak", "library/kenney/tree/elm.glb": "elm"}
+	if got := entries(t, dst); !maps.Equal(got, want) {
+		t.Errorf("after add, entries = %v, want %v", got, want)
+	}
+	if err := add(dst, root, filepath.Join(dir, "test.pck")); err == nil {
+		t.Errorf("added a file outside of root")
+	}
+
+	if err := rm(dst, "res://icon.svg", "library/kenney/tree/oak.glb"); err != nil {
+		t.Fatalf("rm: %v", err)
+	}
+	want = map[string]string{"library/kenney/tree/elm.glb": "elm"}
+	if got := entries(t, dst); !maps.Equal(got, want) {
+		t.Errorf("after rm, entries = %v, want %v", got, want)
+	}
+	if err := rm(dst, "icon.svg"); err == nil {
+		t.Errorf("removed an entry that isn't there")
+	}
+}
+
+func TestAddRmMissing(t *testing.T) {
+	dir := t.TempDir()
+	dst := testPack(t, dir, pck.Source{Path: "icon.svg", Data: []byte("<svg/>")})
+	file, err := os.OpenFile(dst, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserved := pck.File{Size: 3, Hash: md5.Sum([]byte("elm"))}
+	if err := pck.Append(file, map[string]pck.File{"library/kenney/tree/elm.glb": reserved}); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	root := filepath.Join(dir, "root")
+	for _, name := range []string{"oak.glb", "library/kenney/tree/elm.glb"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := add(dst, root, filepath.Join(root, "oak.glb")); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := rm(dst, "icon.svg"); err != nil {
+		t.Fatalf("rm: %v", err)
+	}
+	p, err := open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := p.Index()
+	p.Close()
+	if len(index) != 2 {
+		t.Errorf("entries = %v, want oak.glb and the missing elm.glb", index)
+	}
+	if elm := index["library/kenney/tree/elm.glb"]; !elm.Missing() || elm.Size != reserved.Size || elm.Hash != reserved.Hash {
+		t.Errorf("elm.glb = %+v, want it still reserved", elm)
+	}
+	if err := add(dst, root, filepath.Join(root, "library/kenney/tree/elm.glb")); err == nil {
+		t.Errorf("replaced a missing entry")
+	}
+	if err := rm(dst, "library/kenney/tree/elm.glb"); err == nil {
+		t.Errorf("removed a missing entry")
+	}
+}
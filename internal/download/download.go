@@ -0,0 +1,266 @@
+// Package download fetches a file over HTTP into place, picking up from where
+// an interrupted download left off, and checking it against a published
+// checksum before it replaces the file.
+package download
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrChecksum is returned when the downloaded file does not match the checksum
+// in the manifest. The partial download is discarded, so that the next attempt
+// starts again from zero.
+var ErrChecksum = errors.New("download: checksum mismatch")
+
+// ErrNoChecksum is returned when there is no manifest published (404) to check
+// the file against, before any of it is downloaded.
+var ErrNoChecksum = errors.New("download: no checksum published")
+
+// File to download from URL to Path. While downloading, it is written to
+// Path+".part" (with the validator of the version being downloaded in
+// Path+".part.etag"), which is then renamed into place.
+type File struct {
+	URL  string
+	Path string
+
+	// Manifest is the URL of the published checksums, in the format written
+	// by sha256sum: a hex SHA-256 and a file name, per line. The file is
+	// checked against the line for the name of Path (or the only line). If
+	// there is no manifest published (404), the file is not downloaded, see
+	// ErrNoChecksum. Only without a Manifest is the file not checked.
+	Manifest string
+
+	// Progress, if set, is called from the downloading goroutine as the file
+	// is written.
+	Progress func(Progress)
+
+	// Client to download with, or http.DefaultClient.
+	Client *http.Client
+}
+
+// Progress of a download.
+type Progress struct {
+	Done  int64 // bytes of the file downloaded, including any resumed from.
+	Total int64 // size of the file, or 0 if unknown.
+
+	Rate float64       // bytes per second, so far this attempt.
+	ETA  time.Duration // until done at Rate, or 0 if unknown.
+}
+
+// progressInterval is how often Progress is reported while downloading.
+const progressInterval = 100 * time.Millisecond
+
+// Fetch downloads the file, resuming any partial download of the same version
+// of it, verifies it, and renames it into place.
+func (f File) Fetch(ctx context.Context) error {
+	sum, err := f.checksum(ctx)
+	if err != nil {
+		return err
+	}
+	part, err := os.OpenFile(f.Path+".part", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+	if err := f.resume(ctx, part); err != nil {
+		return err
+	}
+	if sum != nil {
+		if _, err := part.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		hash := sha256.New()
+		if _, err := io.Copy(hash, part); err != nil {
+			return err
+		}
+		if got := hash.Sum(nil); string(got) != string(sum) {
+			part.Close()
+			os.Remove(f.Path + ".part")
+			os.Remove(f.Path + ".part.etag")
+			return fmt.Errorf("%w: %s has SHA-256 %x, not %x", ErrChecksum, filepath.Base(f.Path), got, sum)
+		}
+	}
+	if err := part.Sync(); err != nil {
+		return err
+	}
+	if err := part.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Path+".part", f.Path); err != nil {
+		return err
+	}
+	os.Remove(f.Path + ".part.etag")
+	return nil
+}
+
+func (f File) client() *http.Client {
+	if f.Client != nil {
+		return f.Client
+	}
+	return http.DefaultClient
+}
+
+// checksum returns the SHA-256 of the file published in the manifest, or nil
+// if the file has no Manifest to be checked against.
+func (f File) checksum(ctx context.Context) ([]byte, error) {
+	if f.Manifest == "" {
+		return nil, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", f.Manifest, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s not found", ErrNoChecksum, f.Manifest)
+	default:
+		return nil, fmt.Errorf("failed to fetch %s: %s", f.Manifest, resp.Status)
+	}
+	name := filepath.Base(f.Path)
+	var only []byte
+	var lines int
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		sum, err := hex.DecodeString(fields[0])
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("invalid checksum in %s: %q", f.Manifest, scanner.Text())
+		}
+		if len(fields) > 1 && strings.TrimPrefix(fields[1], "*") == name {
+			return sum, nil
+		}
+		only = sum
+		lines++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if lines == 1 {
+		return only, nil
+	}
+	return nil, fmt.Errorf("no checksum for %s in %s", name, f.Manifest)
+}
+
+// resume downloads the rest of the file into part, from its end, if the file
+// has not changed since it was written, or else all of it, from the start.
+func (f File) resume(ctx context.Context, part *os.File) error {
+	offset, err := part.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	etag, _ := os.ReadFile(f.Path + ".part.etag")
+	if len(etag) == 0 {
+		offset = 0
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", f.URL, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", string(etag))
+	}
+	resp, err := f.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var total int64
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		var first, last int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &first, &last, &total); err != nil || first != offset {
+			return fmt.Errorf("unexpected Content-Range %q resuming from %d", resp.Header.Get("Content-Range"), offset)
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the file was already downloaded in full, if it is that size.
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes */%d", &total); err == nil && total == offset {
+			return nil
+		}
+		os.Remove(f.Path + ".part.etag")
+		if err := part.Truncate(0); err != nil {
+			return err
+		}
+		return f.resume(ctx, part)
+	case http.StatusOK:
+		// a different version of the file (or no partial download), so start
+		// again from the start.
+		offset, total = 0, max(resp.ContentLength, 0)
+		if err := part.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := part.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		validator := resp.Header.Get("ETag")
+		if validator == "" || strings.HasPrefix(validator, "W/") {
+			validator = resp.Header.Get("Last-Modified")
+		}
+		if err := os.WriteFile(f.Path+".part.etag", []byte(validator), 0644); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("failed to fetch %s: %s", f.URL, resp.Status)
+	}
+	_, err = io.Copy(part, &progressReader{
+		Reader: resp.Body,
+		report: f.Progress,
+		start:  time.Now(),
+		from:   offset,
+		done:   offset,
+		total:  total,
+	})
+	return err
+}
+
+// progressReader reports the progress of the download as it is read.
+type progressReader struct {
+	io.Reader
+	report func(Progress)
+
+	start    time.Time
+	reported time.Time
+	from     int64 // bytes resumed from.
+	done     int64
+	total    int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.done += int64(n)
+	if r.report == nil {
+		return n, err
+	}
+	if now := time.Now(); now.Sub(r.reported) >= progressInterval || err == io.EOF {
+		r.reported = now
+		progress := Progress{Done: r.done, Total: r.total}
+		if elapsed := now.Sub(r.start).Seconds(); elapsed > 0 {
+			progress.Rate = float64(r.done-r.from) / elapsed
+		}
+		if progress.Rate > 0 && r.total > r.done {
+			progress.ETA = time.Duration(float64(r.total-r.done) / progress.Rate * float64(time.Second))
+		}
+		r.report(progress)
+	}
+	return n, err
+}
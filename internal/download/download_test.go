@@ -0,0 +1,235 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// server publishes content, as preview.pck, with an ETag for its version, and
+// a manifest of its checksum. served counts the bytes of content sent.
+type server struct {
+	content  atomic.Pointer[[]byte]
+	manifest atomic.Pointer[string]
+	served   atomic.Int64
+	ranges   atomic.Pointer[string] // the last Range asked for.
+}
+
+func newServer(t *testing.T, content []byte) (*server, *httptest.Server) {
+	s := new(server)
+	s.publish(content)
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+func (s *server) publish(content []byte) {
+	s.content.Store(&content)
+	manifest := fmt.Sprintf("%x  library.pck\n%x  preview.pck\n", sha256.Sum256(nil), sha256.Sum256(content))
+	s.manifest.Store(&manifest)
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	content := *s.content.Load()
+	switch r.URL.Path {
+	case "/SHA256SUMS":
+		w.Write([]byte(*s.manifest.Load()))
+	case "/preview.pck":
+		rng := r.Header.Get("Range")
+		s.ranges.Store(&rng)
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(content)))
+		counter := &countingWriter{ResponseWriter: w, count: &s.served}
+		http.ServeContent(counter, r, "preview.pck", time.Time{}, bytes.NewReader(content))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// countingWriter counts the bytes of content written (not of error messages).
+type countingWriter struct {
+	http.ResponseWriter
+	count  *atomic.Int64
+	failed bool
+}
+
+func (w *countingWriter) WriteHeader(code int) {
+	w.failed = code >= 300
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if !w.failed {
+		w.count.Add(int64(len(p)))
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func makeContent(n int) []byte {
+	content := make([]byte, n)
+	for i := range content {
+		content[i] = byte(rand.IntN(256))
+	}
+	return content
+}
+
+func file(ts *httptest.Server, dir string) File {
+	return File{
+		URL:      ts.URL + "/preview.pck",
+		Manifest: ts.URL + "/SHA256SUMS",
+		Path:     filepath.Join(dir, "preview.pck"),
+	}
+}
+
+func TestFetch(t *testing.T) {
+	content := makeContent(100000)
+	_, ts := newServer(t, content)
+	f := file(ts, t.TempDir())
+	var last Progress
+	f.Progress = func(p Progress) { last = p }
+	if err := f.Fetch(context.Background()); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	got, err := os.ReadFile(f.Path)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("downloaded %d bytes (%v), want the %d of the content", len(got), err, len(content))
+	}
+	for _, leftover := range []string{f.Path + ".part", f.Path + ".part.etag"} {
+		if _, err := os.Stat(leftover); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s left behind", filepath.Base(leftover))
+		}
+	}
+	if last.Done != int64(len(content)) || last.Total != int64(len(content)) {
+		t.Errorf("last progress = %+v, want all %d bytes done", last, len(content))
+	}
+}
+
+// interrupt downloads the first n bytes of the file, as an interrupted
+// download would have left them.
+func interrupt(t *testing.T, f File, n int64) {
+	t.Helper()
+	resp, err := http.Get(f.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	part, err := os.Create(f.Path + ".part")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer part.Close()
+	if _, err := io.CopyN(part, resp.Body, n); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(f.Path+".part.etag", []byte(resp.Header.Get("ETag")), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResume(t *testing.T) {
+	content := makeContent(100000)
+	s, ts := newServer(t, content)
+	f := file(ts, t.TempDir())
+	interrupt(t, f, 60000)
+	s.served.Store(0)
+	var first Progress
+	f.Progress = func(p Progress) {
+		if first.Done == 0 {
+			first = p
+		}
+	}
+	if err := f.Fetch(context.Background()); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if rng := *s.ranges.Load(); rng != "bytes=60000-" {
+		t.Errorf("resumed with Range %q, want bytes=60000-", rng)
+	}
+	if n := s.served.Load(); n != 40000 {
+		t.Errorf("served %d bytes resuming, want the remaining 40000", n)
+	}
+	if first.Done < 60000 {
+		t.Errorf("first progress = %+v, want it to count the bytes resumed from", first)
+	}
+	if got, _ := os.ReadFile(f.Path); !bytes.Equal(got, content) {
+		t.Fatalf("resumed download is not the content")
+	}
+}
+
+func TestResumeChanged(t *testing.T) {
+	s, ts := newServer(t, makeContent(100000))
+	f := file(ts, t.TempDir())
+	interrupt(t, f, 60000)
+	// republished since, so the partial download must not be mixed in.
+	content := makeContent(90000)
+	s.publish(content)
+	if err := f.Fetch(context.Background()); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if got, _ := os.ReadFile(f.Path); !bytes.Equal(got, content) {
+		t.Fatalf("download is not the new content")
+	}
+}
+
+func TestResumeComplete(t *testing.T) {
+	content := makeContent(1000)
+	s, ts := newServer(t, content)
+	f := file(ts, t.TempDir())
+	interrupt(t, f, int64(len(content)))
+	s.served.Store(0)
+	if err := f.Fetch(context.Background()); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if n := s.served.Load(); n != 0 {
+		t.Errorf("served %d bytes of a complete download", n)
+	}
+	if got, _ := os.ReadFile(f.Path); !bytes.Equal(got, content) {
+		t.Fatalf("download is not the content")
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	s, ts := newServer(t, makeContent(1000))
+	bad := strings.Repeat("00", sha256.Size) + "  preview.pck\n"
+	s.manifest.Store(&bad)
+	f := file(ts, t.TempDir())
+	if err := f.Fetch(context.Background()); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("Fetch = %v, want %v", err, ErrChecksum)
+	}
+	for _, path := range []string{f.Path, f.Path + ".part", f.Path + ".part.etag"} {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s exists after a checksum mismatch", filepath.Base(path))
+		}
+	}
+}
+
+func TestNoManifest(t *testing.T) {
+	content := makeContent(1000)
+	_, ts := newServer(t, content)
+	f := file(ts, t.TempDir())
+	f.Manifest = ts.URL + "/missing"
+	if err := f.Fetch(context.Background()); !errors.Is(err, ErrNoChecksum) {
+		t.Fatalf("Fetch = %v, want %v", err, ErrNoChecksum)
+	}
+	if _, err := os.Stat(f.Path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("unverified download put into place")
+	}
+	// with no Manifest at all, the file is not checked.
+	f.Manifest = ""
+	if err := f.Fetch(context.Background()); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if got, _ := os.ReadFile(f.Path); !bytes.Equal(got, content) {
+		t.Fatalf("download is not the content")
+	}
+}
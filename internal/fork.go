@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"encoding/base64"
+	"io/fs"
+	"os"
+	"time"
+
+	"runtime.link/api/xray"
+	"the.quetzal.community/aviary/internal/ice/signalling"
+	"the.quetzal.community/aviary/internal/musical"
+)
+
+// workOffsets returns the length of every part of the work named name, both
+// local and (when available) in the cloud, fs.ErrNotExist if there are none.
+func workOffsets(community signalling.API, cloud bool, name string) (map[signalling.PartID]int64, error) {
+	offsets, err := checkpointOffsets(community, cloud, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(offsets) == 0 {
+		return nil, xray.New(fs.ErrNotExist)
+	}
+	return offsets, nil
+}
+
+// forkWork copies every part of a work, both local and (when available) in the
+// cloud, into a new work that records the original as its provenance, so that
+// it can be remixed without touching the original. If rewrite is true, the
+// copy is reattributed to this device (see musical.Fork).
+func forkWork(community signalling.API, cloud bool, work musical.WorkID, rewrite bool) (musical.WorkID, error) {
+	name := base64.RawURLEncoding.EncodeToString(work[:])
+	offsets, err := workOffsets(community, cloud, name)
+	if err != nil {
+		return musical.WorkID{}, err
+	}
+	// the whole work, as if it had just been checkpointed.
+	snapshot := signalling.Checkpoint{
+		Name:    "fork",
+		Time:    time.Now(),
+		Offsets: offsets,
+	}
+	if thumbnail, err := os.ReadFile(UserDataDir + "/snaps/" + name + ".png"); err == nil {
+		snapshot.Thumbnail = thumbnail
+	}
+	return forkCheckpoint(community, cloud, work, snapshot, rewrite)
+}
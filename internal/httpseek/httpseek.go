@@ -1,23 +1,39 @@
 package httpseek
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // URL to an internet-hosted io.ReadSeekCloser. It uses HTTP range requests to fetch content
 // from the URL on demand and attempts to reuse existing connections where possible.
+//
+// A URL is not safe for concurrent use, except for Close, which may be called from
+// another goroutine to abandon a Read that is in flight.
 type URL struct {
 	url           string
 	modifiedAt    time.Time
+	etag          string
 	contentLength int64
 	currentPos    int64
-	reader        io.ReadCloser
-	closed        bool
+	closed        atomic.Bool
+	multipart     multipartSupport // of the server, see ReadRanges.
+
+	ctx    context.Context // of every request, cancelled by Close.
+	cancel context.CancelFunc
+
+	mutex  sync.Mutex    // guards reader, against a concurrent Close.
+	reader io.ReadCloser // the body of the current range request.
+	abort  context.CancelFunc
 
 	on_modified func(*URL)
 }
@@ -65,38 +81,137 @@ func parseLastModified(h http.Header) time.Time {
 // range support and read the content length. The response body is retained so
 // that a read starting from the beginning reuses the same connection.
 func New(url string) (*URL, error) {
-	req, err := http.NewRequest("GET", url, nil)
+	return NewContext(context.Background(), url)
+}
+
+// NewContext is like [New], with a base context for every request of the URL:
+// once ctx is done, the probe, and any Read in flight or after, fail with its
+// error, as if the URL were closed.
+func NewContext(ctx context.Context, url string) (*URL, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	body, abort := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(body, "GET", url, nil)
 	if err != nil {
+		abort()
+		cancel()
 		return nil, err
 	}
 	resp, err := client.Do(req)
 	if err != nil {
+		abort()
+		cancel()
 		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
+	fail := func(err error) (*URL, error) {
 		resp.Body.Close()
-		return nil, fmt.Errorf("range probe failed: status %d", resp.StatusCode)
+		abort()
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fail(fmt.Errorf("range probe failed: status %d", resp.StatusCode))
 	}
 	acceptRanges := resp.Header.Get("Accept-Ranges")
 	if acceptRanges != "bytes" {
-		resp.Body.Close()
-		return nil, fmt.Errorf("server does not support byte-range requests")
+		return fail(fmt.Errorf("server does not support byte-range requests"))
 	}
 	contentLengthStr := resp.Header.Get("Content-Length")
 	contentLength, err := strconv.ParseInt(contentLengthStr, 10, 64)
 	if err != nil || contentLength <= 0 {
-		resp.Body.Close()
-		return nil, fmt.Errorf("invalid or missing Content-Length")
+		return fail(fmt.Errorf("invalid or missing Content-Length"))
 	}
 	return &URL{
 		url:           url,
 		contentLength: contentLength,
 		reader:        resp.Body,
+		abort:         abort,
 		currentPos:    0,
 		modifiedAt:    parseLastModified(resp.Header),
+		etag:          resp.Header.Get("ETag"),
+		ctx:           ctx,
+		cancel:        cancel,
 	}, nil
 }
 
+// errClosed is returned by the operations of a closed URL.
+var errClosed = errors.New("URLRangeReader is closed")
+
+// setReader replaces the body of the current range request, closing the one
+// before (if any) and cancelling its context with abort.
+func (u *URL) setReader(reader io.ReadCloser, abort context.CancelFunc) {
+	u.mutex.Lock()
+	old, oldAbort := u.reader, u.abort
+	u.reader, u.abort = reader, abort
+	u.mutex.Unlock()
+	if old != nil {
+		old.Close()
+		oldAbort()
+	}
+}
+
+// err returns the error to report for ctx being done, or for u being closed.
+func (u *URL) err(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if u.closed.Load() {
+		return errClosed
+	}
+	return u.ctx.Err()
+}
+
+// ErrResourceChanged is returned by reads that find the underlying resource has
+// changed since it was first (or last) seen, instead of any of its new content.
+// By then, the function set with [URL.OnResourceModified] has run, and the
+// reads that follow are of the new content.
+var ErrResourceChanged = errors.New("httpseek: resource changed")
+
+// ifRange returns the If-Range header to send with range requests, so that a
+// server replies with the whole (changed) resource, rather than a range of it,
+// if it has changed. Empty without a validator to send.
+func (u *URL) ifRange() string {
+	if u.etag != "" && !strings.HasPrefix(u.etag, "W/") {
+		return u.etag
+	}
+	if !u.modifiedAt.IsZero() {
+		return u.modifiedAt.Format(http.TimeFormat)
+	}
+	return ""
+}
+
+// changed reports whether the validators of the response are not those of
+// the resource being read.
+func (u *URL) changed(h http.Header) bool {
+	if etag := h.Get("ETag"); etag != "" && u.etag != "" && etag != u.etag {
+		return true
+	}
+	lastMod := parseLastModified(h)
+	return !lastMod.IsZero() && !lastMod.Equal(u.modifiedAt)
+}
+
+// modified moves on to the version of the resource of the response (with its
+// body closed) and runs the OnResourceModified function, returning
+// ErrResourceChanged for the read that found it.
+func (u *URL) modified(resp *http.Response) error {
+	u.setReader(nil, nil)
+	u.etag = resp.Header.Get("ETag")
+	u.modifiedAt = parseLastModified(resp.Header)
+	var first, last, total int64
+	switch {
+	case resp.StatusCode == http.StatusOK && resp.ContentLength > 0:
+		u.contentLength = resp.ContentLength
+	case resp.StatusCode == http.StatusPartialContent:
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &first, &last, &total); err == nil {
+			u.contentLength = total
+		}
+	}
+	u.currentPos = min(u.currentPos, u.contentLength)
+	if u.on_modified != nil {
+		u.on_modified(u)
+	}
+	return ErrResourceChanged
+}
+
 // LastModifiedAt returns the time the underlying resource was last modified.
 func (u *URL) LastModifiedAt() time.Time {
 	return u.modifiedAt
@@ -112,46 +227,67 @@ func (u *URL) OnResourceModified(f func(*URL)) {
 
 // Read reads up to len(p) bytes into p from the current body.
 func (u *URL) Read(p []byte) (int, error) {
-	if u.closed {
-		return 0, fmt.Errorf("URLRangeReader is closed")
+	return u.ReadContext(u.ctx, p)
+}
+
+// ReadContext is like Read, but gives up once ctx is done, returning its error.
+// The connection is then dropped, and the next read resumes from the position
+// reached with a fresh range request.
+func (u *URL) ReadContext(ctx context.Context, p []byte) (int, error) {
+	if u.closed.Load() {
+		u.setReader(nil, nil)
+		return 0, errClosed
 	}
 	if u.currentPos >= u.contentLength {
 		return 0, io.EOF
 	}
-	if u.reader == nil {
-		req, err := http.NewRequest("GET", u.url, nil)
+	u.mutex.Lock()
+	reader, abort := u.reader, u.abort
+	u.mutex.Unlock()
+	if reader == nil {
+		var body context.Context
+		body, abort = context.WithCancel(u.ctx)
+		stop := context.AfterFunc(ctx, abort)
+		resp, err := u.get(body, fmt.Sprintf("bytes=%d-", u.currentPos))
+		stop()
 		if err != nil {
+			abort()
+			if ctxErr := u.err(ctx); ctxErr != nil {
+				return 0, ctxErr
+			}
 			return 0, err
 		}
-		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", u.currentPos))
-		resp, err := client.Do(req)
-		if err != nil {
-			return 0, err
+		if resp.StatusCode == http.StatusOK || (resp.StatusCode == http.StatusPartialContent && u.changed(resp.Header)) {
+			// the If-Range did not match, so the whole of the new version of
+			// the resource is being sent, none of which is to be mixed in.
+			resp.Body.Close()
+			abort()
+			return 0, u.modified(resp)
 		}
 		if resp.StatusCode != http.StatusPartialContent {
 			resp.Body.Close()
+			abort()
 			return 0, fmt.Errorf("expected status 206 Partial Content, got %d", resp.StatusCode)
 		}
 		expectedLen := u.contentLength - u.currentPos
 		if resp.ContentLength > 0 && resp.ContentLength != expectedLen {
 			resp.Body.Close()
+			abort()
 			return 0, fmt.Errorf("range response Content-Length mismatch: got %d, expected %d", resp.ContentLength, expectedLen)
 		}
-
-		lastMod := parseLastModified(resp.Header)
-		u.reader = resp.Body
-		if !lastMod.IsZero() && !lastMod.Equal(u.modifiedAt) {
-			u.modifiedAt = lastMod
-			if u.on_modified != nil {
-				u.on_modified(u)
-			}
-		}
+		reader = resp.Body
+		u.setReader(reader, abort)
 	}
-	n, err := u.reader.Read(p)
+	// Abandon the body (which Close also does) if ctx is done mid-read.
+	stop := context.AfterFunc(ctx, abort)
+	n, err := reader.Read(p)
+	stop()
 	u.currentPos += int64(n)
 	if err != nil && err != io.EOF {
-		u.reader.Close()
-		u.reader = nil
+		u.setReader(nil, nil)
+		if ctxErr := u.err(ctx); ctxErr != nil {
+			return n, ctxErr
+		}
 		return n, err
 	}
 	if err == io.EOF && u.currentPos < u.contentLength {
@@ -159,17 +295,30 @@ func (u *URL) Read(p []byte) (int, error) {
 		// chunking proxy split the open-ended range). Drop the spent body and
 		// report a short read with no error: the next Read reopens a fresh
 		// range request from the current position and transparently resumes.
-		u.reader.Close()
-		u.reader = nil
+		u.setReader(nil, nil)
 		return n, nil
 	}
 	return n, err
 }
 
+// get requests the range of the resource, with ctx, if it has not changed.
+func (u *URL) get(ctx context.Context, rng string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", rng)
+	if ifRange := u.ifRange(); ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
+	return client.Do(req)
+}
+
 // Seek creates a new HTTP range request to seek to the specified offset.
 func (u *URL) Seek(offset int64, whence int) (int64, error) {
-	if u.closed {
-		return 0, fmt.Errorf("URLRangeReader is closed")
+	if u.closed.Load() {
+		u.setReader(nil, nil)
+		return 0, errClosed
 	}
 	var newPos int64
 	switch whence {
@@ -195,28 +344,32 @@ func (u *URL) Seek(offset int64, whence int) (int64, error) {
 	// open, discard the intervening bytes to reuse the current connection and
 	// save the round trip of a fresh range request. Larger gaps fall through to
 	// a new range request issued lazily on the next Read.
-	if u.reader != nil && newPos > u.currentPos && newPos-u.currentPos <= seekDiscardThreshold {
+	u.mutex.Lock()
+	open := u.reader != nil
+	u.mutex.Unlock()
+	if open && newPos > u.currentPos && newPos-u.currentPos <= seekDiscardThreshold {
 		if _, err := io.CopyN(io.Discard, u, newPos-u.currentPos); err != nil {
 			return 0, err
 		}
 		return u.currentPos, nil
 	}
-	if u.reader != nil {
-		u.reader.Close()
-		u.reader = nil
-	}
+	u.setReader(nil, nil)
 	u.currentPos = newPos
 	return u.currentPos, nil
 }
 
-// Close closes the body.
+// Close closes the body, and abandons any request in flight (even one made by
+// another goroutine, which then fails).
 func (u *URL) Close() error {
-	if u.closed {
+	if u.closed.Swap(true) {
 		return fmt.Errorf("URLRangeReader already closed")
 	}
-	u.closed = true
-	if u.reader != nil {
-		return u.reader.Close()
+	u.cancel()
+	u.mutex.Lock()
+	reader := u.reader
+	u.mutex.Unlock()
+	if reader != nil {
+		return reader.Close()
 	}
 	return nil
 }
@@ -2,14 +2,18 @@ package httpseek
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // makeContent returns n deterministic bytes for read-back comparison.
@@ -377,3 +381,179 @@ func TestSeekErrors(t *testing.T) {
 		t.Fatalf("invalid whence should error")
 	}
 }
+
+// stallingServer supports ranges, but stalls after the first half of each
+// body, until the request is abandoned.
+func stallingServer(content []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := parseStart(r.Header.Get("Range"))
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)-int(start)))
+		if r.Header.Get("Range") != "" {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		body := content[start:]
+		w.Write(body[:len(body)/2])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+}
+
+func TestReadContextTimeout(t *testing.T) {
+	content := makeContent(1000)
+	ts := httptest.NewServer(stallingServer(content))
+	defer ts.Close()
+	defer ts.CloseClientConnections()
+
+	u, err := New(ts.URL)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer u.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	buf := make([]byte, len(content))
+	var got []byte
+	for {
+		n, err := u.ReadContext(ctx, buf)
+		got = append(got, buf[:n]...)
+		if err != nil {
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("ReadContext = %v, want %v", err, context.DeadlineExceeded)
+			}
+			break
+		}
+	}
+	if !bytes.Equal(got, content[:len(got)]) || len(got) != len(content)/2 {
+		t.Fatalf("read %d bytes before stalling, want %d", len(got), len(content)/2)
+	}
+	// the position reached is kept, to resume from.
+	if pos, _ := u.Seek(0, io.SeekCurrent); pos != int64(len(got)) {
+		t.Fatalf("position = %d, want %d", pos, len(got))
+	}
+}
+
+func TestCloseDuringRead(t *testing.T) {
+	content := makeContent(1000)
+	ts := httptest.NewServer(stallingServer(content))
+	defer ts.Close()
+	defer ts.CloseClientConnections()
+
+	u, err := New(ts.URL)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := io.ReadAll(u)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := u.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("Read in flight succeeded after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Read in flight was not abandoned by Close")
+	}
+}
+
+func TestNewContextCancel(t *testing.T) {
+	content := makeContent(1000)
+	ts := httptest.NewServer(stallingServer(content))
+	defer ts.Close()
+	defer ts.CloseClientConnections()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	u, err := NewContext(ctx, ts.URL)
+	if err != nil {
+		t.Fatalf("NewContext: %v", err)
+	}
+	defer u.Close()
+	if _, err := u.Read(make([]byte, 10)); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	cancel()
+	if _, err := io.ReadAll(u); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Read after cancel = %v, want %v", err, context.Canceled)
+	}
+}
+
+// versionedServer serves the current version of its content with
+// http.ServeContent, which honours If-Range. etag selects whether an ETag is
+// sent, or just the Last-Modified time.
+type versionedServer struct {
+	mutex    sync.Mutex
+	versions [][]byte
+	current  int
+	etag     bool
+}
+
+func (s *versionedServer) publish(content []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.versions = append(s.versions, content)
+	s.current = len(s.versions) - 1
+}
+
+func (s *versionedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	content, version := s.versions[s.current], s.current
+	s.mutex.Unlock()
+	modtime := time.Date(2026, 1, 1, 0, 0, version, 0, time.UTC)
+	if s.etag {
+		w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, version))
+		modtime = time.Time{}
+	}
+	http.ServeContent(w, r, "library.pck", modtime, bytes.NewReader(content))
+}
+
+func TestResourceChanged(t *testing.T) {
+	for _, etag := range []bool{true, false} {
+		t.Run(fmt.Sprintf("etag=%v", etag), func(t *testing.T) {
+			old, fresh := makeContent(1000), bytes.Repeat([]byte{'x'}, 800)
+			srv := &versionedServer{etag: etag}
+			srv.publish(old)
+			ts := httptest.NewServer(srv)
+			defer ts.Close()
+
+			u, err := New(ts.URL)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			defer u.Close()
+			var modified int
+			u.OnResourceModified(func(*URL) { modified++ })
+			buf := make([]byte, 100)
+			if _, err := u.Seek(500, io.SeekStart); err != nil {
+				t.Fatalf("Seek: %v", err)
+			}
+			if _, err := io.ReadFull(u, buf); err != nil || !bytes.Equal(buf, old[500:600]) {
+				t.Fatalf("Read = %v, want the old content", err)
+			}
+			srv.publish(fresh)
+			if _, err := u.Seek(100, io.SeekStart); err != nil {
+				t.Fatalf("Seek: %v", err)
+			}
+			n, err := u.Read(buf)
+			if !errors.Is(err, ErrResourceChanged) || n != 0 {
+				t.Fatalf("Read after republishing = %d, %v, want 0, %v", n, err, ErrResourceChanged)
+			}
+			if modified != 1 {
+				t.Fatalf("OnResourceModified ran %d times, want 1", modified)
+			}
+			if end, _ := u.Seek(0, io.SeekEnd); end != int64(len(fresh)) {
+				t.Fatalf("size = %d, want %d of the new content", end, len(fresh))
+			}
+			u.Seek(100, io.SeekStart)
+			if _, err := io.ReadFull(u, buf); err != nil || !bytes.Equal(buf, fresh[100:200]) {
+				t.Fatalf("Read = %v, want the new content", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package httpseek
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Range of bytes of the resource, Length bytes long from Offset.
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+func (r Range) end() int64 { return r.Offset + r.Length }
+
+// RangeReader reads the content of a range, as yielded by [URL.ReadRanges], as
+// if from the resource: it seeks to the offsets of the resource, within the
+// range.
+type RangeReader struct {
+	*bytes.Reader
+	offset int64
+}
+
+// NewRangeReader returns a reader of data, the content of the range r.
+func NewRangeReader(r Range, data []byte) *RangeReader {
+	return &RangeReader{Reader: bytes.NewReader(data), offset: r.Offset}
+}
+
+func (r *RangeReader) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekStart {
+		offset -= r.offset
+	}
+	pos, err := r.Reader.Seek(offset, whence)
+	return pos + r.offset, err
+}
+
+// coalesceGap is the largest gap between two ranges that [URL.ReadRanges]
+// fetches along with them, as a single span, rather than as separate spans.
+const coalesceGap = 4 << 10 // 4 KiB
+
+// maxSpansPerRequest bounds the spans asked for in one multi-range request, to
+// keep the Range header within what servers accept.
+const maxSpansPerRequest = 64
+
+// ReadRanges fetches each of the ranges, in as few round trips as it can, and
+// yields each with its content, in order of their offset. Nearby ranges are
+// coalesced into spans, which are asked for together as multipart/byteranges,
+// when the server supports that, or else one span at a time. A range that can't
+// be read is yielded with nil content (after the rest), and can be retried with
+// [URL.Seek] and [URL.Read]. Like the other methods of URL, it must not be
+// called concurrently with them.
+func (u *URL) ReadRanges(ctx context.Context, ranges []Range) iter.Seq2[Range, []byte] {
+	return func(yield func(Range, []byte) bool) {
+		var failed []Range
+		for batch := range slices.Chunk(coalesce(ranges), maxSpansPerRequest) {
+			if u.closed.Load() {
+				failed = append(failed, requested(batch)...)
+				continue
+			}
+			var (
+				fetched []span
+				err     error
+			)
+			if u.multipart != multipartUnsupported && len(batch) > 1 {
+				fetched, err = u.fetchSpans(ctx, batch)
+			}
+			// fall back to asking for each span not yet fetched on its own.
+			for _, s := range batch {
+				if errors.Is(err, ErrResourceChanged) || ctx.Err() != nil {
+					break
+				}
+				if slices.ContainsFunc(fetched, func(f span) bool { return f.covers(s) }) {
+					continue
+				}
+				var one []span
+				if one, err = u.fetchSpans(ctx, []span{s}); err == nil {
+					fetched = append(fetched, one...)
+				}
+			}
+			for _, s := range batch {
+				for _, r := range s.ranges {
+					data, ok := slice(fetched, r)
+					if !ok {
+						failed = append(failed, r)
+						continue
+					}
+					if !yield(r, data) {
+						return
+					}
+				}
+			}
+		}
+		for _, r := range failed {
+			if !yield(r, nil) {
+				return
+			}
+		}
+	}
+}
+
+// span of the resource, fetched as one, covering the requested ranges in it.
+type span struct {
+	Range
+	ranges []Range
+	data   []byte
+}
+
+func (s span) covers(other span) bool {
+	return s.data != nil && s.Offset <= other.Offset && other.end() <= s.end()
+}
+
+// coalesce the ranges into spans, in order of their offset.
+func coalesce(ranges []Range) []span {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b Range) int { return cmp.Compare(a.Offset, b.Offset) })
+	var spans []span
+	for _, r := range sorted {
+		if n := len(spans); n > 0 && r.Offset <= spans[n-1].end()+coalesceGap {
+			last := &spans[n-1]
+			last.Length = max(last.end(), r.end()) - last.Offset
+			last.ranges = append(last.ranges, r)
+			continue
+		}
+		spans = append(spans, span{Range: r, ranges: []Range{r}})
+	}
+	return spans
+}
+
+// requested returns the ranges that were requested within the spans.
+func requested(spans []span) []Range {
+	var within []Range
+	for _, s := range spans {
+		within = append(within, s.ranges...)
+	}
+	return within
+}
+
+// slice returns the content of the range, from the fetched span covering it.
+func slice(fetched []span, r Range) ([]byte, bool) {
+	for _, f := range fetched {
+		if f.data != nil && f.Offset <= r.Offset && r.end() <= f.end() {
+			return f.data[r.Offset-f.Offset : r.end()-f.Offset], true
+		}
+	}
+	return nil, false
+}
+
+// multipart support of the server, learnt from the first multi-range request.
+type multipartSupport int
+
+const (
+	multipartUnknown multipartSupport = iota
+	multipartSupported
+	multipartUnsupported
+)
+
+// fetchSpans asks for the spans in one request, returning those of them (or
+// of the parts the server chose to reply with instead) that were fetched.
+func (u *URL) fetchSpans(ctx context.Context, spans []span) ([]span, error) {
+	var header strings.Builder
+	header.WriteString("bytes=")
+	for i, s := range spans {
+		if s.Length <= 0 {
+			continue
+		}
+		if i > 0 && header.Len() > len("bytes=") {
+			header.WriteString(",")
+		}
+		fmt.Fprintf(&header, "%d-%d", s.Offset, s.end()-1)
+	}
+	if header.Len() == len("bytes=") {
+		// nothing but empty ranges.
+		return []span{{Range: spans[0].Range, data: []byte{}}}, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(u.ctx, cancel)()
+	resp, err := u.get(ctx, header.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK && len(spans) > 1 && !u.changed(resp.Header):
+		// The server ignored the ranges, and is sending the whole resource, so
+		// no more of them are asked for together.
+		u.multipart = multipartUnsupported
+		return nil, fmt.Errorf("expected status 206 Partial Content, got %d", resp.StatusCode)
+	case resp.StatusCode == http.StatusOK, resp.StatusCode == http.StatusPartialContent && u.changed(resp.Header):
+		// The If-Range did not match, so the remaining spans are not fetched
+		// from the new content.
+		resp.Body.Close()
+		return nil, u.modified(resp)
+	case resp.StatusCode != http.StatusPartialContent:
+		return nil, fmt.Errorf("expected status 206 Partial Content, got %d", resp.StatusCode)
+	}
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "multipart/byteranges" {
+		// A single part: the one span asked for, or the server coalesced
+		// several into one, or only replied with the first of them.
+		if len(spans) > 1 {
+			u.multipart = multipartUnsupported
+		}
+		part, err := readPart(resp.Header.Get("Content-Range"), resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return []span{part}, nil
+	}
+	u.multipart = multipartSupported
+	var parts []span
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		p, err := reader.NextPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return parts, err
+		}
+		part, err := readPart(p.Header.Get("Content-Range"), p)
+		if err != nil {
+			return parts, err
+		}
+		parts = append(parts, part)
+	}
+}
+
+// readPart reads the body of a part with the given Content-Range.
+func readPart(contentRange string, body io.Reader) (span, error) {
+	var first, last, total int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &first, &last, &total); err != nil {
+		// the total may be unknown ("*").
+		if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/*", &first, &last); err != nil {
+			return span{}, fmt.Errorf("invalid Content-Range %q", contentRange)
+		}
+	}
+	if last < first {
+		return span{}, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	data := make([]byte, last-first+1)
+	if _, err := io.ReadFull(body, data); err != nil {
+		return span{}, err
+	}
+	return span{Range: Range{Offset: first, Length: last - first + 1}, data: data}, nil
+}
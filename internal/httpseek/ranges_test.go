@@ -0,0 +1,203 @@
+package httpseek
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// multipartServer serves content with http.ServeContent, which replies to a
+// request for several ranges with multipart/byteranges. requests, if non-nil,
+// counts ranged requests.
+func multipartServer(content []byte, requests *atomic.Int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" && requests != nil {
+			requests.Add(1)
+		}
+		http.ServeContent(w, r, "library.pck", time.Time{}, bytes.NewReader(content))
+	})
+}
+
+// singleRangeServer supports a single range per request. Asked for several, it
+// replies with only the first of them, or, if ignore is set, with the whole
+// resource (as some object stores do).
+func singleRangeServer(content []byte, ignore bool, requests *atomic.Int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		rng := r.Header.Get("Range")
+		if rng == "" || (ignore && strings.Contains(rng, ",")) {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.WriteHeader(http.StatusOK)
+			w.Write(content)
+			return
+		}
+		if requests != nil {
+			requests.Add(1)
+		}
+		first, _, _ := strings.Cut(strings.TrimPrefix(rng, "bytes="), ",")
+		var start, end int
+		fmt.Sscanf(first, "%d-%d", &start, &end)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content[start : end+1])
+	})
+}
+
+// testRanges are small files scattered through a resource, some close enough
+// together to be fetched as one span.
+var testRanges = []Range{
+	{Offset: 90000, Length: 100},
+	{Offset: 10, Length: 20},
+	{Offset: 40, Length: 8},
+	{Offset: 50000, Length: 3000},
+	{Offset: 20000, Length: 1},
+	{Offset: 45, Length: 10}, // overlaps the one before.
+	{Offset: 70000, Length: 0},
+}
+
+func readRanges(t *testing.T, srv http.Handler, content []byte) *URL {
+	t.Helper()
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	u, err := New(ts.URL)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { u.Close() })
+	seen := make(map[Range]bool)
+	for r, data := range u.ReadRanges(context.Background(), testRanges) {
+		if data == nil {
+			t.Errorf("range %v could not be read", r)
+			continue
+		}
+		if want := content[r.Offset:r.end()]; !bytes.Equal(data, want) {
+			t.Errorf("range %v = %d bytes, not its content", r, len(data))
+		}
+		seen[r] = true
+	}
+	for _, r := range testRanges {
+		if !seen[r] {
+			t.Errorf("range %v was not yielded", r)
+		}
+	}
+	return u
+}
+
+func TestReadRangesMultipart(t *testing.T) {
+	content := makeContent(100000)
+	var requests atomic.Int64
+	u := readRanges(t, multipartServer(content, &requests), content)
+	if n := requests.Load(); n != 1 {
+		t.Errorf("read in %d requests, want 1", n)
+	}
+	if u.multipart != multipartSupported {
+		t.Errorf("multipart support = %v, want supported", u.multipart)
+	}
+}
+
+func TestReadRangesFirstOnly(t *testing.T) {
+	content := makeContent(100000)
+	var requests atomic.Int64
+	u := readRanges(t, singleRangeServer(content, false, &requests), content)
+	// of the 5 spans coalesced, one is empty, the first comes back from the
+	// multi-range request, then the other 3 are each asked for on their own.
+	if n := requests.Load(); n != 4 {
+		t.Errorf("read in %d requests, want 4", n)
+	}
+	if u.multipart != multipartUnsupported {
+		t.Errorf("multipart support = %v, want unsupported", u.multipart)
+	}
+	// once known, spans are asked for one at a time from the start.
+	requests.Store(0)
+	for range u.ReadRanges(context.Background(), testRanges) {
+	}
+	if n := requests.Load(); n != 4 {
+		t.Errorf("read again in %d requests, want 4", n)
+	}
+}
+
+func TestReadRangesIgnored(t *testing.T) {
+	content := makeContent(100000)
+	var requests atomic.Int64
+	u := readRanges(t, singleRangeServer(content, true, &requests), content)
+	if n := requests.Load(); n != 4 {
+		t.Errorf("read in %d single-range requests, want 4", n)
+	}
+	if u.multipart != multipartUnsupported {
+		t.Errorf("multipart support = %v, want unsupported", u.multipart)
+	}
+}
+
+func TestReadRangesFailure(t *testing.T) {
+	content := makeContent(1000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content)
+	}))
+	defer ts.Close()
+	u, err := New(ts.URL)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer u.Close()
+	var failed int
+	for _, data := range u.ReadRanges(context.Background(), []Range{{0, 10}, {500, 10}}) {
+		if data == nil {
+			failed++
+		}
+	}
+	if failed != 2 {
+		t.Errorf("%d ranges failed, want 2", failed)
+	}
+}
+
+func TestReadRangesResourceChanged(t *testing.T) {
+	srv := &versionedServer{etag: true}
+	srv.publish(makeContent(100000))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	u, err := New(ts.URL)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer u.Close()
+	var modified int
+	u.OnResourceModified(func(*URL) { modified++ })
+	srv.publish(bytes.Repeat([]byte{'x'}, 100000))
+	for r, data := range u.ReadRanges(context.Background(), testRanges) {
+		if data != nil && r.Length > 0 {
+			t.Errorf("range %v was read from the changed resource", r)
+		}
+	}
+	if modified != 1 {
+		t.Errorf("OnResourceModified ran %d times, want 1", modified)
+	}
+}
+
+func TestRangeReader(t *testing.T) {
+	r := NewRangeReader(Range{Offset: 100, Length: 5}, []byte("hello"))
+	if pos, err := r.Seek(102, io.SeekStart); err != nil || pos != 102 {
+		t.Fatalf("Seek(102) = %d, %v, want 102", pos, err)
+	}
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "llo" {
+		t.Errorf("read %q, %v, want \"llo\"", buf, err)
+	}
+	if pos, err := r.Seek(-1, io.SeekCurrent); err != nil || pos != 104 {
+		t.Errorf("Seek(-1, current) = %d, %v, want 104", pos, err)
+	}
+}
@@ -3,6 +3,7 @@ package signalling
 import (
 	"context"
 	"io"
+	"strings"
 	"time"
 
 	"runtime.link/api"
@@ -22,12 +23,37 @@ type API struct {
 	InsertSave func(context.Context, WorkID, PartID, io.ReadCloser) error   `rest:"POST(application/octet-stream) /saves/{work_id=%v}/{part_id=%v}"`
 	LookupSave func(context.Context, WorkID, PartID) (io.ReadCloser, error) `rest:"GET /saves/{work_id=%v}/{part_id=%v}" mime:"application/octet-stream"`
 
+	// AppendSave appends to a part, only if the part is currently exactly
+	// offset bytes long (a part that does not exist yet is zero bytes long).
+	// Otherwise, nothing is appended and the request fails with 409 Conflict,
+	// after which the whole part should be uploaded with InsertSave instead.
+	AppendSave func(context.Context, WorkID, PartID, int64, io.ReadCloser) error `rest:"POST(application/octet-stream) /saves/{work_id=%v}/{part_id=%v}/append?offset=%v"`
+
+	// DeleteSave deletes a part, for reclaiming the space taken up by a
+	// device that no longer writes to it (once its records are kept elsewhere).
+	// Deleting a part that does not exist succeeds.
+	DeleteSave func(context.Context, WorkID, PartID) error `rest:"DELETE /saves/{work_id=%v}/{part_id=%v}"`
+
 	InsertSnap func(context.Context, WorkID, io.ReadCloser) error   `rest:"POST(application/octet-stream) /snaps/{work_id=%v}"`      // image
 	LookupSnap func(context.Context, WorkID) (io.ReadCloser, error) `rest:"GET /snaps/{work_id=%v}" mime:"application/octet-stream"` // image
+
+	// InsertCheckpoint records a named checkpoint of a work, replacing any
+	// existing checkpoint with the same name.
+	InsertCheckpoint func(context.Context, WorkID, Checkpoint) error     `rest:"POST /checkpoints/{work_id=%v}"`
+	CloudCheckpoints func(context.Context, WorkID) ([]Checkpoint, error) `rest:"GET /checkpoints/{work_id=%v}"`
 }
 
 type WorkID string
 type PartID string
+
+// Device returns the device that writes to the part. A device writes to the
+// part named after it, unless that part was also written to from elsewhere,
+// in which case it carries on in a part named <device>~<suffix>.
+func (part PartID) Device() string {
+	device, _, _ := strings.Cut(string(part), "~")
+	return device
+}
+
 type UserID string
 
 type Part struct {
@@ -35,6 +61,16 @@ type Part struct {
 	Time time.Time `json:"time"`
 }
 
+// Checkpoint is a named version of a work: the length of each of its parts at
+// the moment it was made. Replaying each part only up to its offset reproduces
+// the work as it was.
+type Checkpoint struct {
+	Name      string           `json:"name"`
+	Time      time.Time        `json:"time"`
+	Offsets   map[PartID]int64 `json:"offsets"`
+	Thumbnail []byte           `json:"thumbnail,omitempty"` // PNG image.
+}
+
 type User struct {
 	ID            UserID    `json:"user_id"`
 	TogetherUntil time.Time `json:"together_until"`
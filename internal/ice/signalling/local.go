@@ -0,0 +1,314 @@
+package signalling
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"runtime.link/api"
+	"runtime.link/api/rest"
+	"runtime.link/api/xray"
+)
+
+// Local returns an in-process [API] that stores cloud saves and snapshots under
+// dir, for exercising cloud saves, flight-planner snapshots and their readers
+// without the network. Every call is made as the given user. The layout is
+//
+//	dir/saves/<work_id>/<part_id>
+//	dir/snaps/<work_id>
+//	dir/checkpoints/<work_id>.json
+//
+// and a part's [Part.Time] is the modification time of its file.
+func Local(dir string, user User) API {
+	local := localAPI{dir: dir, user: user}
+	return API{
+		LookupUser: local.LookupUser,
+		CloudSaves: local.CloudSaves,
+		CloudParts: local.CloudParts,
+		InsertSave: local.InsertSave,
+		LookupSave: local.LookupSave,
+		AppendSave: local.AppendSave,
+		DeleteSave: local.DeleteSave,
+		InsertSnap: local.InsertSnap,
+		LookupSnap: local.LookupSnap,
+
+		InsertCheckpoint: local.InsertCheckpoint,
+		CloudCheckpoints: local.CloudCheckpoints,
+	}
+}
+
+// Handler serves the routes of the [API] from impl (for example, a [Local]
+// one), so that it can be served with httptest and imported as usual:
+//
+//	srv := httptest.NewServer(signalling.Handler(signalling.Local(dir, user)))
+//	community := signalling.Imported(srv.URL)
+func Handler(impl API) http.Handler {
+	handler, err := rest.Handler(nil, &impl)
+	if err != nil {
+		// only possible if the API's own rest tags are malformed.
+		panic(err)
+	}
+	return handler
+}
+
+// Imported returns an [API] calling the routes served at host.
+func Imported(host string) API {
+	return api.Import[API](rest.API, host, nil)
+}
+
+type localAPI struct {
+	dir  string
+	user User
+}
+
+// notFound is reported for saves and snaps that do not exist, so that
+// [Handler] responds 404 just like the signalling service.
+type notFound struct{ what string }
+
+func (err notFound) Error() string   { return err.what + " not found" }
+func (err notFound) StatusHTTP() int { return http.StatusNotFound }
+func (err notFound) Is(target error) bool {
+	return target == fs.ErrNotExist
+}
+
+// conflict is reported when an append's expected offset is not the length of
+// the part.
+type conflict struct{ reason string }
+
+func (err conflict) Error() string   { return err.reason }
+func (err conflict) StatusHTTP() int { return http.StatusConflict }
+
+// badRequest is reported for identifiers that cannot name a file.
+type badRequest struct{ reason string }
+
+func (err badRequest) Error() string   { return err.reason }
+func (err badRequest) StatusHTTP() int { return http.StatusBadRequest }
+
+// component checks that an identifier names a single path component, so that
+// a caller can't reach outside of dir.
+func component(kind, id string) error {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return badRequest{fmt.Sprintf("invalid %s %q", kind, id)}
+	}
+	return nil
+}
+
+func (local localAPI) LookupUser(ctx context.Context) (User, error) {
+	return local.user, nil
+}
+
+func (local localAPI) CloudSaves(ctx context.Context) ([]WorkID, error) {
+	entries, err := os.ReadDir(filepath.Join(local.dir, "saves"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	var works []WorkID
+	for _, entry := range entries {
+		if entry.IsDir() {
+			works = append(works, WorkID(entry.Name()))
+		}
+	}
+	return works, nil
+}
+
+func (local localAPI) CloudParts(ctx context.Context, work WorkID) (map[PartID]Part, error) {
+	if err := component("work_id", string(work)); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(local.dir, "saves", string(work)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[PartID]Part{}, nil // yet to be uploaded.
+	}
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	parts := make(map[PartID]Part, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, xray.New(err)
+		}
+		parts[PartID(entry.Name())] = Part{Size: info.Size(), Time: info.ModTime()}
+	}
+	return parts, nil
+}
+
+func (local localAPI) InsertSave(ctx context.Context, work WorkID, part PartID, body io.ReadCloser) error {
+	defer body.Close()
+	if err := component("work_id", string(work)); err != nil {
+		return err
+	}
+	if err := component("part_id", string(part)); err != nil {
+		return err
+	}
+	return local.store(filepath.Join(local.dir, "saves", string(work)), string(part), body)
+}
+
+func (local localAPI) LookupSave(ctx context.Context, work WorkID, part PartID) (io.ReadCloser, error) {
+	if err := component("work_id", string(work)); err != nil {
+		return nil, err
+	}
+	if err := component("part_id", string(part)); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(local.dir, "saves", string(work), string(part)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, notFound{fmt.Sprintf("part %q of work %q", part, work)}
+	}
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	return file, nil
+}
+
+func (local localAPI) AppendSave(ctx context.Context, work WorkID, part PartID, offset int64, body io.ReadCloser) error {
+	defer body.Close()
+	if err := component("work_id", string(work)); err != nil {
+		return err
+	}
+	if err := component("part_id", string(part)); err != nil {
+		return err
+	}
+	dir := filepath.Join(local.dir, "saves", string(work))
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return xray.New(err)
+	}
+	file, err := os.OpenFile(filepath.Join(dir, string(part)), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return xray.New(err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return xray.New(err)
+	}
+	if stat.Size() != offset {
+		return conflict{fmt.Sprintf("part %q of work %q is %d bytes long, not %d", part, work, stat.Size(), offset)}
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return xray.New(err)
+	}
+	if _, err := io.Copy(file, body); err != nil {
+		// don't leave a partial append behind.
+		file.Truncate(offset)
+		return xray.New(err)
+	}
+	return nil
+}
+
+func (local localAPI) DeleteSave(ctx context.Context, work WorkID, part PartID) error {
+	if err := component("work_id", string(work)); err != nil {
+		return err
+	}
+	if err := component("part_id", string(part)); err != nil {
+		return err
+	}
+	// like the imported API, deleting a part that is already gone succeeds.
+	err := os.Remove(filepath.Join(local.dir, "saves", string(work), string(part)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return xray.New(err)
+	}
+	return nil
+}
+
+func (local localAPI) InsertSnap(ctx context.Context, work WorkID, body io.ReadCloser) error {
+	defer body.Close()
+	if err := component("work_id", string(work)); err != nil {
+		return err
+	}
+	return local.store(filepath.Join(local.dir, "snaps"), string(work), body)
+}
+
+func (local localAPI) LookupSnap(ctx context.Context, work WorkID) (io.ReadCloser, error) {
+	if err := component("work_id", string(work)); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(local.dir, "snaps", string(work)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, notFound{fmt.Sprintf("snap of work %q", work)}
+	}
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	return file, nil
+}
+
+func (local localAPI) InsertCheckpoint(ctx context.Context, work WorkID, checkpoint Checkpoint) error {
+	if checkpoint.Name == "" {
+		return badRequest{"checkpoint has no name"}
+	}
+	checkpoints, err := local.CloudCheckpoints(ctx, work)
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range checkpoints {
+		if checkpoints[i].Name == checkpoint.Name {
+			checkpoints[i], replaced = checkpoint, true
+		}
+	}
+	if !replaced {
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	data, err := json.Marshal(checkpoints)
+	if err != nil {
+		return xray.New(err)
+	}
+	return local.store(filepath.Join(local.dir, "checkpoints"), string(work)+".json", bytes.NewReader(data))
+}
+
+func (local localAPI) CloudCheckpoints(ctx context.Context, work WorkID) ([]Checkpoint, error) {
+	if err := component("work_id", string(work)); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(local.dir, "checkpoints", string(work)+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	var checkpoints []Checkpoint
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		return nil, xray.New(err)
+	}
+	return checkpoints, nil
+}
+
+// store replaces dir/name with the body, via a temporary file, so that a
+// failed upload never leaves a truncated save behind.
+func (local localAPI) store(dir, name string, body io.Reader) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return xray.New(err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return xray.New(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return xray.New(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return xray.New(err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return xray.New(err)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package signalling_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"the.quetzal.community/aviary/internal/ice/signalling"
+)
+
+// TestLocal exercises every route of the in-process API, both directly and as
+// served by Handler, so that the two behave the same.
+func TestLocal(t *testing.T) {
+	user := signalling.User{ID: "tester", TogetherUntil: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	t.Run("direct", func(t *testing.T) {
+		testAPI(t, signalling.Local(t.TempDir(), user), user)
+	})
+	t.Run("served", func(t *testing.T) {
+		srv := httptest.NewServer(signalling.Handler(signalling.Local(t.TempDir(), user)))
+		defer srv.Close()
+		testAPI(t, signalling.Imported(srv.URL), user)
+	})
+}
+
+func testAPI(t *testing.T, community signalling.API, user signalling.User) {
+	ctx := context.Background()
+
+	got, err := community.LookupUser(ctx)
+	if err != nil {
+		t.Fatalf("LookupUser: %v", err)
+	}
+	if got.ID != user.ID || !got.TogetherUntil.Equal(user.TogetherUntil) {
+		t.Fatalf("LookupUser = %+v, want %+v", got, user)
+	}
+
+	works, err := community.CloudSaves(ctx)
+	if err != nil {
+		t.Fatalf("CloudSaves: %v", err)
+	}
+	if len(works) != 0 {
+		t.Fatalf("CloudSaves = %v, want none", works)
+	}
+
+	const work = signalling.WorkID("AAECAwQFBgcICQoLDA0ODw")
+	if parts, err := community.CloudParts(ctx, work); err != nil || len(parts) != 0 {
+		t.Fatalf("CloudParts of a work yet to be uploaded = %v, %v, want none", parts, err)
+	}
+	data := map[signalling.PartID][]byte{
+		"laptop": []byte("MUS3 laptop part"),
+		"tablet": []byte("MUS3 tablet part, a little longer"),
+	}
+	for part, body := range data {
+		if err := community.InsertSave(ctx, work, part, io.NopCloser(bytes.NewReader(body))); err != nil {
+			t.Fatalf("InsertSave(%s): %v", part, err)
+		}
+	}
+
+	works, err = community.CloudSaves(ctx)
+	if err != nil {
+		t.Fatalf("CloudSaves: %v", err)
+	}
+	if len(works) != 1 || works[0] != work {
+		t.Fatalf("CloudSaves = %v, want [%s]", works, work)
+	}
+
+	parts, err := community.CloudParts(ctx, work)
+	if err != nil {
+		t.Fatalf("CloudParts: %v", err)
+	}
+	if len(parts) != len(data) {
+		t.Fatalf("CloudParts = %v, want %d parts", parts, len(data))
+	}
+	for part, body := range data {
+		if parts[part].Size != int64(len(body)) {
+			t.Errorf("part %s has size %d, want %d", part, parts[part].Size, len(body))
+		}
+		if parts[part].Time.IsZero() {
+			t.Errorf("part %s has no time", part)
+		}
+		read, err := community.LookupSave(ctx, work, part)
+		if err != nil {
+			t.Fatalf("LookupSave(%s): %v", part, err)
+		}
+		saved, err := io.ReadAll(read)
+		read.Close()
+		if err != nil {
+			t.Fatalf("LookupSave(%s): %v", part, err)
+		}
+		if !bytes.Equal(saved, body) {
+			t.Errorf("LookupSave(%s) = %q, want %q", part, saved, body)
+		}
+	}
+
+	// appending at the acknowledged offset extends the part, appending at any
+	// other offset is refused and leaves it as it was.
+	tail := []byte(" and then some")
+	laptop := int64(len(data["laptop"]))
+	if err := community.AppendSave(ctx, work, "laptop", laptop, io.NopCloser(bytes.NewReader(tail))); err != nil {
+		t.Fatalf("AppendSave: %v", err)
+	}
+	if err := community.AppendSave(ctx, work, "laptop", laptop, io.NopCloser(bytes.NewReader(tail))); err == nil {
+		t.Error("AppendSave at a stale offset succeeded")
+	}
+	if err := community.AppendSave(ctx, work, "phone", 0, io.NopCloser(bytes.NewReader(tail))); err != nil {
+		t.Fatalf("AppendSave to a new part: %v", err)
+	}
+	for part, want := range map[signalling.PartID][]byte{
+		"laptop": append(append([]byte(nil), data["laptop"]...), tail...),
+		"phone":  tail,
+	} {
+		read, err := community.LookupSave(ctx, work, part)
+		if err != nil {
+			t.Fatalf("LookupSave(%s): %v", part, err)
+		}
+		saved, err := io.ReadAll(read)
+		read.Close()
+		if err != nil {
+			t.Fatalf("LookupSave(%s): %v", part, err)
+		}
+		if !bytes.Equal(saved, want) {
+			t.Errorf("after AppendSave, part %s = %q, want %q", part, saved, want)
+		}
+	}
+
+	if err := community.DeleteSave(ctx, work, "phone"); err != nil {
+		t.Fatalf("DeleteSave: %v", err)
+	}
+	if _, err := community.LookupSave(ctx, work, "phone"); err == nil {
+		t.Error("LookupSave of a deleted part succeeded")
+	}
+	if err := community.DeleteSave(ctx, work, "phone"); err != nil {
+		t.Errorf("DeleteSave of a deleted part: %v", err)
+	}
+
+	if _, err := community.LookupSave(ctx, work, "missing"); err == nil {
+		t.Error("LookupSave of a missing part succeeded")
+	}
+	if _, err := community.LookupSnap(ctx, work); err == nil {
+		t.Error("LookupSnap of a missing snap succeeded")
+	}
+
+	snap := []byte("\x89PNG thumbnail")
+	if err := community.InsertSnap(ctx, work, io.NopCloser(bytes.NewReader(snap))); err != nil {
+		t.Fatalf("InsertSnap: %v", err)
+	}
+	read, err := community.LookupSnap(ctx, work)
+	if err != nil {
+		t.Fatalf("LookupSnap: %v", err)
+	}
+	saved, err := io.ReadAll(read)
+	read.Close()
+	if err != nil {
+		t.Fatalf("LookupSnap: %v", err)
+	}
+	if !bytes.Equal(saved, snap) {
+		t.Errorf("LookupSnap = %q, want %q", saved, snap)
+	}
+}
+
+func TestLocalCheckpoints(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(signalling.Handler(signalling.Local(t.TempDir(), signalling.User{})))
+	defer srv.Close()
+	community := signalling.Imported(srv.URL)
+
+	const work = signalling.WorkID("work")
+	first := signalling.Checkpoint{
+		Name:      "first flight",
+		Time:      time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Offsets:   map[signalling.PartID]int64{"laptop": 100, "tablet": 40},
+		Thumbnail: []byte("\x89PNG"),
+	}
+	if err := community.InsertCheckpoint(ctx, work, first); err != nil {
+		t.Fatalf("InsertCheckpoint: %v", err)
+	}
+	second := first
+	second.Offsets = map[signalling.PartID]int64{"laptop": 200}
+	if err := community.InsertCheckpoint(ctx, work, second); err != nil {
+		t.Fatalf("InsertCheckpoint: %v", err)
+	}
+	checkpoints, err := community.CloudCheckpoints(ctx, work)
+	if err != nil {
+		t.Fatalf("CloudCheckpoints: %v", err)
+	}
+	if len(checkpoints) != 1 {
+		t.Fatalf("CloudCheckpoints = %+v, want the checkpoint replaced by name", checkpoints)
+	}
+	got := checkpoints[0]
+	if got.Name != second.Name || !got.Time.Equal(second.Time) || got.Offsets["laptop"] != 200 || len(got.Offsets) != 1 || !bytes.Equal(got.Thumbnail, second.Thumbnail) {
+		t.Errorf("CloudCheckpoints = %+v, want %+v", got, second)
+	}
+}
+
+func TestLocalRejectsPaths(t *testing.T) {
+	ctx := context.Background()
+	community := signalling.Local(t.TempDir(), signalling.User{})
+	for _, work := range []signalling.WorkID{"", ".", "..", "../escape", `a\b`} {
+		if err := community.InsertSave(ctx, work, "part", io.NopCloser(bytes.NewReader(nil))); err == nil {
+			t.Errorf("InsertSave accepted work %q", work)
+		}
+	}
+	if _, err := community.LookupSave(ctx, "work", "part"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LookupSave of a missing part = %v, want fs.ErrNotExist", err)
+	}
+}
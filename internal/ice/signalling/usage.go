@@ -0,0 +1,110 @@
+package signalling
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"runtime.link/api/xray"
+)
+
+// Usage is the cloud storage taken up by a user's works.
+type Usage struct {
+	Size    int64                  `json:"size"`
+	Synced  time.Time              `json:"synced"` // the most recent sync of any part.
+	Works   []WorkUsage            `json:"works"`  // largest first.
+	Devices map[string]DeviceUsage `json:"devices"`
+}
+
+// WorkUsage is the cloud storage taken up by a work.
+type WorkUsage struct {
+	Work    WorkID                 `json:"work_id"`
+	Size    int64                  `json:"size"`
+	Synced  time.Time              `json:"synced"`
+	Parts   map[PartID]Part        `json:"parts"`
+	Devices map[string]DeviceUsage `json:"devices"`
+}
+
+// DeviceUsage is the cloud storage taken up by the parts a device writes to
+// (see [PartID.Device]).
+type DeviceUsage struct {
+	Size   int64     `json:"size"`
+	Synced time.Time `json:"synced"` // when the device last synced any of them.
+	Parts  int       `json:"parts"`
+}
+
+func (usage *DeviceUsage) add(part Part) {
+	usage.Size += part.Size
+	usage.Parts++
+	if part.Time.After(usage.Synced) {
+		usage.Synced = part.Time
+	}
+}
+
+// StorageUsage adds up the cloud storage taken up by each of the user's works,
+// and by each of their devices.
+func StorageUsage(ctx context.Context, community API) (Usage, error) {
+	works, err := community.CloudSaves(ctx)
+	if err != nil {
+		return Usage{}, xray.New(err)
+	}
+	usage := Usage{Devices: make(map[string]DeviceUsage)}
+	for _, work := range works {
+		parts, err := community.CloudParts(ctx, work)
+		if err != nil {
+			return Usage{}, xray.New(err)
+		}
+		stored := WorkStorage(work, parts)
+		usage.Works = append(usage.Works, stored)
+		usage.Size += stored.Size
+		if stored.Synced.After(usage.Synced) {
+			usage.Synced = stored.Synced
+		}
+		for id, part := range parts {
+			device := usage.Devices[id.Device()]
+			device.add(part)
+			usage.Devices[id.Device()] = device
+		}
+	}
+	slices.SortStableFunc(usage.Works, func(a, b WorkUsage) int {
+		return cmp.Compare(b.Size, a.Size)
+	})
+	return usage, nil
+}
+
+// WorkStorage adds up the cloud storage taken up by the parts of a work.
+func WorkStorage(work WorkID, parts map[PartID]Part) WorkUsage {
+	usage := WorkUsage{
+		Work:    work,
+		Parts:   parts,
+		Devices: make(map[string]DeviceUsage),
+	}
+	for id, part := range parts {
+		usage.Size += part.Size
+		if part.Time.After(usage.Synced) {
+			usage.Synced = part.Time
+		}
+		device := usage.Devices[id.Device()]
+		device.add(part)
+		usage.Devices[id.Device()] = device
+	}
+	return usage
+}
+
+// Stale returns the parts of the work, in order, other than own, that were
+// last synced before the given time, by devices that haven't synced any of
+// their parts of the work since.
+func (usage WorkUsage) Stale(own PartID, before time.Time) []PartID {
+	var stale []PartID
+	for _, part := range slices.Sorted(maps.Keys(usage.Parts)) {
+		if part == own || part.Device() == own.Device() {
+			continue
+		}
+		if usage.Devices[part.Device()].Synced.Before(before) {
+			stale = append(stale, part)
+		}
+	}
+	return stale
+}
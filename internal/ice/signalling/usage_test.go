@@ -0,0 +1,74 @@
+package signalling_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"the.quetzal.community/aviary/internal/ice/signalling"
+)
+
+func TestStorageUsage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	community := signalling.Local(dir, signalling.User{})
+
+	old := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	recent := time.Date(2026, 6, 7, 8, 9, 10, 0, time.UTC)
+	saves := []struct {
+		work signalling.WorkID
+		part signalling.PartID
+		size int
+		time time.Time
+	}{
+		{"small", "laptop", 10, recent},
+		{"large", "laptop", 100, recent},
+		{"large", "laptop~1a2b", 20, old},
+		{"large", "tablet", 50, old},
+	}
+	for _, save := range saves {
+		body := io.NopCloser(bytes.NewReader(make([]byte, save.size)))
+		if err := community.InsertSave(ctx, save.work, save.part, body); err != nil {
+			t.Fatalf("InsertSave(%s, %s): %v", save.work, save.part, err)
+		}
+		path := filepath.Join(dir, "saves", string(save.work), string(save.part))
+		if err := os.Chtimes(path, save.time, save.time); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage, err := signalling.StorageUsage(ctx, community)
+	if err != nil {
+		t.Fatalf("StorageUsage: %v", err)
+	}
+	if usage.Size != 180 || !usage.Synced.Equal(recent) {
+		t.Errorf("StorageUsage = %d bytes synced at %v, want 180 bytes synced at %v", usage.Size, usage.Synced, recent)
+	}
+	if len(usage.Works) != 2 || usage.Works[0].Work != "large" || usage.Works[0].Size != 170 || usage.Works[1].Size != 10 {
+		t.Fatalf("StorageUsage works = %+v, want large (170 bytes) then small (10 bytes)", usage.Works)
+	}
+	if laptop := usage.Devices["laptop"]; laptop.Size != 130 || laptop.Parts != 3 || !laptop.Synced.Equal(recent) {
+		t.Errorf("laptop usage = %+v, want 130 bytes in 3 parts, synced at %v", laptop, recent)
+	}
+	if tablet := usage.Devices["tablet"]; tablet.Size != 50 || tablet.Parts != 1 || !tablet.Synced.Equal(old) {
+		t.Errorf("tablet usage = %+v, want 50 bytes in 1 part, synced at %v", tablet, old)
+	}
+
+	// the laptop's forked part is as old as the tablet's, but the laptop
+	// has synced since, so only the tablet's part is stale.
+	large := usage.Works[0]
+	if stale := large.Stale("laptop", recent.Add(-time.Hour)); !slices.Equal(stale, []signalling.PartID{"tablet"}) {
+		t.Errorf("Stale = %v, want [tablet]", stale)
+	}
+	if stale := large.Stale("tablet", recent.Add(time.Hour)); !slices.Equal(stale, []signalling.PartID{"laptop", "laptop~1a2b"}) {
+		t.Errorf("Stale from the tablet = %v, want both of the laptop's parts", stale)
+	}
+	if stale := large.Stale("laptop", old); len(stale) != 0 {
+		t.Errorf("Stale before the oldest sync = %v, want none", stale)
+	}
+}
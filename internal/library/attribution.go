@@ -0,0 +1,169 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Report of the library artwork that a work uses, grouped by license, with
+// what each license obliges anyone publishing the work (e.g. in a video) to do.
+type Report struct {
+	Licenses []Attribution `json:"licenses"`
+}
+
+// Attribution lists the authors whose artwork is used under a license.
+type Attribution struct {
+	License     string   `json:"license"` // "" for authors with an unknown license.
+	Name        string   `json:"name"`
+	ShareAlike  bool     `json:"share_alike,omitempty"` // adaptations must be shared under the same license.
+	Obligations []string `json:"obligations"`
+	Authors     []Credit `json:"authors"`
+}
+
+// Credit for an author, and the designs of theirs that are used.
+type Credit struct {
+	Author      string   `json:"author"` // library author folder.
+	Name        string   `json:"name"`
+	Homepage    string   `json:"homepage,omitempty"`
+	Attribution string   `json:"attribution,omitempty"` // how the author asks to be credited.
+	Designs     []string `json:"designs"`
+}
+
+// attributionOrder lists licenses from most to least demanding, the order
+// they are reported in, with unknown licenses last so that they stand out.
+var attributionOrder = []string{CCBYSA, CCBY, CCZero, ""}
+
+var licenseTerms = map[string]Attribution{
+	CCBYSA: {Name: "CC BY-SA", ShareAlike: true, Obligations: []string{
+		"Credit each author below wherever the work is published.",
+		"Share any adaptation of their artwork under CC BY-SA.",
+	}},
+	CCBY: {Name: "CC BY", Obligations: []string{
+		"Credit each author below wherever the work is published.",
+	}},
+	CCZero: {Name: "CC0", Obligations: []string{
+		"None, the artwork is in the public domain (credit is appreciated).",
+	}},
+	"": {Name: "Unknown license", Obligations: []string{
+		"Check the license of each author below before publishing the work.",
+	}},
+}
+
+// Attribute reports on the library artwork among the given design resource
+// URIs, as imported by a work. URIs outside of the library (such as procedural
+// builtin designs) need no attribution and are skipped.
+func Attribute(uris []string) Report {
+	uses := make([]Use, 0, len(uris))
+	for _, uri := range uris {
+		author := Author(uri)
+		uses = append(uses, Use{URI: uri, Author: author, License: License(author)})
+	}
+	return AttributeUses(uses)
+}
+
+// Use of a library design by a work, under the author and license that it was
+// recorded with.
+type Use struct {
+	URI     string
+	Author  string // "" for designs outside of the library.
+	License string
+}
+
+// AttributeUses reports on the library artwork as it was recorded, rather than
+// under the licenses that the library's authors publish under now (which may
+// have changed since). Uses without an author are skipped.
+func AttributeUses(uses []Use) Report {
+	designs := make(map[string]map[string][]string) // license → author → designs
+	for _, use := range uses {
+		uri, author, license := use.URI, use.Author, use.License
+		if author == "" {
+			continue
+		}
+		if _, known := licenseTerms[license]; !known {
+			license = ""
+		}
+		if designs[license] == nil {
+			designs[license] = make(map[string][]string)
+		}
+		if !slices.Contains(designs[license][author], uri) {
+			designs[license][author] = append(designs[license][author], uri)
+		}
+	}
+	var report Report
+	for _, license := range attributionOrder {
+		authors, ok := designs[license]
+		if !ok {
+			continue
+		}
+		attribution := licenseTerms[license]
+		attribution.License = license
+		for _, author := range slices.Sorted(func(yield func(string) bool) {
+			for author := range authors {
+				if !yield(author) {
+					return
+				}
+			}
+		}) {
+			uris := authors[author]
+			slices.Sort(uris)
+			info, _ := Lookup(author)
+			attribution.Authors = append(attribution.Authors, Credit{
+				Author:      author,
+				Name:        info.Name,
+				Homepage:    info.Homepage,
+				Attribution: info.Attribution,
+				Designs:     uris,
+			})
+		}
+		report.Licenses = append(report.Licenses, attribution)
+	}
+	return report
+}
+
+// ShareAlike reports whether any of the artwork is under a share-alike
+// license, obliging adaptations to be shared under the same license.
+func (report Report) ShareAlike() bool {
+	return slices.ContainsFunc(report.Licenses, func(a Attribution) bool { return a.ShareAlike })
+}
+
+// JSON encodes the report.
+func (report Report) JSON() ([]byte, error) {
+	return json.MarshalIndent(report, "", "\t")
+}
+
+// Markdown formats the report as credits, suitable for a video description.
+func (report Report) Markdown() string {
+	var md strings.Builder
+	md.WriteString("# Credits\n")
+	if len(report.Licenses) == 0 {
+		md.WriteString("\nNo library artwork is used.\n")
+		return md.String()
+	}
+	if report.ShareAlike() {
+		md.WriteString("\n**Share-alike:** some of this artwork is licensed CC BY-SA, so any adaptation of it must be shared under CC BY-SA.\n")
+	}
+	for _, attribution := range report.Licenses {
+		fmt.Fprintf(&md, "\n## %s\n\n", attribution.Name)
+		for _, obligation := range attribution.Obligations {
+			fmt.Fprintf(&md, "> %s\n", obligation)
+		}
+		md.WriteString("\n")
+		for _, credit := range attribution.Authors {
+			if credit.Homepage != "" {
+				fmt.Fprintf(&md, "- **[%s](%s)**", credit.Name, credit.Homepage)
+			} else {
+				fmt.Fprintf(&md, "- **%s**", credit.Name)
+			}
+			if credit.Attribution != "" {
+				fmt.Fprintf(&md, ": %s", credit.Attribution)
+			}
+			md.WriteString("\n")
+			for _, uri := range credit.Designs {
+				fmt.Fprintf(&md, "  - `%s`\n", uri)
+			}
+		}
+	}
+	return md.String()
+}
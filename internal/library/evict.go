@@ -0,0 +1,40 @@
+package library
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"strings"
+
+	"the.quetzal.community/aviary/internal/pck"
+)
+
+// Evict returns the present files of a local library.pck to keep within the
+// budget, in bytes stored (all of them, if budget is zero), evicting the least
+// recently used first, by the Unix time each was last used (never, if absent),
+// and then by path.
+func Evict(local map[string]pck.File, used map[string]int64, budget int64) map[string]bool {
+	keep := make(map[string]bool)
+	var size int64
+	for path, file := range local {
+		if !file.Missing() {
+			keep[path] = true
+			size += file.Stored()
+		}
+	}
+	if budget <= 0 || size <= budget {
+		return keep
+	}
+	paths := slices.Collect(maps.Keys(keep))
+	slices.SortFunc(paths, func(a, b string) int {
+		return cmp.Or(cmp.Compare(used[a], used[b]), strings.Compare(a, b))
+	})
+	for _, path := range paths {
+		if size <= budget {
+			break
+		}
+		delete(keep, path)
+		size -= local[path].Stored()
+	}
+	return keep
+}
@@ -0,0 +1,89 @@
+package library_test
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"the.quetzal.community/aviary/internal/library"
+	"the.quetzal.community/aviary/internal/pck"
+)
+
+func TestEvict(t *testing.T) {
+	present := func(size int64) pck.File { return pck.File{Size: size} }
+	missing := func(size int64) pck.File { return pck.File{Size: size, Flag: pck.FlagMissing} }
+	encrypted := pck.File{Size: 10, Flag: pck.FlagEncrypted}
+	for _, test := range []struct {
+		name   string
+		local  map[string]pck.File
+		used   map[string]int64
+		budget int64
+		keep   []string
+	}{
+		{
+			name:  "no budget",
+			local: map[string]pck.File{"a": present(10), "b": present(10)},
+			keep:  []string{"a", "b"},
+		},
+		{
+			name:   "within budget",
+			local:  map[string]pck.File{"a": present(10), "b": present(10)},
+			budget: 20,
+			keep:   []string{"a", "b"},
+		},
+		{
+			name:   "least recently used first",
+			local:  map[string]pck.File{"a": present(10), "b": present(10), "c": present(10)},
+			used:   map[string]int64{"a": 3, "b": 1, "c": 2},
+			budget: 20,
+			keep:   []string{"a", "c"},
+		},
+		{
+			name:   "never used before used",
+			local:  map[string]pck.File{"a": present(10), "b": present(10)},
+			used:   map[string]int64{"a": 1},
+			budget: 10,
+			keep:   []string{"a"},
+		},
+		{
+			name:   "ties by path",
+			local:  map[string]pck.File{"b": present(10), "a": present(10), "c": present(10)},
+			used:   map[string]int64{"a": 5, "b": 5, "c": 5},
+			budget: 15,
+			keep:   []string{"c"},
+		},
+		{
+			name:   "only until within budget",
+			local:  map[string]pck.File{"big": present(100), "small": present(1), "new": present(10)},
+			used:   map[string]int64{"big": 1, "small": 2, "new": 3},
+			budget: 11,
+			keep:   []string{"new", "small"},
+		},
+		{
+			name:   "missing entries neither kept nor counted",
+			local:  map[string]pck.File{"a": present(10), "gone": missing(1000)},
+			used:   map[string]int64{"gone": 9},
+			budget: 10,
+			keep:   []string{"a"},
+		},
+		{
+			name:   "encrypted by their stored size",
+			local:  map[string]pck.File{"a": encrypted, "b": present(10)},
+			used:   map[string]int64{"a": 2, "b": 1},
+			budget: encrypted.Stored() + 9, // enough for the size of both.
+			keep:   []string{"a"},
+		},
+		{
+			name:   "nothing present",
+			local:  map[string]pck.File{"gone": missing(10)},
+			budget: 10,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			keep := slices.Sorted(maps.Keys(library.Evict(test.local, test.used, test.budget)))
+			if !slices.Equal(keep, test.keep) {
+				t.Errorf("Evict kept %q, want %q", keep, test.keep)
+			}
+		})
+	}
+}
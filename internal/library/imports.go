@@ -0,0 +1,60 @@
+package library
+
+import (
+	"bytes"
+	"strings"
+)
+
+// ImportTargets returns the resources that a Godot .import/.remap file points
+// to (without their "res://" prefix): its path, and any per-platform variants
+// of it (path.s3tc, path.etc2...).
+func ImportTargets(data []byte) []string {
+	var targets []string
+	for line := range bytes.SplitSeq(data, []byte("\n")) {
+		key, value, ok := bytes.Cut(line, []byte("="))
+		if !ok || !(string(key) == "path" || bytes.HasPrefix(key, []byte("path."))) {
+			continue
+		}
+		value = bytes.Trim(bytes.TrimSpace(value), "\"")
+		if target, ok := bytes.CutPrefix(value, []byte("res://")); ok {
+			targets = append(targets, string(target))
+		}
+	}
+	return targets
+}
+
+// SceneDependencies returns the resources that a text scene or resource
+// (.tscn/.tres) depends on, its ext_resources (without their "res://" prefix).
+func SceneDependencies(data []byte) []string {
+	var deps []string
+	for line := range bytes.SplitSeq(data, []byte("\n")) {
+		if !bytes.HasPrefix(line, []byte("[ext_resource ")) {
+			continue
+		}
+		_, value, ok := bytes.Cut(line, []byte(` path="`))
+		if !ok {
+			continue
+		}
+		value, _, _ = bytes.Cut(value, []byte(`"`))
+		if dep, ok := bytes.CutPrefix(value, []byte("res://")); ok {
+			deps = append(deps, string(dep))
+		}
+	}
+	return deps
+}
+
+// Category extracts the library category from a design resource URI (or path
+// without the "res://" prefix) of the form "library/<author>/<category>/<file>".
+// Returns "" for resources outside of a category.
+func Category(uri string) string {
+	rest, ok := strings.CutPrefix(strings.TrimPrefix(uri, "res://"), "library/")
+	if !ok {
+		return ""
+	}
+	_, rest, _ = strings.Cut(rest, "/")
+	category, _, ok := strings.Cut(rest, "/")
+	if !ok {
+		return ""
+	}
+	return category
+}
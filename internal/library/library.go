@@ -0,0 +1,45 @@
+// Package library describes the artwork in The Quetzal Community Library: who
+// made each design, and the license that they publish it under.
+package library
+
+import "strings"
+
+// Licenses, as published by the authors in the library. The string value
+// doubles as the client's license badge asset suffix.
+const (
+	CCZero = "cc-zero"
+	CCBY   = "by"
+	CCBYSA = "by-sa"
+)
+
+// builtin maps each library author folder (res://library/<author>) to the
+// license their assets are distributed under, mirroring the License.txt
+// shipped alongside each author's folder in the library project. It is the
+// fallback for authors missing from the library's own manifest (see Load).
+var builtin = map[string]string{
+	"everything":     CCBY,
+	"kenney":         CCZero,
+	"makehuman":      CCZero,
+	"splizard":       CCZero,
+	"wildfire_games": CCBYSA,
+	"yughues":        CCZero,
+}
+
+// Author extracts the library author from a design resource URI of the form
+// "res://library/<author>/<category>/<file>". Returns "" for non-library
+// resources such as procedural builtin designs.
+func Author(uri string) string {
+	rest, ok := strings.CutPrefix(uri, "res://library/")
+	if !ok {
+		return ""
+	}
+	author, _, _ := strings.Cut(rest, "/")
+	return author
+}
+
+// License returns the license that the library author publishes under, or ""
+// if it isn't known.
+func License(author string) string {
+	info, _ := Lookup(author)
+	return info.License
+}
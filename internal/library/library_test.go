@@ -0,0 +1,163 @@
+package library_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"the.quetzal.community/aviary/internal/library"
+	"the.quetzal.community/aviary/internal/pck"
+)
+
+func TestAuthor(t *testing.T) {
+	for uri, want := range map[string]string{
+		"res://library/kenney/tree/oak.glb": "kenney",
+		"res://library/kenney":              "kenney",
+		"res://builtin/terrain":             "",
+		"":                                  "",
+	} {
+		if got := library.Author(uri); got != want {
+			t.Errorf("Author(%q) = %q, want %q", uri, got, want)
+		}
+	}
+}
+
+func TestAttribute(t *testing.T) {
+	report := library.Attribute([]string{
+		"res://library/kenney/tree/oak.glb",
+		"res://library/wildfire_games/wall/stone.glb",
+		"res://library/everything/bird/quetzal.glb",
+		"res://library/kenney/tree/oak.glb",
+		"res://library/stranger/rock/boulder.glb",
+		"res://builtin/terrain",
+	})
+	var licenses []string
+	for _, attribution := range report.Licenses {
+		licenses = append(licenses, attribution.License)
+	}
+	if got, want := strings.Join(licenses, ","), "by-sa,by,cc-zero,"; got != want {
+		t.Fatalf("licenses = %q, want %q", got, want)
+	}
+	if !report.ShareAlike() || !report.Licenses[0].ShareAlike {
+		t.Error("share-alike obligation not surfaced")
+	}
+	if credits := report.Licenses[2].Authors; len(credits) != 1 || credits[0].Author != "kenney" || len(credits[0].Designs) != 1 {
+		t.Errorf("CC0 credits = %+v, want kenney's oak once", credits)
+	}
+	if credits := report.Licenses[3].Authors; len(credits) != 1 || credits[0].Author != "stranger" {
+		t.Errorf("unknown license credits = %+v, want stranger", credits)
+	}
+
+	md := report.Markdown()
+	for _, want := range []string{"Share-alike", "## CC BY-SA", "**wildfire_games**", "## CC BY", "**everything**", "## Unknown license"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown is missing %q:\n%s", want, md)
+		}
+	}
+	data, err := report.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded library.Report
+	if err := json.Unmarshal(data, &decoded); err != nil || len(decoded.Licenses) != 4 {
+		t.Errorf("JSON round trip = %+v, %v", decoded, err)
+	}
+}
+
+func TestAttributeNothing(t *testing.T) {
+	report := library.Attribute([]string{"res://builtin/terrain"})
+	if len(report.Licenses) != 0 || report.ShareAlike() {
+		t.Errorf("Attribute = %+v, want nothing to credit", report)
+	}
+	if md := report.Markdown(); !strings.Contains(md, "No library artwork") {
+		t.Errorf("Markdown = %q", md)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	if info, ok := library.Lookup("kenney"); !ok || info.License != library.CCZero || info.Name != "kenney" {
+		t.Errorf("built-in Lookup(kenney) = %+v, %v", info, ok)
+	}
+	if _, ok := library.Lookup("newcomer"); ok {
+		t.Fatal("newcomer is known before the manifest is loaded")
+	}
+
+	manifest := `{
+		"newcomer": {"license": "by", "name": "New Comer", "homepage": "https://example.com", "attribution": "Art by New Comer"},
+		"yughues": {"name": "Yughues"}
+	}`
+	data := append([]byte("padding..."), manifest...)
+	index := map[string]pck.File{
+		library.ManifestPath: {Seek: int64(len("padding...")), Size: int64(len(manifest))},
+	}
+	if ok, err := library.LoadPCK(bytes.NewReader(data), index); !ok || err != nil {
+		t.Fatalf("LoadPCK = %v, %v", ok, err)
+	}
+	if ok, err := library.LoadPCK(bytes.NewReader(data), nil); ok || err != nil {
+		t.Errorf("LoadPCK without a manifest = %v, %v", ok, err)
+	}
+
+	if info, ok := library.Lookup("newcomer"); !ok || info.License != library.CCBY || info.Name != "New Comer" {
+		t.Errorf("Lookup(newcomer) = %+v, %v", info, ok)
+	}
+	// the built-in license still applies to authors the manifest leaves it out for.
+	if info, _ := library.Lookup("yughues"); info.License != library.CCZero || info.Name != "Yughues" {
+		t.Errorf("Lookup(yughues) = %+v", info)
+	}
+
+	md := library.Attribute([]string{"res://library/newcomer/bird/finch.glb"}).Markdown()
+	if !strings.Contains(md, "**[New Comer](https://example.com)**: Art by New Comer") {
+		t.Errorf("Markdown does not credit the newcomer as asked:\n%s", md)
+	}
+}
+
+func TestCategory(t *testing.T) {
+	for uri, want := range map[string]string{
+		"res://library/kenney/tree/oak.glb": "tree",
+		"library/kenney/tree/oak.glb":       "tree",
+		"res://library/kenney/icon.png":     "",
+		"res://builtin/terrain":             "",
+	} {
+		if got := library.Category(uri); got != want {
+			t.Errorf("Category(%q) = %q, want %q", uri, got, want)
+		}
+	}
+}
+
+func TestImportTargets(t *testing.T) {
+	data := []byte(`[remap]
+
+importer="texture"
+path.s3tc="res://.godot/imported/oak.png-0123.s3tc.ctex"
+path.etc2="res://.godot/imported/oak.png-0123.etc2.ctex"
+metadata={
+"imported_formats": ["s3tc_bptc", "etc2_astc"]
+}
+
+[deps]
+
+source_file="res://library/kenney/tree/oak.png"
+`)
+	got := library.ImportTargets(data)
+	want := []string{".godot/imported/oak.png-0123.s3tc.ctex", ".godot/imported/oak.png-0123.etc2.ctex"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("ImportTargets = %q, want %q", got, want)
+	}
+}
+
+func TestSceneDependencies(t *testing.T) {
+	data := []byte(`[gd_scene load_steps=3 format=3 uid="uid://b6x1"]
+
+[ext_resource type="PackedScene" uid="uid://c2k9" path="res://library/kenney/tree/oak.glb" id="1_oak"]
+[ext_resource type="Material" path="res://library/kenney/texture/bark.tres" id="2_bark"]
+
+[node name="Oak" instance=ExtResource("1_oak")]
+material_override = ExtResource("2_bark")
+`)
+	got := library.SceneDependencies(data)
+	want := []string{"library/kenney/tree/oak.glb", "library/kenney/texture/bark.tres"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("SceneDependencies = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,78 @@
+package library
+
+import (
+	"encoding/json"
+	"io"
+	"maps"
+	"sync"
+
+	"runtime.link/api/xray"
+	"the.quetzal.community/aviary/internal/pck"
+)
+
+// ManifestPath is where the library's manifest of authors is packed, within
+// library.pck and preview.pck. It is a JSON object of [Info], by author
+// folder, so that adding an author to the library doesn't need a client
+// release.
+const ManifestPath = "library/authors.json"
+
+// Info about a library author.
+type Info struct {
+	License     string `json:"license,omitempty"`     // see [CCZero], [CCBY] and [CCBYSA].
+	Name        string `json:"name,omitempty"`        // display name.
+	Homepage    string `json:"homepage,omitempty"`    // URL.
+	Attribution string `json:"attribution,omitempty"` // how the author asks to be credited.
+}
+
+var registry struct {
+	sync.RWMutex
+	authors map[string]Info
+}
+
+// Load merges a manifest of authors (see [ManifestPath]) into the registry,
+// each author replacing what was previously known about them.
+func Load(manifest io.Reader) error {
+	var authors map[string]Info
+	if err := json.NewDecoder(manifest).Decode(&authors); err != nil {
+		return xray.New(err)
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.authors == nil {
+		registry.authors = make(map[string]Info)
+	}
+	maps.Copy(registry.authors, authors)
+	return nil
+}
+
+// LoadPCK loads the manifest of authors from a .pck, with the given index (see
+// pck.Index), reporting whether it has one.
+func LoadPCK(src io.ReadSeeker, index map[string]pck.File) (bool, error) {
+	file, ok := index[ManifestPath]
+	if !ok || file.Missing() {
+		return false, nil
+	}
+	if _, err := src.Seek(file.Seek, io.SeekStart); err != nil {
+		return false, xray.New(err)
+	}
+	if err := Load(io.LimitReader(src, file.Size)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Lookup returns what is known about a library author, falling back to the
+// built-in licenses (with the author folder as the name) for authors missing
+// from the manifest.
+func Lookup(author string) (Info, bool) {
+	registry.RLock()
+	info, ok := registry.authors[author]
+	registry.RUnlock()
+	if license, known := builtin[author]; known && info.License == "" {
+		info.License, ok = license, true
+	}
+	if info.Name == "" {
+		info.Name = author
+	}
+	return info, ok
+}
@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"graphics.gd/classdb/Engine"
+	"the.quetzal.community/aviary/internal/library"
+	"the.quetzal.community/aviary/internal/pck"
+)
+
+// library.pck only ever grows: each change to the community library reserves
+// space for the changed resources at the end, and writes a new directory after
+// them, leaving the old resources and directories behind. So once enough of it
+// is unreferenced (or, with a budget, it holds too much), a compacted copy is
+// written alongside it. Godot has library.pck mounted by then, so the copy is
+// only swapped in on the next start, before it is mounted again (resources
+// downloaded in the meantime are left out of it, to be downloaded again).
+
+const (
+	libraryCompacted = "/library.pck.compact" // swapped in on the next start.
+	libraryUsed      = "/library.used"        // when each resource was last used.
+
+	// compactThreshold is how much of library.pck must be unreferenced for it
+	// to be worth compacting.
+	compactThreshold = 64 << 20
+
+	// usageSaveInterval is how often the use of library resources is saved.
+	usageSaveInterval = time.Minute
+)
+
+// libraryBudget reads AVIARY_LIBRARY_BUDGET (MiB), the size that downloaded
+// library resources are evicted down to, least recently used first, when
+// library.pck is compacted. Zero (the default) keeps everything downloaded.
+func libraryBudget() int64 {
+	if v := os.Getenv("AVIARY_LIBRARY_BUDGET"); v != "" {
+		if mib, err := strconv.ParseInt(v, 10, 64); err == nil && mib > 0 {
+			return mib << 20
+		}
+	}
+	return 0
+}
+
+// swapCompactedLibrary replaces library.pck with the copy compacted during the
+// last run, if there is one. Must be called before library.pck is mounted.
+func swapCompactedLibrary() {
+	os.Remove(UserDataDir + libraryCompacted + ".tmp")
+	err := os.Rename(UserDataDir+libraryCompacted, UserDataDir+"/library.pck")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		Engine.Raise(fmt.Errorf("failed to swap in compacted library.pck: %w", err))
+	}
+}
+
+// touch records that the resource at path was used, saving when resources were
+// last used every so often.
+func (crl *CommunityResourceLoader) touch(path string) {
+	if crl.used == nil {
+		crl.used = loadLibraryUsage()
+	}
+	now := time.Now()
+	crl.used[path] = now.Unix()
+	if now.Sub(crl.usedSaved) > usageSaveInterval {
+		crl.usedSaved = now
+		saveLibraryUsage(crl.used)
+	}
+}
+
+func loadLibraryUsage() map[string]int64 {
+	used := make(map[string]int64)
+	data, err := os.ReadFile(UserDataDir + libraryUsed)
+	if err != nil {
+		return used
+	}
+	if err := json.Unmarshal(data, &used); err != nil {
+		return make(map[string]int64)
+	}
+	return used
+}
+
+func saveLibraryUsage(used map[string]int64) {
+	data, err := json.Marshal(used)
+	if err != nil {
+		Engine.Raise(err)
+		return
+	}
+	if err := os.WriteFile(UserDataDir+libraryUsed+".tmp", data, 0644); err != nil {
+		Engine.Raise(err)
+		return
+	}
+	if err := os.Rename(UserDataDir+libraryUsed+".tmp", UserDataDir+libraryUsed); err != nil {
+		Engine.Raise(err)
+	}
+}
+
+// compacting is set while a compacted copy of library.pck is being written.
+var compacting atomic.Bool
+
+// compact writes a compacted copy of library.pck (of size bytes), to swap in on
+// the next start, if enough of it is unreferenced or over budget. Resources
+// that no longer match the community library are left out. The copy is
+// written in the background, from a snapshot of the index.
+func (crl *CommunityResourceLoader) compact(size int64) {
+	if crl.local == nil {
+		return
+	}
+	if crl.used == nil {
+		crl.used = loadLibraryUsage()
+	}
+	keep := library.Evict(crl.local, crl.used, libraryBudget())
+	var live, evicted int64
+	for path, file := range crl.local {
+		switch {
+		case file.Missing():
+			live += file.Stored() // reserved again by pck.Append, if dropped.
+		case !keep[path]:
+			evicted++
+		default:
+			if cloud, ok := crl.cloud[path]; ok && cloud.Hash != file.Hash {
+				delete(keep, path)
+				continue
+			}
+			live += file.Stored()
+		}
+	}
+	if size-live < compactThreshold && evicted == 0 {
+		return
+	}
+	if !compacting.CompareAndSwap(false, true) {
+		return
+	}
+	saveLibraryUsage(crl.used)
+	go func() {
+		defer compacting.Store(false)
+		if err := compactLibrary(keep); err != nil {
+			Engine.Raise(fmt.Errorf("failed to compact library.pck: %w", err))
+			return
+		}
+		profMark("library: compacted library.pck (%d MiB unreferenced, %d evicted), swapped in on the next start", (size-live)>>20, evicted)
+	}()
+}
+
+// compactLibrary writes the files of library.pck to keep, into the copy to
+// swap in on the next start.
+func compactLibrary(keep map[string]bool) error {
+	src, err := os.Open(UserDataDir + "/library.pck")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := UserDataDir + libraryCompacted + ".tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = pck.Compact(dst, src, func(path string, file pck.File) bool {
+		return keep[path]
+	})
+	if err == nil {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, UserDataDir+libraryCompacted)
+}
@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"the.quetzal.community/aviary/internal/library"
+	"the.quetzal.community/aviary/internal/pck"
+)
+
+// Offline (with AVIARY_DOWNLOAD=0, or when the community library.pck can't be
+// reached), the library is resolved exclusively from the local library.pck,
+// which can be populated ahead of time, in full or for some authors and
+// categories, with internal/cmd/mirror. Resources that are missing from it
+// can't be loaded, so designs that need them are left out of the design
+// explorer.
+
+// libraryOffline is the local library of the (one) community resource loader,
+// once it is known to be offline.
+var libraryOffline offlineLibrary
+
+// offlineLibrary tells which library resources are available offline. It works
+// from its own copy of the indexes (like [prefetcher]), so it is safe to ask
+// from any goroutine.
+type offlineLibrary struct {
+	mutex sync.Mutex
+
+	offline bool
+	local   map[string]pck.File // of library.pck
+	preview map[string]pck.File // of preview.pck
+
+	checked map[string]bool // whether each design asked about is available.
+}
+
+// reset to offline, with the indexes to resolve resources with. Called on the
+// loader thread, with maps that it then no longer mutates.
+func (o *offlineLibrary) reset(local, preview map[string]pck.File) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.offline = true
+	o.local, o.preview = local, preview
+	o.checked = make(map[string]bool)
+}
+
+// Available reports whether the library resource at uri can be loaded: always,
+// when online, and when offline, only if it (and what its .import/.remap points
+// to, and, for a scene, the library resources it depends on) is in library.pck
+// or preview.pck.
+func (o *offlineLibrary) Available(uri string) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if !o.offline {
+		return true
+	}
+	return o.check(path.Clean(strings.TrimPrefix(uri, "res://")))
+}
+
+// check whether the resource is available, once.
+func (o *offlineLibrary) check(resource string) bool {
+	if available, ok := o.checked[resource]; ok {
+		return available
+	}
+	o.checked[resource] = true // while it is checked, so that cyclic dependencies end.
+	available := o.available(resource)
+	o.checked[resource] = available
+	return available
+}
+
+func (o *offlineLibrary) available(resource string) bool {
+	if strings.HasSuffix(resource, ".region") {
+		// available if the shared material it is a region of is.
+		data, ok := o.read(resource)
+		var sidecar regionSidecar
+		if !ok || json.Unmarshal(data, &sidecar) != nil {
+			return false
+		}
+		return o.check(path.Clean(strings.TrimPrefix(sidecar.Material, "res://")))
+	}
+	if !o.loadable(resource) {
+		return false
+	}
+	switch path.Ext(resource) {
+	case ".tscn", ".tres":
+		// along with the library resources it refers to, such as the
+		// materials that designs share under author/texture.
+		data, _ := o.read(resource)
+		for _, dep := range library.SceneDependencies(data) {
+			if strings.HasPrefix(dep, "library/") && !o.check(dep) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// loadable reports whether the resource, or what its .import/.remap points to,
+// is present.
+func (o *offlineLibrary) loadable(resource string) bool {
+	if o.present(resource) {
+		return true
+	}
+	for _, suffix := range []string{".import", ".remap"} {
+		data, ok := o.read(resource + suffix)
+		if !ok {
+			continue
+		}
+		targets := library.ImportTargets(data)
+		for _, target := range targets {
+			if !o.present(target) {
+				return false
+			}
+		}
+		return len(targets) > 0
+	}
+	return false
+}
+
+// present reports whether the resource at path is in library.pck or
+// preview.pck.
+func (o *offlineLibrary) present(path string) bool {
+	if _, ok := o.preview[path]; ok {
+		return true
+	}
+	file, ok := o.local[path]
+	return ok && !file.Missing()
+}
+
+// read the resource at path from preview.pck, or library.pck.
+func (o *offlineLibrary) read(path string) ([]byte, bool) {
+	name, entry := "/preview.pck", pck.File{}
+	if file, ok := o.preview[path]; ok {
+		entry = file
+	} else if file, ok := o.local[path]; ok && !file.Missing() {
+		name, entry = "/library.pck", file
+	} else {
+		return nil, false
+	}
+	file, err := os.Open(UserDataDir + name)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+	data, err := entry.Bytes(file)
+	return data, err == nil
+}
@@ -0,0 +1,416 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"the.quetzal.community/aviary/internal/httpseek"
+	"the.quetzal.community/aviary/internal/library"
+	"the.quetzal.community/aviary/internal/musical"
+	"the.quetzal.community/aviary/internal/pck"
+)
+
+// Downloading library resources one at a time, as [CommunityResourceLoader]
+// is asked for them, leaves a newly opened scene waiting on a round trip per
+// resource. So as the log of the scene is read, each design it imports is
+// prefetched (along with the resources that its .import/.remap points to),
+// several at a time, into the slots reserved for them in library.pck. Designs
+// that are placed in the scene are fetched before those that are only
+// imported, and a resource that a load is waiting on (but that isn't being
+// fetched yet) is downloaded by the loader thread right away, as before. By
+// the time the scene asks for a resource, it is usually already present. When
+// the scene is left, whatever is still being prefetched for it is abandoned.
+
+// prefetch priorities, soonest first.
+const (
+	prefetchVisible  = iota // placed in the scene.
+	prefetchImported        // imported, but not (yet) placed.
+	prefetchPriorities
+)
+
+// prefetchWorkers is how many library resources are downloaded at once, each
+// over its own connection.
+const prefetchWorkers = 4
+
+// libraryPrefetch is the prefetcher of the (one) community resource loader.
+var libraryPrefetch prefetcher
+
+// prefetcher downloads library resources in the background, by priority. It
+// works from its own copy of the indexes, so that it never touches the maps of
+// the [CommunityResourceLoader], which belong to the loader thread.
+type prefetcher struct {
+	mutex sync.Mutex
+	wake  sync.Cond
+
+	local   map[string]pck.File // slots reserved in library.pck
+	cloud   map[string]pck.File // files of the community library.pck
+	preview map[string]pck.File // files of preview.pck
+
+	queue   [prefetchPriorities][]string
+	fetches map[string]*prefetch
+	designs map[musical.Design]string // import URI of each design
+
+	started bool
+	closed  bool
+
+	ctx    context.Context // of the prefetches of the current scene, see Cancel.
+	cancel context.CancelFunc
+}
+
+// prefetch is the state of a resource, once it has been asked for.
+type prefetch struct {
+	priority int
+	running  bool
+	claimed  bool          // by the loader thread, to download itself.
+	done     chan struct{} // closed once downloaded (or failed).
+	err      error
+}
+
+// reset the indexes to prefetch with, starting the workers the first time.
+// Called on the loader thread, with maps that it then no longer mutates.
+func (p *prefetcher) reset(local, cloud, preview map[string]pck.File) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.local, p.cloud, p.preview = local, cloud, preview
+	if p.fetches == nil {
+		p.fetches = make(map[string]*prefetch)
+		p.designs = make(map[musical.Design]string)
+	}
+	if !p.started && cloud != nil {
+		p.started = true
+		p.wake.L = &p.mutex
+		p.ctx, p.cancel = context.WithCancel(context.Background())
+		for range prefetchWorkers {
+			go p.work()
+		}
+		go func() {
+			<-ShuttingDown
+			p.mutex.Lock()
+			p.closed = true
+			p.cancel()
+			p.mutex.Unlock()
+			p.wake.Broadcast()
+		}()
+	}
+	p.wake.Broadcast()
+}
+
+// Cancel abandons the prefetches of the scene being left: those queued are
+// dropped, and those being downloaded fail (to be downloaded by the loader
+// thread, if they turn out to be needed after all).
+func (p *prefetcher) Cancel() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.started {
+		return
+	}
+	p.cancel()
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	for priority, queue := range p.queue {
+		for _, path := range queue {
+			delete(p.fetches, path)
+		}
+		p.queue[priority] = nil
+	}
+	clear(p.designs)
+}
+
+// Import prefetches the design imported by the log of the scene.
+func (p *prefetcher) Import(imp musical.Import) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.started {
+		return
+	}
+	p.designs[imp.Design] = imp.Import
+	p.want(imp.Import, prefetchImported)
+}
+
+// Placed prefetches the design, ahead of those that are only imported, as it
+// is placed in the scene.
+func (p *prefetcher) Placed(design musical.Design) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if uri, ok := p.designs[design]; ok {
+		p.want(uri, prefetchVisible)
+	}
+}
+
+// want queues the resource at uri to be fetched, or raises its priority if it
+// is already queued.
+func (p *prefetcher) want(uri string, priority int) {
+	clean := path.Clean(strings.TrimPrefix(uri, "res://"))
+	fetch, ok := p.fetches[clean]
+	if !ok {
+		p.fetches[clean] = &prefetch{priority: priority, done: make(chan struct{})}
+		p.queue[priority] = append(p.queue[priority], clean)
+		p.wake.Signal()
+		return
+	}
+	if fetch.running || fetch.claimed || priority >= fetch.priority || p.isDone(fetch) {
+		return
+	}
+	p.queue[fetch.priority] = slices.DeleteFunc(p.queue[fetch.priority], func(queued string) bool { return queued == clean })
+	fetch.priority = priority
+	p.queue[priority] = append(p.queue[priority], clean)
+}
+
+func (p *prefetcher) isDone(fetch *prefetch) bool {
+	select {
+	case <-fetch.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// claim is called by the loader thread, before it downloads the resource at
+// path itself. If the resource is being prefetched, claim waits for it, and
+// reports whether it was downloaded. Otherwise, it is no longer prefetched.
+func (p *prefetcher) claim(path string) bool {
+	p.mutex.Lock()
+	fetch, ok := p.fetches[path]
+	switch {
+	case !ok:
+		p.mutex.Unlock()
+		return false
+	case fetch.running:
+		p.mutex.Unlock()
+		<-fetch.done
+		return fetch.err == nil
+	case p.isDone(fetch):
+		p.mutex.Unlock()
+		return fetch.err == nil && !fetch.claimed
+	}
+	p.queue[fetch.priority] = slices.DeleteFunc(p.queue[fetch.priority], func(queued string) bool { return queued == path })
+	fetch.claimed = true
+	close(fetch.done)
+	p.mutex.Unlock()
+	return false
+}
+
+// next blocks until there is a resource to fetch, returning false once
+// shutting down. Small resources queued behind it, at the same priority, are
+// fetched along with it (see batch), all of them with the context returned.
+func (p *prefetcher) next() (context.Context, []string, []*prefetch, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for {
+		if p.closed {
+			return nil, nil, nil, false
+		}
+		for priority := range p.queue {
+			queue := p.queue[priority]
+			if len(queue) == 0 {
+				continue
+			}
+			paths := []string{queue[0]}
+			queue = queue[1:]
+			if _, small := p.download(paths[0]); small {
+				queue = slices.DeleteFunc(queue, func(path string) bool {
+					if _, small := p.download(path); small && len(paths) < prefetchBatch {
+						paths = append(paths, path)
+						return true
+					}
+					return false
+				})
+			}
+			p.queue[priority] = queue
+			fetches := make([]*prefetch, len(paths))
+			for i, path := range paths {
+				fetches[i] = p.fetches[path]
+				fetches[i].running = true
+			}
+			return p.ctx, paths, fetches, true
+		}
+		p.wake.Wait()
+	}
+}
+
+// prefetchBatch is how many small resources are fetched in one round trip, and
+// prefetchSmall how large each of them may be.
+const (
+	prefetchBatch = 32
+	prefetchSmall = 256 << 10
+)
+
+// download reports whether the resource at path needs to be downloaded, and if
+// so, whether it is small enough to be batched. Called with the mutex held.
+func (p *prefetcher) download(path string) (need, small bool) {
+	next, reserved := p.local[path]
+	prev, inCloud := p.cloud[path]
+	need = reserved && inCloud && next.Missing() && next.Hash == prev.Hash
+	return need, need && prev.Stored() <= prefetchSmall
+}
+
+// work fetches queued resources until shutting down.
+func (p *prefetcher) work() {
+	var cache *httpseek.URL
+	defer func() {
+		if cache != nil {
+			cache.Close()
+		}
+	}()
+	for {
+		ctx, paths, fetches, ok := p.next()
+		if !ok {
+			return
+		}
+		if len(paths) > 1 {
+			p.batch(ctx, &cache, paths)
+		}
+		for i, path := range paths {
+			err := p.fetch(ctx, &cache, path, fetches[i].priority)
+			p.mutex.Lock()
+			fetches[i].running = false
+			fetches[i].err = err
+			close(fetches[i].done)
+			if ctx.Err() != nil && p.fetches[path] == fetches[i] {
+				// cancelled, so prefetched again if a later scene wants it.
+				delete(p.fetches, path)
+			}
+			p.mutex.Unlock()
+		}
+	}
+}
+
+// batch downloads the small resources at paths in one round trip (or as few as
+// the server allows). Any that fail are left missing, for fetch to download on
+// their own.
+func (p *prefetcher) batch(ctx context.Context, cache **httpseek.URL, paths []string) {
+	if *cache == nil {
+		url, err := openLibrary()
+		if err != nil {
+			return
+		}
+		*cache = url
+	}
+	slots := make(map[int64]string)
+	var ranges []httpseek.Range
+	p.mutex.Lock()
+	for _, path := range paths {
+		prev := p.cloud[path]
+		slots[prev.Seek] = path
+		ranges = append(ranges, httpseek.Range{Offset: prev.Seek, Length: prev.Stored()})
+	}
+	p.mutex.Unlock()
+	local, err := os.OpenFile(UserDataDir+"/library.pck", os.O_RDWR, 0644)
+	if err != nil {
+		return
+	}
+	defer local.Close()
+	for r, data := range (*cache).ReadRanges(ctx, ranges) {
+		if data == nil {
+			continue
+		}
+		path := slots[r.Offset]
+		p.mutex.Lock()
+		next, prev := p.local[path], p.cloud[path]
+		p.mutex.Unlock()
+		err := pck.Remap(local, httpseek.NewRangeReader(r, data), next, prev)
+		if errors.Is(err, pck.ErrHashMismatch) {
+			profMark("library: %q failed verification (%d failures so far)", path, libraryVerifyFailures.Add(1))
+		}
+		if err != nil {
+			// left to be fetched again on its own, see fetchLibraryResource.
+			discardSlot(local, next)
+			continue
+		}
+		p.present(path)
+	}
+}
+
+// fetch downloads the resource at path, if it is missing, then queues its
+// .import/.remap (if any), to be fetched like any other resource, or if it is
+// one, the resources that it points to.
+func (p *prefetcher) fetch(ctx context.Context, cache **httpseek.URL, path string, priority int) error {
+	p.mutex.Lock()
+	next, prev := p.local[path], p.cloud[path]
+	need, _ := p.download(path)
+	p.mutex.Unlock()
+	if need {
+		if err := fetchLibraryResource(ctx, cache, path, next, prev, &libraryVerifyFailures); err != nil {
+			profMark("library: prefetch of %q failed: %v", path, err)
+			return err
+		}
+		p.present(path)
+	}
+	if strings.HasSuffix(path, ".import") || strings.HasSuffix(path, ".remap") {
+		targets, err := p.targets(path)
+		if err != nil {
+			return err
+		}
+		p.mutex.Lock()
+		for _, target := range targets {
+			p.want(target, priority)
+		}
+		p.mutex.Unlock()
+		return nil
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, suffix := range []string{".import", ".remap"} {
+		_, inPreview := p.preview[path+suffix]
+		_, inCloud := p.cloud[path+suffix]
+		if inPreview || inCloud {
+			p.want(path+suffix, priority)
+		}
+	}
+	return nil
+}
+
+// present marks the slot for path as downloaded, returning it.
+func (p *prefetcher) present(path string) pck.File {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	file := p.local[path]
+	file.Flag &^= pck.FlagMissing
+	p.local[path] = file
+	return file
+}
+
+// targets returns the resources that the .import/.remap at path points to,
+// reading it from preview.pck, or from library.pck, once it has been
+// downloaded.
+func (p *prefetcher) targets(path string) ([]string, error) {
+	p.mutex.Lock()
+	preview, inPreview := p.preview[path]
+	next, reserved := p.local[path]
+	p.mutex.Unlock()
+	name, entry := "/preview.pck", preview
+	switch {
+	case inPreview:
+	case reserved && !next.Missing():
+		name, entry = "/library.pck", next
+	default:
+		return nil, nil
+	}
+	file, err := os.Open(UserDataDir + name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := entry.Bytes(file)
+	if err != nil {
+		return nil, err
+	}
+	return library.ImportTargets(data), nil
+}
+
+// prefetched is called by the loader thread before it downloads the resource
+// at path, reporting whether it has been prefetched instead.
+func (crl *CommunityResourceLoader) prefetched(path string) bool {
+	if !libraryPrefetch.claim(path) {
+		return false
+	}
+	file := crl.local[path]
+	file.Flag &^= pck.FlagMissing
+	crl.local[path] = file
+	crl.touch(path)
+	return true
+}
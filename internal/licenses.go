@@ -2,7 +2,8 @@ package internal
 
 import (
 	"slices"
-	"strings"
+
+	"the.quetzal.community/aviary/internal/library"
 )
 
 // ccLicense identifies one of the Creative Commons licenses that the
@@ -12,9 +13,9 @@ import (
 type ccLicense string
 
 const (
-	ccZero ccLicense = "cc-zero"
-	ccBY   ccLicense = "by"
-	ccBYSA ccLicense = "by-sa"
+	ccZero ccLicense = library.CCZero
+	ccBY   ccLicense = library.CCBY
+	ccBYSA ccLicense = library.CCBYSA
 )
 
 // ccLicenses lists the license badges shown in the Settings menu, ordered
@@ -22,21 +23,6 @@ const (
 // attribution + share-alike).
 var ccLicenses = []ccLicense{ccZero, ccBY, ccBYSA}
 
-// authorLicense maps each library author folder (res://library/<author>)
-// to the license their assets are distributed under, mirroring the
-// License.txt shipped alongside each author's folder in the library
-// project (which isn't exported into library.pck, so the mapping lives
-// here). Authors missing from this map are never hidden by the license
-// toggles.
-var authorLicense = map[string]ccLicense{
-	"everything":     ccBY,
-	"kenney":         ccZero,
-	"makehuman":      ccZero,
-	"splizard":       ccZero,
-	"wildfire_games": ccBYSA,
-	"yughues":        ccZero,
-}
-
 // licenseHidden reports whether the user has toggled this license's badge
 // off in the Settings menu.
 func licenseHidden(license ccLicense) bool {
@@ -57,22 +43,18 @@ func setLicenseHidden(license ccLicense, hidden bool) {
 
 // authorHidden reports whether an author's artwork should be hidden from
 // the design explorer because the user toggled off the badge for that
-// author's license. Authors with no known license stay visible.
+// author's license (see library.License). Authors with no known license
+// stay visible.
 func authorHidden(name string) bool {
-	license, ok := authorLicense[name]
-	return ok && licenseHidden(license)
+	license := library.License(name)
+	return license != "" && licenseHidden(ccLicense(license))
 }
 
 // designAuthor extracts the library author from a design resource URI of
 // the form "res://library/<author>/<category>/<file>". Returns "" (never
 // hidden) for non-library resources such as procedural builtin designs.
 func designAuthor(uri string) string {
-	rest, ok := strings.CutPrefix(uri, "res://library/")
-	if !ok {
-		return ""
-	}
-	author, _, _ := strings.Cut(rest, "/")
-	return author
+	return library.Author(uri)
 }
 
 // applyLicenseVisibility walks every placed entity and shows/hides it
@@ -0,0 +1,234 @@
+package musical
+
+import (
+	"errors"
+	"io"
+	"math"
+
+	"runtime.link/api/xray"
+)
+
+// Fork writes a new work (into), copied from each of the parts of an existing
+// one (from), to dst. Each part is a .mus3 log, starting with its
+// [MagicHeader], and dst receives a single log holding all of them in order,
+// starting with a [Member] that records the provenance of the fork (see
+// [Provenance]) as made by author.
+//
+// If rewrite is true, every contribution is reattributed to author, as if the
+// forking device had made them all: entities, designs and records are
+// renumbered in the order that they first appear, and sculpts that share a
+// timing are given unique ones.
+func Fork(dst io.Writer, from, into WorkID, author Author, rewrite bool, parts ...io.Reader) error {
+	if _, err := dst.Write([]byte(MagicHeader)); err != nil {
+		return xray.New(err)
+	}
+	buf, err := encode(Member{Record: into, Author: author, Origin: from})
+	if err != nil {
+		return xray.New(err)
+	}
+	if _, err := dst.Write(buf); err != nil {
+		return xray.New(err)
+	}
+	var rw rewriter
+	if rewrite {
+		rw = newRewriter(author)
+	}
+	for _, part := range parts {
+		var header [len(MagicHeader)]byte
+		if _, err := io.ReadFull(part, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				continue // empty part.
+			}
+			return xray.New(err)
+		}
+		if string(header[:]) != MagicHeader {
+			return xray.New(errors.New("invalid musical.Users3DScene file"))
+		}
+		if !rewrite {
+			if _, err := io.Copy(dst, part); err != nil {
+				return xray.New(err)
+			}
+			continue
+		}
+		for {
+			entry, err := decode(part)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return xray.New(err)
+			}
+			if entry, err = rw.rewrite(entry); err != nil {
+				return err
+			}
+			buf, err := encode(entry)
+			if err != nil {
+				return xray.New(err)
+			}
+			if _, err := dst.Write(buf); err != nil {
+				return xray.New(err)
+			}
+		}
+	}
+	return nil
+}
+
+// Provenance returns the work that the .mus3 log was forked from, if it was
+// created by [Fork].
+func Provenance(mus3 io.Reader) (WorkID, bool, error) {
+	var header [len(MagicHeader)]byte
+	if _, err := io.ReadFull(mus3, header[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return WorkID{}, false, nil
+		}
+		return WorkID{}, false, xray.New(err)
+	}
+	if string(header[:]) != MagicHeader {
+		return WorkID{}, false, xray.New(errors.New("invalid musical.Users3DScene file"))
+	}
+	entry, err := decode(mus3)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return WorkID{}, false, nil
+		}
+		return WorkID{}, false, xray.New(err)
+	}
+	member, ok := entry.(Member)
+	if !ok || member.Origin == (WorkID{}) {
+		return WorkID{}, false, nil
+	}
+	return member.Origin, true, nil
+}
+
+// rewriter reattributes the entries of a log to a single author.
+type rewriter struct {
+	author Author
+
+	entities map[Entity]Entity
+	designs  map[Design]Design
+	records  map[Record]Record
+	timings  map[stroke]Timing // sculpts, by their original identity.
+
+	timing Timing // latest timing given to a sculpt.
+}
+
+func newRewriter(author Author) rewriter {
+	return rewriter{
+		author:   author,
+		entities: make(map[Entity]Entity),
+		designs:  make(map[Design]Design),
+		records:  make(map[Record]Record),
+		timings:  make(map[stroke]Timing),
+	}
+}
+
+// stroke is the (Author, Timing) identity of a sculpt.
+type stroke struct {
+	Author Author
+	Timing Timing
+}
+
+var errForkTooLarge = errors.New("too many contributions to reattribute to a single author")
+
+func (rw *rewriter) entity(entity Entity) (Entity, error) {
+	if entity == (Entity{}) {
+		return entity, nil
+	}
+	if mapped, ok := rw.entities[entity]; ok {
+		return mapped, nil
+	}
+	if len(rw.entities) >= math.MaxUint16 {
+		return entity, xray.New(errForkTooLarge)
+	}
+	mapped := Entity{Author: rw.author, Number: uint16(len(rw.entities) + 1)}
+	rw.entities[entity] = mapped
+	return mapped, nil
+}
+
+func (rw *rewriter) design(design Design) (Design, error) {
+	if design == (Design{}) {
+		return design, nil
+	}
+	if mapped, ok := rw.designs[design]; ok {
+		return mapped, nil
+	}
+	if len(rw.designs) >= math.MaxUint16 {
+		return design, xray.New(errForkTooLarge)
+	}
+	mapped := Design{Author: rw.author, Number: uint16(len(rw.designs) + 1)}
+	rw.designs[design] = mapped
+	return mapped, nil
+}
+
+func (rw *rewriter) record(record Record) (Record, error) {
+	if record == (Record{}) {
+		return record, nil
+	}
+	if mapped, ok := rw.records[record]; ok {
+		return mapped, nil
+	}
+	if len(rw.records) >= math.MaxUint16 {
+		return record, xray.New(errForkTooLarge)
+	}
+	mapped := Record{Author: rw.author, Number: uint16(len(rw.records) + 1)}
+	rw.records[record] = mapped
+	return mapped, nil
+}
+
+// sculpt gives a sculpt a timing that is unique once every author is the same,
+// keeping its original timing where it can, so that reverts (which refer to the
+// (Author, Timing) identity of a stroke) still find the stroke they revert.
+func (rw *rewriter) sculpt(brush Sculpt) Timing {
+	if brush.Timing == 0 {
+		return 0
+	}
+	original := stroke{brush.Author, brush.Timing}
+	if mapped, ok := rw.timings[original]; ok {
+		return mapped
+	}
+	rw.timing = max(brush.Timing, rw.timing+1)
+	rw.timings[original] = rw.timing
+	return rw.timing
+}
+
+func (rw *rewriter) rewrite(entry encodable) (encodable, error) {
+	var err error
+	switch entry := entry.(type) {
+	case Member:
+		entry.Author = rw.author
+		return entry, nil
+	case Import:
+		entry.Design, err = rw.design(entry.Design)
+		return entry, err
+	case Upload:
+		entry.Design, err = rw.design(entry.Design)
+		return entry, err
+	case Sculpt:
+		entry.Timing = rw.sculpt(entry)
+		entry.Author = rw.author
+		entry.Design, err = rw.design(entry.Design)
+		return entry, err
+	case Change:
+		entry.Author = rw.author
+		if entry.Entity, err = rw.entity(entry.Entity); err != nil {
+			return entry, err
+		}
+		if entry.Design, err = rw.design(entry.Design); err != nil {
+			return entry, err
+		}
+		entry.Record, err = rw.record(entry.Record)
+		return entry, err
+	case Action:
+		entry.Author = rw.author
+		if entry.Entity, err = rw.entity(entry.Entity); err != nil {
+			return entry, err
+		}
+		if entry.Design, err = rw.design(entry.Design); err != nil {
+			return entry, err
+		}
+		entry.Record, err = rw.record(entry.Record)
+		return entry, err
+	default:
+		return entry, nil
+	}
+}
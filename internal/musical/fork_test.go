@@ -0,0 +1,120 @@
+package musical
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func testLog(t *testing.T, entries ...encodable) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.WriteString(MagicHeader)
+	for _, entry := range entries {
+		data, err := encode(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(data)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func decodeLog(t *testing.T, r io.Reader) []encodable {
+	t.Helper()
+	var header [len(MagicHeader)]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil || string(header[:]) != MagicHeader {
+		t.Fatalf("bad header %q: %v", header, err)
+	}
+	var entries []encodable
+	for {
+		entry, err := decode(r)
+		if errors.Is(err, io.EOF) {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func TestFork(t *testing.T) {
+	from, into := WorkID{1}, WorkID{2}
+	laptop := testLog(t,
+		Import{Design: Design{Author: 1, Number: 1}, Import: "res://library/a.glb"},
+		Change{Author: 1, Entity: Entity{Author: 1, Number: 1}, Design: Design{Author: 1, Number: 1}, Commit: true},
+		Sculpt{Author: 1, Design: Design{Author: 1, Number: 1}, Timing: 100, Commit: true},
+	)
+	tablet := testLog(t,
+		Import{Design: Design{Author: 2, Number: 1}, Import: "res://library/b.glb"},
+		Change{Author: 2, Entity: Entity{Author: 2, Number: 1}, Design: Design{Author: 2, Number: 1}, Commit: true},
+		Change{Author: 2, Entity: Entity{Author: 1, Number: 1}, Design: Design{Author: 1, Number: 1}, Commit: true},
+		Sculpt{Author: 2, Timing: 100, Commit: true},
+		Sculpt{Author: 2, Timing: 100, Commit: true, Revert: true},
+	)
+
+	t.Run("copy", func(t *testing.T) {
+		laptop.Seek(0, io.SeekStart)
+		tablet.Seek(0, io.SeekStart)
+		var out bytes.Buffer
+		if err := Fork(&out, from, into, 9, false, laptop, tablet); err != nil {
+			t.Fatal(err)
+		}
+		entries := decodeLog(t, bytes.NewReader(out.Bytes()))
+		if len(entries) != 9 {
+			t.Fatalf("fork has %d entries, want 9", len(entries))
+		}
+		if want := (Member{Record: into, Author: 9, Origin: from}); entries[0] != want {
+			t.Errorf("provenance = %+v, want %+v", entries[0], want)
+		}
+		if change := entries[5].(Change); change.Author != 2 || change.Entity != (Entity{Author: 2, Number: 1}) {
+			t.Errorf("copy rewrote the authors: %+v", change)
+		}
+		origin, ok, err := Provenance(bytes.NewReader(out.Bytes()))
+		if err != nil || !ok || origin != from {
+			t.Errorf("Provenance = %v, %v, %v, want %v", origin, ok, err, from)
+		}
+	})
+
+	t.Run("rewrite", func(t *testing.T) {
+		laptop.Seek(0, io.SeekStart)
+		tablet.Seek(0, io.SeekStart)
+		var out bytes.Buffer
+		if err := Fork(&out, from, into, 9, true, laptop, tablet); err != nil {
+			t.Fatal(err)
+		}
+		entries := decodeLog(t, bytes.NewReader(out.Bytes()))
+		want := []encodable{
+			Member{Record: into, Author: 9, Origin: from},
+			Import{Design: Design{Author: 9, Number: 1}, Import: "res://library/a.glb"},
+			Change{Author: 9, Entity: Entity{Author: 9, Number: 1}, Design: Design{Author: 9, Number: 1}, Commit: true},
+			Sculpt{Author: 9, Design: Design{Author: 9, Number: 1}, Timing: 100, Commit: true},
+			Import{Design: Design{Author: 9, Number: 2}, Import: "res://library/b.glb"},
+			Change{Author: 9, Entity: Entity{Author: 9, Number: 2}, Design: Design{Author: 9, Number: 2}, Commit: true},
+			Change{Author: 9, Entity: Entity{Author: 9, Number: 1}, Design: Design{Author: 9, Number: 1}, Commit: true},
+			Sculpt{Author: 9, Timing: 101, Commit: true},
+			Sculpt{Author: 9, Timing: 101, Commit: true, Revert: true},
+		}
+		if len(entries) != len(want) {
+			t.Fatalf("fork has %d entries, want %d", len(entries), len(want))
+		}
+		for i := range want {
+			if entries[i] != want[i] {
+				t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+			}
+		}
+	})
+}
+
+func TestProvenanceUnforked(t *testing.T) {
+	_, ok, err := Provenance(testLog(t, Import{Design: Design{Author: 1, Number: 1}, Import: "res://library/a.glb"}))
+	if err != nil || ok {
+		t.Errorf("Provenance of an unforked log = %v, %v", ok, err)
+	}
+	_, ok, err = Provenance(bytes.NewReader(nil))
+	if err != nil || ok {
+		t.Errorf("Provenance of an empty log = %v, %v", ok, err)
+	}
+}
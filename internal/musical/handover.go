@@ -0,0 +1,352 @@
+package musical
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"iter"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"runtime.link/api/xray"
+)
+
+// Handover lets a joined scene survive the loss of its host. While joined, every
+// persisted instruction is also recorded into Storage, so that if this joiner is
+// nominated as the [Successor] it already holds the full log and can [Resume]
+// hosting it. Every other joiner reconnects to the successor's join code.
+type Handover struct {
+	// Storage records the joined scene. Open must return an empty file for
+	// the first open of a session, and the recorded log for later opens.
+	Storage Storage
+
+	// Standby starts hosting a fresh rendezvous for when this joiner is
+	// nominated as the successor, returning its join code and the joiners
+	// that connect to it.
+	Standby func() (string, iter.Seq[Networking], error)
+
+	// Rejoin connects to the successor's join code once the host is lost.
+	Rejoin func(code string) (Networking, error)
+
+	Server string // server identifier, used if this joiner resumes hosting.
+}
+
+// rejoinAttempts bounds how many times a joiner retries the successor's join
+// code (it may still be noticing that the host is gone), waiting rejoinBackoff
+// longer between each attempt.
+const (
+	rejoinAttempts = 5
+	rejoinBackoff  = time.Second
+)
+
+// JoinHandover is like [Join], but follows the host's [Successor] nominations,
+// so that the returned scene carries on (either as the new host, or joined to
+// it) when the host is lost.
+func JoinHandover(network Networking, replica UsersSpace3D, handover Handover) (UsersSpace3D, error) {
+	if err := network.present(); err != nil {
+		return nil, xray.New(err)
+	}
+	f := &follower{
+		handover: handover,
+		replica:  replica,
+		reports:  network.ErrorReports,
+		access:   network.Access,
+		sink:     replica,
+	}
+	f.current.Store(&followed{client{network}})
+	go f.run(network)
+	return f, nil
+}
+
+// follower is the [UsersSpace3D] handed back by [JoinHandover]. Instructions go
+// to whichever scene is current: the host's connection, the successor's once
+// rejoined, or the resumed host once this joiner has taken over.
+type follower struct {
+	handover Handover
+	replica  UsersSpace3D
+	reports  ErrorReporter
+	access   string // room password, presented to the successor as well
+
+	current atomic.Pointer[followed]
+
+	applying sync.Mutex // held to apply what the host sends, which arrives over both connections
+	self     Author
+	seen     uint64       // persisted instructions received
+	skip     uint64       // persisted instructions to skip whilst the successor catches us up
+	file     fs.File      // handover storage, once opened
+	sink     UsersSpace3D // replica, behind the handover storage once it is opened
+
+	mutex     sync.Mutex
+	successor Successor
+	standby   string
+	joiners   iter.Seq[Networking]
+	pending   bool
+}
+
+type followed struct{ UsersSpace3D }
+
+func (f *follower) Member(req Member) error { return f.current.Load().Member(req) }
+func (f *follower) Upload(req Upload) error { return f.current.Load().Upload(req) }
+func (f *follower) Sculpt(req Sculpt) error { return f.current.Load().Sculpt(req) }
+func (f *follower) Import(req Import) error { return f.current.Load().Import(req) }
+func (f *follower) Change(req Change) error { return f.current.Load().Change(req) }
+func (f *follower) Action(req Action) error { return f.current.Load().Action(req) }
+func (f *follower) LookAt(req LookAt) error { return f.current.Load().LookAt(req) }
+
+func (f *follower) run(network Networking) {
+	defer func() {
+		f.applying.Lock()
+		defer f.applying.Unlock()
+		if f.file != nil {
+			f.file.Close()
+		}
+	}()
+	for {
+		err := f.follow(network)
+		next, ok := f.migrate(err)
+		if !ok {
+			return
+		}
+		network = next
+	}
+}
+
+// follow applies the instructions received from the host until its connection
+// is lost, returning why.
+func (f *follower) follow(network Networking) error {
+	go func() {
+		for {
+			packet, err := network.MediaUploads.Recv()
+			if err != nil {
+				return
+			}
+			req, err := decode(bytes.NewReader(packet))
+			if err != nil {
+				f.reports.ReportError(xray.New(err))
+				return
+			}
+			if _, ok := req.(Upload); ok {
+				f.received(req)
+			}
+		}
+	}()
+	for {
+		packet, err := network.Instructions.Recv()
+		if err != nil {
+			return err
+		}
+		req, err := decode(bytes.NewReader(packet))
+		if err != nil {
+			return err
+		}
+		switch v := req.(type) {
+		case Member:
+			if v.Reject != "" {
+				return fmt.Errorf("%w: %s", ErrRejected, v.Reject)
+			}
+			if v.Assign {
+				f.assigned(v)
+				f.replica.Member(v)
+				continue
+			}
+		case Successor:
+			f.nominated(network, v)
+			continue
+		}
+		f.received(req)
+	}
+}
+
+// received applies an instruction from the host to the replica, through the
+// handover storage, so that it is there for us to host, should we succeed it.
+func (f *follower) received(req encodable) {
+	f.applying.Lock()
+	defer f.applying.Unlock()
+	if persisted(req) {
+		if f.skip > 0 {
+			f.skip--
+			return
+		}
+		f.seen++
+	}
+	if err := apply(f.sink, req); err != nil {
+		f.reports.ReportError(xray.New(err))
+	}
+}
+
+// assigned adopts the author a host assigned us. The first assignment opens the
+// handover storage, every one after it comes from a successor, which catches us
+// up on instructions that we already have, so those are skipped.
+func (f *follower) assigned(req Member) {
+	f.applying.Lock()
+	defer f.applying.Unlock()
+	f.self = req.Author
+	f.skip = min(f.seen, req.Number)
+	if f.file != nil || f.handover.Storage == nil {
+		return
+	}
+	file, err := f.handover.Storage.Open(req.Record)
+	if err != nil {
+		f.reports.ReportError(xray.New(err))
+		return
+	}
+	mus3, err := newStorage(file, 0, f.replica)
+	if err != nil {
+		file.Close()
+		f.reports.ReportError(xray.New(err))
+		return
+	}
+	f.file = file
+	f.sink = mus3
+}
+
+// nominated records an accepted [Successor], or, when this joiner is the one
+// being nominated, stands by on a fresh join code and answers the host with it.
+func (f *follower) nominated(network Networking, req Successor) {
+	f.applying.Lock()
+	self := f.self
+	f.applying.Unlock()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if req.Rejoin != "" {
+		f.successor = req
+		return
+	}
+	if req.Author != self || f.handover.Standby == nil || f.handover.Storage == nil {
+		return
+	}
+	if f.standby != "" {
+		req.Rejoin = f.standby
+		if err := network.send(req, false); err != nil {
+			f.reports.ReportError(xray.New(err))
+		}
+		return
+	}
+	if f.pending {
+		return
+	}
+	f.pending = true
+	go func() {
+		code, joiners, err := f.handover.Standby()
+		f.mutex.Lock()
+		f.pending = false
+		if err != nil {
+			f.mutex.Unlock()
+			f.reports.ReportError(xray.New(err))
+			return
+		}
+		f.standby, f.joiners = code, joiners
+		f.mutex.Unlock()
+		req.Rejoin = code
+		if err := network.send(req, false); err != nil {
+			f.reports.ReportError(xray.New(err))
+		}
+	}()
+}
+
+// migrate recovers from the loss of the host: the successor resumes hosting and
+// everyone else rejoins it. Reports false when there is nothing more to follow.
+func (f *follower) migrate(lost error) (Networking, bool) {
+	f.mutex.Lock()
+	successor, joiners := f.successor, f.joiners
+	f.successor = Successor{}
+	f.mutex.Unlock()
+	if successor.Rejoin == "" {
+		f.reports.ReportError(xray.New(lost))
+		return Networking{}, false
+	}
+	f.applying.Lock()
+	self := f.self
+	if successor.Author == self && f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	f.applying.Unlock()
+	if successor.Author == self {
+		// Rejoiners present the room password we joined with, so the
+		// successor asks for the same one, and admits as many as the host did.
+		admitted := func(yield func(Networking) bool) {
+			for network := range joiners {
+				if network.Access == "" {
+					network.Access = f.access
+				}
+				if network.Limit == 0 {
+					network.Limit = int(successor.Limit)
+				}
+				if !yield(network) {
+					return
+				}
+			}
+		}
+		space, _, err := Resume(f.handover.Server, admitted, successor, f.handover.Storage, f.replica, f.reports, self)
+		if err != nil {
+			f.reports.ReportError(xray.New(err))
+			return Networking{}, false
+		}
+		f.current.Store(&followed{space})
+		return Networking{}, false
+	}
+	if f.handover.Rejoin == nil {
+		f.reports.ReportError(xray.New(lost))
+		return Networking{}, false
+	}
+	var errs []error
+	for attempt := range rejoinAttempts {
+		network, err := f.handover.Rejoin(successor.Rejoin)
+		if err == nil {
+			if network.ErrorReports == nil {
+				network.ErrorReports = f.reports
+			}
+			if network.Access == "" {
+				network.Access = f.access
+			}
+			if err := network.present(); err != nil {
+				errs = append(errs, err)
+				network.Instructions.Close()
+				network.MediaUploads.Close()
+				time.Sleep(time.Duration(attempt+1) * rejoinBackoff)
+				continue
+			}
+			f.current.Store(&followed{client{network}})
+			return network, true
+		}
+		errs = append(errs, err)
+		time.Sleep(time.Duration(attempt+1) * rejoinBackoff)
+	}
+	f.reports.ReportError(xray.New(errors.Join(lost, errors.Join(errs...))))
+	return Networking{}, false
+}
+
+// persisted reports whether the instruction is one that storage records (and so
+// one that a host counts and replays to catch up a joiner).
+func persisted(req encodable) bool {
+	if _, ok := req.(Upload); ok {
+		return false // storage does not record uploads, so hosts do not count them.
+	}
+	var tally counter
+	apply(&tally, req)
+	return tally.value > 0
+}
+
+// apply forwards an instruction to the matching method of the scene.
+func apply(scene UsersSpace3D, req encodable) error {
+	switch v := req.(type) {
+	case Member:
+		return scene.Member(v)
+	case Upload:
+		return scene.Upload(v)
+	case Sculpt:
+		return scene.Sculpt(v)
+	case Import:
+		return scene.Import(v)
+	case Change:
+		return scene.Change(v)
+	case Action:
+		return scene.Action(v)
+	case LookAt:
+		return scene.LookAt(v)
+	}
+	return nil
+}
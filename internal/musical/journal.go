@@ -0,0 +1,194 @@
+package musical
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"runtime.link/api/xray"
+)
+
+// Recover truncates the .mus3 log in file to its last complete record, so
+// that a record left partially written by a crash (or the tail of zeros that
+// power loss can leave after the last whole record) doesn't break the next
+// decode. It returns the length of the recovered log, with the file positioned
+// at its start, ready to be read. Anything else that fails to decode is reported rather than
+// truncated, as it may be a record from a newer version.
+func Recover(file *os.File) (int64, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, xray.New(err)
+	}
+	size := stat.Size()
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, xray.New(err)
+	}
+	r := &countingReader{r: bufio.NewReaderSize(file, 1<<16)}
+	var header [len(MagicHeader)]byte
+	intact := int64(0)
+	n, _ := io.ReadFull(r, header[:])
+	if string(header[:n]) != MagicHeader {
+		// a torn header can only be a prefix of ours (possibly followed by zeros).
+		valid := bytes.HasPrefix([]byte(MagicHeader), bytes.TrimRight(header[:n], "\x00"))
+		if valid {
+			zeros, err := zeroTail(file, int64(n), size)
+			if err != nil {
+				return 0, err
+			}
+			valid = zeros
+		}
+		if !valid {
+			return 0, xray.New(errors.New("invalid musical.Users3DScene file"))
+		}
+	} else {
+		intact = r.n
+		for {
+			_, err := decode(r)
+			if err == nil {
+				intact = r.n
+				continue
+			}
+			if errors.Is(err, io.EOF) && r.n == intact {
+				break // a clean end.
+			}
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				zeros, zerr := zeroTail(file, intact, size)
+				if zerr != nil {
+					return 0, zerr
+				}
+				if !zeros {
+					return 0, xray.New(err)
+				}
+			}
+			break
+		}
+	}
+	if intact != size {
+		if err := file.Truncate(intact); err != nil {
+			return 0, xray.New(err)
+		}
+		if err := file.Sync(); err != nil {
+			return 0, xray.New(err)
+		}
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, xray.New(err)
+	}
+	return intact, nil
+}
+
+// zeroTail reports whether the file is all zeros from offset to size.
+func zeroTail(file *os.File, offset, size int64) (bool, error) {
+	r := bufio.NewReader(io.NewSectionReader(file, offset, size-offset))
+	for {
+		b, err := r.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		}
+		if err != nil {
+			return false, xray.New(err)
+		}
+		if b != 0 {
+			return false, nil
+		}
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// Journal appends records to a .mus3 log file (once it has been read to its
+// end, see [Recover]). Each Write is expected to be a whole record, which is
+// either written whole or (should the write fail part way) not at all, and
+// records are synced to disk in batches, at most delay after they are written.
+type Journal struct {
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+	delay time.Duration
+	timer *time.Timer
+	dirty bool
+	err   error // from a batched sync, reported by the next Write or Sync.
+}
+
+// NewJournal appends to the file, which is size bytes long.
+func NewJournal(file *os.File, size int64, delay time.Duration) *Journal {
+	return &Journal{file: file, size: size, delay: delay}
+}
+
+// Write appends the record.
+func (j *Journal) Write(record []byte) (int, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if err := j.err; err != nil {
+		j.err = nil
+		return 0, err
+	}
+	n, err := j.file.WriteAt(record, j.size)
+	if err != nil {
+		// leave no partial record behind.
+		if terr := j.file.Truncate(j.size); terr != nil {
+			err = errors.Join(err, terr)
+		}
+		return 0, xray.New(err)
+	}
+	j.size += int64(n)
+	if !j.dirty {
+		j.dirty = true
+		j.timer = time.AfterFunc(j.delay, func() {
+			j.mutex.Lock()
+			defer j.mutex.Unlock()
+			if err := j.sync(); err != nil {
+				j.err = err
+			}
+		})
+	}
+	return n, nil
+}
+
+// Sync syncs any records written since the last sync to disk.
+func (j *Journal) Sync() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if err := j.err; err != nil {
+		j.err = nil
+		return err
+	}
+	return j.sync()
+}
+
+func (j *Journal) sync() error {
+	if !j.dirty {
+		return nil
+	}
+	j.timer.Stop()
+	j.dirty = false
+	if err := j.file.Sync(); err != nil {
+		return xray.New(err)
+	}
+	return nil
+}
+
+// Size returns the length of the log.
+func (j *Journal) Size() int64 {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.size
+}
+
+// Close syncs the log and closes the file.
+func (j *Journal) Close() error {
+	return errors.Join(j.Sync(), j.file.Close())
+}
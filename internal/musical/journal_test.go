@@ -0,0 +1,140 @@
+package musical
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRecoverTornWrites(t *testing.T) {
+	entries := []encodable{
+		Member{Record: WorkID{1}, Author: 1},
+		Import{Design: Design{Author: 1, Number: 1}, Import: "res://library/a.glb"},
+		Change{Author: 1, Entity: Entity{Author: 1, Number: 1}, Design: Design{Author: 1, Number: 1}, Commit: true},
+		Sculpt{Author: 1, Design: Design{Author: 1, Number: 1}, Timing: 100, Commit: true},
+	}
+	log := []byte(MagicHeader)
+	boundaries := []int{len(log)}
+	for _, entry := range entries {
+		data, err := encode(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		log = append(log, data...)
+		boundaries = append(boundaries, len(log))
+	}
+	next, err := encode(Change{Author: 1, Entity: Entity{Author: 1, Number: 2}, Design: Design{Author: 1, Number: 1}, Commit: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "device.mus3")
+	for _, zeros := range []int{0, 7} {
+		for torn := 0; torn <= len(log); torn++ {
+			// zeros (from a file extended before its data reached the disk) can
+			// only be told apart from a record after a whole record.
+			if zeros > 0 && !slices.Contains(boundaries, torn) && torn > len(MagicHeader) {
+				continue
+			}
+			// the last whole record before the tear (an incomplete header is nothing).
+			intact, records := 0, 0
+			for i, boundary := range boundaries {
+				if boundary <= torn {
+					intact, records = boundary, i
+				}
+			}
+			if err := os.WriteFile(path, append(bytes.Clone(log[:torn]), make([]byte, zeros)...), 0666); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.OpenFile(path, os.O_RDWR, 0666)
+			if err != nil {
+				t.Fatal(err)
+			}
+			size, err := Recover(file)
+			if err != nil {
+				t.Fatalf("torn at %d (+%d zeros): %v", torn, zeros, err)
+			}
+			if size != int64(intact) {
+				t.Fatalf("torn at %d (+%d zeros): recovered %d bytes, want %d", torn, zeros, size, intact)
+			}
+			// the log carries on from where it was recovered.
+			journal := NewJournal(file, size, time.Hour)
+			if size == 0 {
+				if _, err := journal.Write([]byte(MagicHeader)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := journal.Write(next); err != nil {
+				t.Fatal(err)
+			}
+			if err := journal.Close(); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded := decodeLog(t, bytes.NewReader(data))
+			if len(decoded) != records+1 {
+				t.Fatalf("torn at %d (+%d zeros): decoded %d records, want %d", torn, zeros, len(decoded), records+1)
+			}
+			if int64(len(data)) != journal.Size() {
+				t.Fatalf("torn at %d (+%d zeros): journal size %d, file %d", torn, zeros, journal.Size(), len(data))
+			}
+		}
+	}
+}
+
+func TestRecoverRejects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "device.mus3")
+	for name, data := range map[string][]byte{
+		"header": []byte("not a musical file at all"),
+		"record": append([]byte(MagicHeader), 0xff, 1, 2, 3),
+	} {
+		if err := os.WriteFile(path, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.OpenFile(path, os.O_RDWR, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Recover(file); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+		file.Close()
+		if after, _ := os.ReadFile(path); !bytes.Equal(after, data) {
+			t.Errorf("%s: file was modified", name)
+		}
+	}
+}
+
+func TestJournalSyncBatching(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "device.mus3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal := NewJournal(file, 0, time.Millisecond)
+	defer journal.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := journal.Write([]byte(MagicHeader)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	journal.mutex.Lock()
+	dirty := journal.dirty
+	journal.mutex.Unlock()
+	if dirty {
+		t.Fatal("expected the batch to have been synced")
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(file)
+	if len(data) != 3*len(MagicHeader) {
+		t.Fatalf("wrote %d bytes", len(data))
+	}
+}
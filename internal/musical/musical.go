@@ -94,6 +94,20 @@ type Member struct {
 	// "follow author 0" behaviour. Member{Assign} is never persisted, so this
 	// never touches on-disk format.
 	Host Author
+
+	// Access is the room password a joiner presents, before it is assigned an
+	// author, to a host that requires one (see [Networking.Access]). Only
+	// exchanged in that handshake, so it is never persisted either.
+	Access string
+
+	// Origin is the work that this one was forked from, recorded by [Fork]
+	// as the first entry of the fork (see [Provenance]).
+	Origin WorkID
+
+	// Reject is why a host turned a joiner away (such as a wrong room
+	// password, or a full room), sent just before it disconnects the joiner,
+	// which reports it as an [ErrRejected]. Never persisted.
+	Reject string
 }
 
 type Import struct {
@@ -181,6 +195,19 @@ type LookAt struct {
 	Timing Timing        // timing of the viewer.
 }
 
+// Successor nominates the joiner that takes over hosting the scene if the
+// current host is lost. The host sends it (with an empty Rejoin) to the nominee,
+// the nominee answers with the join code it is standing by on, and the host then
+// broadcasts the accepted nomination to every joiner. It is never persisted.
+type Successor struct {
+	Record WorkID // identifier for the user/scene being hosted.
+	Number uint64 // a number of instructions observed by the host.
+	Author Author // joiner nominated to take over as host.
+	Assign Author // last author assigned by the host, so the successor continues after it.
+	Rejoin string // join code the successor is standing by on.
+	Limit  uint8  // most joiners the host admits at once, so the successor does too (0 for no limit).
+}
+
 type entryType uint8
 
 const (
@@ -191,6 +218,7 @@ const (
 	entryTypeCreate
 	entryTypeAttach
 	entryTypeLookAt
+	entryTypeSuccessor
 )
 
 type encodable interface {
@@ -198,22 +226,24 @@ type encodable interface {
 	validateAuthor(Author) bool
 }
 
-func (Member) entryType() entryType { return entryTypeMember }
-func (Import) entryType() entryType { return entryTypeImport }
-func (Upload) entryType() entryType { return entryTypeUpload }
-func (Change) entryType() entryType { return entryTypeCreate }
-func (Action) entryType() entryType { return entryTypeAttach }
-func (Sculpt) entryType() entryType { return entryTypeSculpt }
-func (LookAt) entryType() entryType { return entryTypeLookAt }
+func (Member) entryType() entryType    { return entryTypeMember }
+func (Import) entryType() entryType    { return entryTypeImport }
+func (Upload) entryType() entryType    { return entryTypeUpload }
+func (Change) entryType() entryType    { return entryTypeCreate }
+func (Action) entryType() entryType    { return entryTypeAttach }
+func (Sculpt) entryType() entryType    { return entryTypeSculpt }
+func (LookAt) entryType() entryType    { return entryTypeLookAt }
+func (Successor) entryType() entryType { return entryTypeSuccessor }
 func (orc Member) validateAuthor(author Author) bool {
-	return !orc.Assign && orc.Author == author
+	return !orc.Assign && orc.Author == author && orc.Access == ""
 }
-func (di Import) validateAuthor(author Author) bool  { return true }
-func (du Upload) validateAuthor(author Author) bool  { return true }
-func (con Change) validateAuthor(author Author) bool { return con.Author == author }
-func (rel Action) validateAuthor(author Author) bool { return rel.Author == author }
-func (ats Sculpt) validateAuthor(author Author) bool { return ats.Author == author }
-func (bev LookAt) validateAuthor(author Author) bool { return bev.Author == author }
+func (di Import) validateAuthor(author Author) bool     { return true }
+func (du Upload) validateAuthor(author Author) bool     { return true }
+func (con Change) validateAuthor(author Author) bool    { return con.Author == author }
+func (rel Action) validateAuthor(author Author) bool    { return rel.Author == author }
+func (ats Sculpt) validateAuthor(author Author) bool    { return ats.Author == author }
+func (bev LookAt) validateAuthor(author Author) bool    { return bev.Author == author }
+func (suc Successor) validateAuthor(author Author) bool { return suc.Author == author }
 
 // encode/decode pack a struct's "which fields are present" mask
 // into a uint16 layout word.
@@ -321,6 +351,8 @@ func decode(r io.Reader) (encodable, error) {
 		v = reflect.New(reflect.TypeOf(Sculpt{})).Elem()
 	case entryTypeLookAt:
 		v = reflect.New(reflect.TypeOf(LookAt{})).Elem()
+	case entryTypeSuccessor:
+		v = reflect.New(reflect.TypeOf(Successor{})).Elem()
 	default:
 		return nil, xray.New(errors.New("unknown entry type " + fmt.Sprint(et)))
 	}
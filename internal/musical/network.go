@@ -2,9 +2,12 @@ package musical
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"errors"
+	"fmt"
 	"io"
 	"iter"
+	"time"
 
 	"runtime.link/api/xray"
 )
@@ -20,12 +23,26 @@ type Networking struct {
 	Instructions Connection
 	MediaUploads Connection
 	ErrorReports ErrorReporter
+
+	// Access is the room password of the connection. A joiner presents it
+	// (as a [Member]) before anything else, and a host only assigns an author
+	// to a joiner that presented it. Empty for rooms without a password.
+	Access string
+
+	// Limit is the most joiners that a host admits at once, counting those
+	// that it has assigned an author to, and not those still to present the
+	// room password. A joiner past it is turned away. Zero for no limit.
+	Limit int
 }
 
 type ErrorReporter interface {
 	ReportError(error)
 }
 
+// ErrRejected is reported by a joiner that the host turned away, along with the
+// [Member.Reject] reason that the host gave.
+var ErrRejected = errors.New("rejected by the host")
+
 func (network Networking) send(val encodable, media bool) error {
 	packet, err := encode(val)
 	if err != nil {
@@ -43,7 +60,19 @@ func (network Networking) send(val encodable, media bool) error {
 	return nil
 }
 
+// present sends the room password, if any, that the host checks before it
+// assigns us an author.
+func (network Networking) present() error {
+	if network.Access == "" {
+		return nil
+	}
+	return network.send(Member{Access: network.Access}, false)
+}
+
 func Join(network Networking, userID WorkID, replica UsersSpace3D) (UsersSpace3D, error) {
+	if err := network.present(); err != nil {
+		return nil, xray.New(err)
+	}
 	scene := client{network}
 	go scene.handle(replica)
 	return scene, nil
@@ -95,6 +124,10 @@ func (c client) handle(replica UsersSpace3D) {
 		}
 		switch v := req.(type) {
 		case Member:
+			if v.Reject != "" {
+				c.ErrorReports.ReportError(xray.New(fmt.Errorf("%w: %s", ErrRejected, v.Reject)))
+				return
+			}
 			replica.Member(v)
 		case Sculpt:
 			replica.Sculpt(v)
@@ -106,6 +139,8 @@ func (c client) handle(replica UsersSpace3D) {
 			replica.Action(v)
 		case LookAt:
 			replica.LookAt(v)
+		case Successor:
+			// only followed by [JoinHandover].
 		default:
 			return
 		}
@@ -119,21 +154,53 @@ func (c client) handle(replica UsersSpace3D) {
 // sequential authors and told `self` (via Member.Host) so they can follow the
 // host's clock.
 func Host(name string, network iter.Seq[Networking], initial WorkID, storage Storage, replica UsersSpace3D, reports ErrorReporter, self Author) (UsersSpace3D, chan<- WorkID, error) {
-	var srv = server{
+	return serve(network, server{
 		name: name,
 		self: self,
 
 		initial: initial,
 		storage: storage,
 		replica: replica,
-		clients: make(chan Networking),
-		changes: make(chan WorkID),
-		request: make(chan encodable),
 		reports: reports,
-	}
+	})
+}
+
+// Resume continues hosting a scene from an accepted [Successor] nomination once
+// the host that made it has been lost. `self` must be the nominated author, and
+// storage must already hold the log that the successor recorded while it was
+// joined (see [Handover]): that log is counted, so the original record count
+// carries on, but it is not replayed into the replica, which already reflects it.
+// New joiners are assigned authors after [Successor.Assign], up to the
+// [Successor.Limit] of the host that was lost.
+func Resume(name string, network iter.Seq[Networking], from Successor, storage Storage, replica UsersSpace3D, reports ErrorReporter, self Author) (UsersSpace3D, chan<- WorkID, error) {
+	return serve(network, server{
+		name: name,
+		self: self,
+
+		initial: from.Record,
+		storage: storage,
+		replica: replica,
+		reports: reports,
+		assign:  from.Assign,
+		limit:   int(from.Limit),
+		resume:  true,
+	})
+}
+
+func serve(network iter.Seq[Networking], srv server) (UsersSpace3D, chan<- WorkID, error) {
+	srv.clients = make(chan Networking)
+	srv.changes = make(chan WorkID)
+	srv.request = make(chan encodable)
+	srv.admitted = make(chan Networking)
+	srv.left = make(chan Networking)
+	srv.done = make(chan struct{})
 	go func() {
 		for client := range network {
-			srv.clients <- client
+			select {
+			case srv.clients <- client:
+			case <-srv.done:
+				return
+			}
 		}
 		close(srv.clients)
 	}()
@@ -141,6 +208,15 @@ func Host(name string, network iter.Seq[Networking], initial WorkID, storage Sto
 	return channel(srv.request), srv.changes, nil
 }
 
+// admitTimeout bounds how long a host waits for a joiner to present the room
+// password before giving up on it.
+const admitTimeout = 30 * time.Second
+
+// handoverInterval is how often the host re-broadcasts its [Successor], so that
+// every joiner holds a recent record count and author assignment, and a nominee
+// that has gone away is replaced.
+const handoverInterval = 15 * time.Second
+
 type server struct {
 	name string
 	self Author // author the host adopts for its own contributions
@@ -152,10 +228,19 @@ type server struct {
 	clients chan Networking
 	changes chan WorkID
 	request chan encodable
+
+	admitted chan Networking // joiners that presented the room password
+	left     chan Networking // joiners that have disconnected
+	done     chan struct{}   // closed once run has returned, so nothing more is received.
+	assign   Author          // last author assigned, when resuming from a [Successor].
+	limit    int             // most joiners admitted at once, when resuming from a [Successor].
+	resume   bool            // if true, the storage is counted but not replayed into the replica.
 }
 
 func (srv server) run() {
-	var assign Author
+	defer close(srv.done)
+	var assign = srv.assign
+	var limit = srv.limit // of the latest joiner, as the host last set it
 	var authors = make(map[Author]Member)
 	var clients = make(map[Networking]Author)
 	var current = srv.initial
@@ -169,11 +254,55 @@ func (srv server) run() {
 	defer func() {
 		store.Close()
 	}()
-	mus3, err := newStorage(store, 0, Compose(&tracker, srv.replica))
+	replay := composition{&tracker, srv.replica}
+	if srv.resume {
+		// The successor's replica already holds the log being resumed, so it
+		// is only counted here; the replica is swapped back in for anything new.
+		replay[1] = Stubbed{}
+	}
+	mus3, err := newStorage(store, 0, replay)
 	if err != nil {
 		srv.reports.ReportError(xray.New(err))
 		return
 	}
+	replay[1] = srv.replica
+	var nominee Successor
+	// nominate picks the longest-connected live joiner (the lowest author) as
+	// the successor and asks it to stand by. Once it has answered with a join
+	// code, the nomination is broadcast to every joiner instead.
+	nominate := func() {
+		var pick Author
+		var next Networking
+		for client, author := range clients {
+			if pick == 0 || author < pick {
+				pick, next = author, client
+			}
+		}
+		if pick == 0 {
+			nominee = Successor{}
+			return
+		}
+		if pick != nominee.Author {
+			nominee = Successor{Author: pick}
+		}
+		nominee.Record = current
+		nominee.Number = tracker.value
+		nominee.Assign = assign
+		nominee.Limit = uint8(min(max(limit, 0), 255))
+		if nominee.Rejoin == "" {
+			if err := next.send(nominee, false); err != nil {
+				delete(clients, next)
+			}
+			return
+		}
+		for client := range clients {
+			if err := client.send(nominee, false); err != nil {
+				delete(clients, client)
+			}
+		}
+	}
+	ticker := time.NewTicker(handoverInterval)
+	defer ticker.Stop()
 	if err := srv.replica.Member(Member{
 		Record: current,
 		Number: tracker.value,
@@ -185,41 +314,60 @@ func (srv server) run() {
 		srv.reports.ReportError(xray.New(err))
 		return
 	}
+	// admit assigns the next author to a joiner, unless the room is full.
+	admit := func(client Networking) {
+		limit = client.Limit
+		if limit > 0 && len(clients) >= limit {
+			srv.reject(client, "the room is full")
+			return
+		}
+		assign++
+		if assign > 255 {
+			// Authors 256+ are reserved for device-derived host authors
+			// (and 0 for legacy), so a joiner must never be assigned one;
+			// past 255 the counter would also eventually wrap to 0.
+			srv.reports.ReportError(xray.New(errors.New("session full: joiner authors are limited to 1..255")))
+			assign = 255
+			srv.reject(client, "the session has run out of authors to assign")
+			return
+		}
+		orc, ok := authors[assign]
+		if !ok {
+			orc = Member{
+				Record: current,
+				Number: tracker.value,
+				Author: assign,
+				Server: srv.name,
+				Assign: true,
+				Host:   srv.self,
+			}
+			authors[assign] = orc
+		}
+		if err := client.send(orc, false); err == nil {
+			clients[client] = assign
+			go srv.handle(assign, client, current, tracker.value)
+			nominate()
+		} else {
+			srv.reports.ReportError(xray.New(err))
+		}
+	}
 	for {
 		select {
 		case client, ok := <-srv.clients:
 			if !ok {
 				return
 			}
-			assign++
-			if assign > 255 {
-				// Authors 256+ are reserved for device-derived host authors
-				// (and 0 for legacy), so a joiner must never be assigned one;
-				// past 255 the counter would also eventually wrap to 0.
-				srv.reports.ReportError(xray.New(errors.New("session full: joiner authors are limited to 1..255")))
-				assign = 255
-				client.Instructions.Close()
-				client.MediaUploads.Close()
+			if client.Access != "" {
+				go srv.checkAccess(client)
 				continue
 			}
-			orc, ok := authors[assign]
-			if !ok {
-				orc = Member{
-					Record: current,
-					Number: tracker.value,
-					Author: assign,
-					Server: srv.name,
-					Assign: true,
-					Host:   srv.self,
-				}
-				authors[assign] = orc
-			}
-			if err := client.send(orc, false); err == nil {
-				clients[client] = assign
-				go srv.handle(assign, client, current, tracker.value)
-			} else {
-				srv.reports.ReportError(xray.New(err))
-			}
+			admit(client)
+		case client := <-srv.admitted:
+			admit(client)
+		case client := <-srv.left:
+			delete(clients, client)
+		case <-ticker.C:
+			nominate()
 		case scene, ok := <-srv.changes:
 			if !ok {
 				return
@@ -227,7 +375,7 @@ func (srv server) run() {
 			current = scene
 			tracker.value = 0
 			store.Close()
-			store, err := srv.storage.Open(current)
+			store, err = srv.storage.Open(current)
 			if err != nil {
 				srv.reports.ReportError(xray.New(err))
 				return
@@ -239,6 +387,13 @@ func (srv server) run() {
 			}
 		case req := <-srv.request:
 			switch v := req.(type) {
+			case Successor:
+				if v.Author != nominee.Author || v.Rejoin == "" {
+					continue
+				}
+				nominee.Rejoin = v.Rejoin
+				nominate()
+				continue
 			case Member:
 				mus3.Member(v)
 			case Upload:
@@ -263,6 +418,54 @@ func (srv server) run() {
 	}
 }
 
+// checkAccess admits a joiner once it has presented the room password. A joiner
+// that presents the wrong one, or none in time, is told so and disconnected
+// without ever being assigned an author.
+func (srv server) checkAccess(network Networking) {
+	type result struct {
+		packet []byte
+		err    error
+	}
+	first := make(chan result, 1)
+	go func() {
+		packet, err := network.Instructions.Recv()
+		first <- result{packet, err}
+	}()
+	var err error
+	reason := "wrong room password"
+	select {
+	case res := <-first:
+		err = res.err
+		if err == nil {
+			var req encodable
+			req, err = decode(bytes.NewReader(res.packet))
+			if err == nil {
+				orc, ok := req.(Member)
+				if ok && subtle.ConstantTimeCompare([]byte(orc.Access), []byte(network.Access)) == 1 {
+					select {
+					case srv.admitted <- network:
+					case <-srv.done:
+					}
+					return
+				}
+				err = errors.New("joiner presented the wrong room password")
+			}
+		}
+	case <-time.After(admitTimeout):
+		err = errors.New("joiner did not present the room password in time")
+		reason = "no room password presented in time"
+	}
+	srv.reports.ReportError(xray.New(err))
+	srv.reject(network, reason)
+}
+
+// reject tells a joiner why it is being turned away, and disconnects it.
+func (srv server) reject(network Networking, reason string) {
+	network.send(Member{Reject: reason}, false)
+	network.Instructions.Close()
+	network.MediaUploads.Close()
+}
+
 func (srv server) handle(author Author, network Networking, current WorkID, catchup uint64) {
 	go func() {
 		file, err := srv.storage.Open(current)
@@ -293,10 +496,20 @@ func (srv server) handle(author Author, network Networking, current WorkID, catc
 				srv.reports.ReportError(xray.New(errors.New("invalid author for request")))
 				continue
 			}
-			srv.request <- req
+			select {
+			case srv.request <- req:
+			case <-srv.done:
+				return
+			}
 		}
 	}()
 	defer network.Instructions.Close()
+	defer func() {
+		select {
+		case srv.left <- network:
+		case <-srv.done:
+		}
+	}()
 	for {
 		packet, err := network.Instructions.Recv()
 		if err != nil {
@@ -312,6 +525,10 @@ func (srv server) handle(author Author, network Networking, current WorkID, catc
 			srv.reports.ReportError(xray.New(errors.New("invalid author for request")))
 			continue
 		}
-		srv.request <- req
+		select {
+		case srv.request <- req:
+		case <-srv.done:
+			return
+		}
 	}
 }
@@ -60,6 +60,22 @@ func newStorage(mus3 fs.File, limit int, client UsersSpace3D) (UsersSpace3D, err
 	return store, nil
 }
 
+// Replay decodes every entry of a .mus3 log, starting with its [MagicHeader],
+// into space, returning the number of entries decoded.
+func Replay(mus3 io.Reader, space UsersSpace3D) (int, error) {
+	var header [len(MagicHeader)]byte
+	if _, err := io.ReadFull(mus3, header[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, nil
+		}
+		return 0, xray.New(err)
+	}
+	if string(header[:]) != MagicHeader {
+		return 0, xray.New(errors.New("invalid musical.Users3DScene file"))
+	}
+	return storage{reader: mus3, client: space}.decode(0)
+}
+
 type storage struct {
 	reader io.Reader
 	writer io.Writer
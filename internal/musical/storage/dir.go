@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"runtime.link/api/xray"
+
+	"the.quetzal.community/aviary/internal/musical"
+)
+
+// Dir keeps the log of each work in the file dir/<name>.mus3 (see [Name]),
+// which is created along with dir when the work is first opened. Any record
+// left partially written by a crash is truncated when the log is first opened
+// by the process (see [musical.Recover]), and records are appended through a
+// single [musical.Journal] per log, for as long as it is open. Opening a log
+// again while it is open returns a read-only file, that sees each record as
+// soon as it is written.
+type Dir string
+
+// journalSyncDelay bounds how long a record written to a [Dir] can sit in the
+// page cache before it is synced to disk.
+const journalSyncDelay = time.Second
+
+// dirLogs are the logs of every [Dir] in the process, by path.
+var dirLogs = struct {
+	sync.Mutex
+	journals  map[string]*musical.Journal // of the logs open for writing.
+	recovered map[string]bool
+}{
+	journals:  make(map[string]*musical.Journal),
+	recovered: make(map[string]bool),
+}
+
+// Open the log of the work.
+func (dir Dir) Open(work musical.WorkID) (fs.File, error) {
+	if err := os.MkdirAll(string(dir), 0777); err != nil {
+		return nil, xray.New(err)
+	}
+	path, err := filepath.Abs(filepath.Join(string(dir), Name(work)+".mus3"))
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	dirLogs.Lock()
+	defer dirLogs.Unlock()
+	if dirLogs.journals[path] != nil {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, xray.New(err)
+		}
+		return readOnly{file}, nil
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	var size int64
+	if dirLogs.recovered[path] {
+		stat, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, xray.New(err)
+		}
+		size = stat.Size()
+	} else {
+		if size, err = musical.Recover(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+		dirLogs.recovered[path] = true
+	}
+	journal := musical.NewJournal(file, size, journalSyncDelay)
+	dirLogs.journals[path] = journal
+	return &dirFile{File: file, path: path, journal: journal}, nil
+}
+
+// dirFile reads a log file, and appends to it through its journal.
+type dirFile struct {
+	*os.File
+	path    string
+	journal *musical.Journal
+}
+
+func (file *dirFile) Write(record []byte) (int, error) { return file.journal.Write(record) }
+
+func (file *dirFile) Close() error {
+	dirLogs.Lock()
+	if dirLogs.journals[file.path] == file.journal {
+		delete(dirLogs.journals, file.path)
+	}
+	dirLogs.Unlock()
+	return file.journal.Close()
+}
+
+// readOnly hides the io.Writer of a file.
+type readOnly struct {
+	fs.File
+}
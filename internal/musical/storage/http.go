@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"runtime.link/api/xray"
+
+	"the.quetzal.community/aviary/internal/musical"
+)
+
+// HTTP reads the log of each work published at <url>/<name>.mus3 (see [Name]),
+// for example by a [Dir] served as static files. Works can't be written to, and
+// a work that isn't published fails to open with [fs.ErrNotExist].
+type HTTP string
+
+// client is shared by every [HTTP] and [S3] store. Like httpseek, there is no
+// Client.Timeout, which would bound the whole body read of a large log.
+var client = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   4,
+	},
+}
+
+// Open the log of the work, for reading.
+func (url HTTP) Open(work musical.WorkID) (fs.File, error) {
+	name := Name(work) + ".mus3"
+	resp, err := client.Get(strings.TrimSuffix(string(url), "/") + "/" + name)
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusForbidden, http.StatusGone:
+		resp.Body.Close()
+		return nil, xray.New(fmt.Errorf("work %s is not published at %s: %w", Name(work), url, fs.ErrNotExist))
+	default:
+		resp.Body.Close()
+		return nil, xray.New(fmt.Errorf("work %s could not be read from %s: %s", Name(work), url, resp.Status))
+	}
+	published := info{name: name, size: max(resp.ContentLength, 0)}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		published.time = modified
+	}
+	return &httpFile{body: resp.Body, info: published}, nil
+}
+
+// httpFile is a read-only log, streamed from the web server.
+type httpFile struct {
+	body io.ReadCloser
+	info info
+}
+
+func (file *httpFile) Read(p []byte) (int, error) { return file.body.Read(p) }
+func (file *httpFile) Stat() (fs.FileInfo, error) { return file.info, nil }
+func (file *httpFile) Close() error               { return file.body.Close() }
@@ -0,0 +1,456 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"runtime.link/api/xray"
+
+	"the.quetzal.community/aviary/internal/musical"
+)
+
+// S3 keeps the log of each work in an S3-compatible object store (such as
+// Amazon S3 or MinIO). Objects can't be appended to, so the log is kept as a
+// sequence of segments, Bucket/Prefix<name>/<offset>.mus3 (see [Name]), each
+// holding the whole records written from that offset of the log (in 16 hex
+// digits) onwards.
+//
+// Records written to an opened log are buffered (in a buffer shared by every
+// open of the log in the process, which read them from it in the meantime),
+// and uploaded as a new segment once s3FlushDelay has passed since the first
+// of them was written, once s3SegmentSize of them are buffered, or when the
+// log is closed. A segment is
+// only created when there is no segment at its offset already, so that two
+// hosts writing to the same work can't overwrite each other's records.
+type S3 struct {
+	Endpoint string // such as https://s3.us-east-1.amazonaws.com, or http://localhost:9000 for MinIO.
+	Region   string // such as us-east-1.
+	Bucket   string
+	Prefix   string // prepended to the name of each work, such as "works/".
+
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+const (
+	s3FlushDelay  = 10 * time.Second
+	s3SegmentSize = 4 << 20 // 4 MiB
+)
+
+// Open the log of the work, which starts out empty.
+func (s3 S3) Open(work musical.WorkID) (fs.File, error) {
+	prefix := s3.Prefix + Name(work) + "/"
+	key := s3.Endpoint + "/" + s3.Bucket + "/" + prefix
+	s3Logs.Lock()
+	log, ok := s3Logs.open[key]
+	if !ok {
+		log = &s3Log{store: s3, key: key, prefix: prefix, name: Name(work) + ".mus3"}
+		s3Logs.open[key] = log
+	}
+	log.files++
+	s3Logs.Unlock()
+	file := &s3File{log: log}
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	objects, err := s3.list(prefix)
+	if err != nil {
+		file.release()
+		return nil, err
+	}
+	var (
+		readers []io.Reader
+		size    int64
+		modTime time.Time
+	)
+	for _, object := range objects {
+		offset, ok := segmentOffset(prefix, object.Key)
+		if !ok {
+			continue
+		}
+		if offset != size {
+			file.release()
+			return nil, xray.New(fmt.Errorf("segment %s of work %s does not follow on from the %d bytes before it", object.Key, Name(work), size))
+		}
+		segment := &s3Segment{store: s3, key: object.Key}
+		file.segments = append(file.segments, segment)
+		readers = append(readers, segment)
+		size += object.Size
+		if object.LastModified.After(modTime) {
+			modTime = object.LastModified
+		}
+	}
+	if !log.listed {
+		log.size, log.time, log.listed = size, modTime, true
+	}
+	if size == log.size {
+		// and then whatever is yet to be uploaded, from this or any
+		// other open of the log.
+		readers = append(readers, bytes.NewReader(bytes.Clone(log.pending)))
+	}
+	file.reader = io.MultiReader(readers...)
+	return file, nil
+}
+
+// segmentOffset returns the offset of the segment stored at key, if it is one.
+func segmentOffset(prefix, key string) (int64, bool) {
+	offset, ok := strings.CutPrefix(key, prefix)
+	if !ok {
+		return 0, false
+	}
+	offset, ok = strings.CutSuffix(offset, ".mus3")
+	if !ok || len(offset) != 16 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(offset, 16, 64)
+	return n, err == nil
+}
+
+// s3Logs are the logs open in the process, by the key prefix of their
+// segments (under the endpoint and bucket), each shared by every file that
+// opens it, so that the records buffered by one are read by the others.
+var s3Logs = struct {
+	sync.Mutex
+	open map[string]*s3Log
+}{open: make(map[string]*s3Log)}
+
+// s3Log is a log in an [S3] store, shared by every file that opens it.
+type s3Log struct {
+	store  S3
+	key    string // in s3Logs.
+	prefix string
+	name   string
+	files  int // open, guarded by s3Logs.
+
+	mutex   sync.Mutex
+	listed  bool  // whether size and time have been listed.
+	size    int64 // uploaded so far.
+	time    time.Time
+	pending []byte // records yet to be uploaded.
+	timer   *time.Timer
+	err     error // from a delayed upload, reported by the next Write or Close.
+}
+
+// s3File reads the segments of a log, and appends to the log by uploading new
+// ones.
+type s3File struct {
+	log      *s3Log
+	reader   io.Reader
+	segments []*s3Segment
+}
+
+func (file *s3File) Read(p []byte) (int, error) { return file.reader.Read(p) }
+
+func (file *s3File) Write(record []byte) (int, error) {
+	log := file.log
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	if err := log.err; err != nil {
+		log.err = nil
+		return 0, err
+	}
+	if len(log.pending) > 0 && len(log.pending)+len(record) > s3SegmentSize {
+		if err := log.flush(); err != nil {
+			return 0, err
+		}
+	}
+	log.pending = append(log.pending, record...)
+	if log.timer == nil {
+		log.timer = time.AfterFunc(s3FlushDelay, func() {
+			log.mutex.Lock()
+			defer log.mutex.Unlock()
+			if err := log.flush(); err != nil {
+				log.err = err
+			}
+		})
+	}
+	return len(record), nil
+}
+
+// flush uploads the pending records as the next segment, the mutex must be held.
+// Should the upload fail, they are kept to be uploaded by the next flush.
+func (log *s3Log) flush() error {
+	if log.timer != nil {
+		log.timer.Stop()
+		log.timer = nil
+	}
+	if len(log.pending) == 0 {
+		return nil
+	}
+	key := fmt.Sprintf("%s%016x.mus3", log.prefix, log.size)
+	header := http.Header{"If-None-Match": {"*"}}
+	resp, err := log.store.do("PUT", key, nil, header, log.pending)
+	if err != nil {
+		var status s3Error
+		if errors.As(err, &status) && status.Status == http.StatusPreconditionFailed {
+			return xray.New(fmt.Errorf("%s was written to from elsewhere at offset %d: %w", log.name, log.size, err))
+		}
+		return err
+	}
+	resp.Body.Close()
+	log.size += int64(len(log.pending))
+	log.time = time.Now()
+	log.pending = nil
+	return nil
+}
+
+func (file *s3File) Stat() (fs.FileInfo, error) {
+	log := file.log
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	return info{name: log.name, size: log.size + int64(len(log.pending)), time: log.time}, nil
+}
+
+// release the file's hold on its log, which is forgotten once no file has it
+// open, s3Logs must not be held.
+func (file *s3File) release() {
+	s3Logs.Lock()
+	defer s3Logs.Unlock()
+	if file.log.files--; file.log.files == 0 {
+		delete(s3Logs.open, file.log.key)
+	}
+}
+
+// Close uploads any pending records.
+func (file *s3File) Close() error {
+	log := file.log
+	log.mutex.Lock()
+	err := log.err
+	log.err = nil
+	if ferr := log.flush(); ferr != nil {
+		err = errors.Join(err, ferr)
+	}
+	log.mutex.Unlock()
+	for _, segment := range file.segments {
+		segment.close()
+	}
+	file.release()
+	return err
+}
+
+// s3Segment downloads a segment of a log once it is first read.
+type s3Segment struct {
+	store S3
+	key   string
+	body  io.ReadCloser
+	done  bool
+}
+
+func (segment *s3Segment) Read(p []byte) (int, error) {
+	if segment.done {
+		return 0, io.EOF
+	}
+	if segment.body == nil {
+		resp, err := segment.store.do("GET", segment.key, nil, nil, nil)
+		if err != nil {
+			return 0, err
+		}
+		segment.body = resp.Body
+	}
+	n, err := segment.body.Read(p)
+	if err == io.EOF {
+		segment.close()
+	}
+	return n, err
+}
+
+func (segment *s3Segment) close() {
+	if segment.body != nil {
+		segment.body.Close()
+		segment.body = nil
+	}
+	segment.done = true
+}
+
+// s3Object is an object listed by ListObjectsV2.
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// list returns every object in the bucket whose key starts with prefix, in
+// order.
+func (s3 S3) list(prefix string) ([]s3Object, error) {
+	var objects []s3Object
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s3.do("GET", "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents              []s3Object `xml:"Contents"`
+			IsTruncated           bool       `xml:"IsTruncated"`
+			NextContinuationToken string     `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, xray.New(err)
+		}
+		objects = append(objects, page.Contents...)
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+	slices.SortFunc(objects, func(a, b s3Object) int { return strings.Compare(a.Key, b.Key) })
+	return objects, nil
+}
+
+// s3Error is an error response from the object store.
+type s3Error struct {
+	Status  int    `xml:"-"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (err s3Error) Error() string {
+	if err.Code == "" {
+		return fmt.Sprintf("s3: %d %s", err.Status, http.StatusText(err.Status))
+	}
+	return fmt.Sprintf("s3: %s: %s", err.Code, err.Message)
+}
+
+func (err s3Error) Is(target error) bool {
+	return target == fs.ErrNotExist && err.Status == http.StatusNotFound
+}
+
+// do sends a signed request for the object at key (or, if key is empty, for
+// the bucket) to the object store, returning its response if it succeeded.
+func (s3 S3) do(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	endpoint, err := url.Parse(s3.Endpoint)
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/" + s3.Bucket
+	if key != "" {
+		endpoint.Path += "/" + key
+	}
+	endpoint.RawPath = awsEscape(endpoint.Path, true)
+	endpoint.RawQuery = query.Encode()
+	req, err := http.NewRequest(method, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	hash := sha256.Sum256(body)
+	s3.sign(req, hex.EncodeToString(hash[:]), time.Now())
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		failure := s3Error{Status: resp.StatusCode}
+		if data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16)); err == nil {
+			xml.Unmarshal(data, &failure)
+		}
+		return nil, xray.New(failure)
+	}
+	return resp, nil
+}
+
+// sign the request with AWS Signature Version 4, given the hex-encoded SHA-256
+// of its body.
+func (s3 S3) sign(req *http.Request, payload string, now time.Time) {
+	date := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	signed := []string{"host"}
+	for name := range req.Header {
+		if name := strings.ToLower(name); name != "authorization" {
+			signed = append(signed, name)
+		}
+	}
+	slices.Sort(signed)
+	scope := date[:8] + "/" + s3.Region + "/s3/aws4_request"
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3.AccessKeyID, scope, strings.Join(signed, ";"), signature(s3.SecretAccessKey, scope, date, canonicalRequest(req, signed, payload))))
+}
+
+// canonicalRequest returns the canonical form of the request that is signed,
+// covering the given (lower case, sorted) headers.
+func canonicalRequest(req *http.Request, signed []string, payload string) string {
+	var query []string
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			query = append(query, awsEscape(name, false)+"="+awsEscape(value, false))
+		}
+	}
+	slices.Sort(query)
+	var headers strings.Builder
+	for _, name := range signed {
+		value := req.Host
+		if name != "host" {
+			values := slices.Clone(req.Header.Values(name))
+			for i := range values {
+				values[i] = strings.Join(strings.Fields(values[i]), " ")
+			}
+			value = strings.Join(values, ",")
+		} else if value == "" {
+			value = req.URL.Host
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+	return strings.Join([]string{
+		req.Method,
+		awsEscape(req.URL.Path, true),
+		strings.Join(query, "&"),
+		headers.String(),
+		strings.Join(signed, ";"),
+		payload,
+	}, "\n")
+}
+
+// signature of the canonical request, made at date within the scope.
+func signature(secret, scope, date, canonical string) string {
+	hash := sha256.Sum256([]byte(canonical))
+	key := []byte("AWS4" + secret)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, "AWS4-HMAC-SHA256\n"+date+"\n"+scope+"\n"+hex.EncodeToString(hash[:])))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsEscape percent-encodes every byte of s other than the unreserved
+// characters (and, if path is true, slashes), as signing requires.
+func awsEscape(s string, path bool) string {
+	var escaped strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', path && b == '/':
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
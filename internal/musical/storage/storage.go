@@ -0,0 +1,114 @@
+// Package storage provides backends for [musical.Storage], each of which keeps
+// the .mus3 log of every work under the name of the work (see [Name]):
+//
+//   - [Memory] keeps the logs in memory, for tests.
+//   - [Dir] keeps each log in a file of a local directory.
+//   - [S3] keeps each log in an S3-compatible object store.
+//   - [HTTP] reads the logs published on a web server (for example, a [Dir]
+//     served as static files), and cannot be written to.
+//
+// Like any [musical.Storage], opening a work returns a file that reads its log
+// from the start and, unless the backend is read-only, appends whatever is
+// written to it, one whole record per write.
+package storage
+
+import (
+	"encoding/base64"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+
+	"the.quetzal.community/aviary/internal/musical"
+)
+
+var (
+	_ musical.Storage = (*Memory)(nil)
+	_ musical.Storage = Dir("")
+	_ musical.Storage = HTTP("")
+	_ musical.Storage = S3{}
+)
+
+// Name of the work, as its log is named by each backend (with a .mus3 suffix).
+// It is the same as the name the client gives to the work's saves.
+func Name(work musical.WorkID) string {
+	return base64.RawURLEncoding.EncodeToString(work[:])
+}
+
+// info describes an opened log.
+type info struct {
+	name string
+	size int64
+	time time.Time
+}
+
+func (i info) Name() string       { return i.name }
+func (i info) Size() int64        { return i.size }
+func (i info) Mode() fs.FileMode  { return 0666 }
+func (i info) ModTime() time.Time { return i.time }
+func (i info) IsDir() bool        { return false }
+func (i info) Sys() any           { return nil }
+
+// Memory keeps the log of each work in memory, for as long as it is in use.
+// The zero value is an empty store, ready to use.
+type Memory struct {
+	mutex sync.Mutex
+	logs  map[musical.WorkID]*memoryLog
+}
+
+// memoryLog is the log of a work in a [Memory] store, shared by every file
+// that opens it.
+type memoryLog struct {
+	mutex sync.Mutex
+	data  []byte
+	time  time.Time
+}
+
+// Open the log of the work, which starts out empty.
+func (mem *Memory) Open(work musical.WorkID) (fs.File, error) {
+	mem.mutex.Lock()
+	defer mem.mutex.Unlock()
+	if mem.logs == nil {
+		mem.logs = make(map[musical.WorkID]*memoryLog)
+	}
+	log, ok := mem.logs[work]
+	if !ok {
+		log = &memoryLog{time: time.Now()}
+		mem.logs[work] = log
+	}
+	return &memoryFile{name: Name(work) + ".mus3", log: log}, nil
+}
+
+// memoryFile reads a [memoryLog] from its own offset, and appends to it.
+type memoryFile struct {
+	name   string
+	log    *memoryLog
+	offset int
+}
+
+func (file *memoryFile) Read(p []byte) (int, error) {
+	file.log.mutex.Lock()
+	defer file.log.mutex.Unlock()
+	if file.offset >= len(file.log.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, file.log.data[file.offset:])
+	file.offset += n
+	return n, nil
+}
+
+func (file *memoryFile) Write(record []byte) (int, error) {
+	file.log.mutex.Lock()
+	defer file.log.mutex.Unlock()
+	file.log.data = append(file.log.data, record...)
+	file.log.time = time.Now()
+	return len(record), nil
+}
+
+func (file *memoryFile) Stat() (fs.FileInfo, error) {
+	file.log.mutex.Lock()
+	defer file.log.mutex.Unlock()
+	return info{name: file.name, size: int64(len(file.log.data)), time: file.log.time}, nil
+}
+
+func (file *memoryFile) Close() error { return nil }
@@ -0,0 +1,333 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"the.quetzal.community/aviary/internal/musical"
+)
+
+// backend opens a store holding the given logs, for the conformance tests.
+type backend struct {
+	open     func(t *testing.T, logs map[musical.WorkID][]byte) musical.Storage
+	readOnly bool
+}
+
+// writable returns a backend that writes the logs it is to hold through the
+// store itself.
+func writable(store func(t *testing.T) musical.Storage) backend {
+	return backend{open: func(t *testing.T, logs map[musical.WorkID][]byte) musical.Storage {
+		t.Helper()
+		storage := store(t)
+		for work, log := range logs {
+			file, err := storage.Open(work)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			if _, err := file.(io.Writer).Write(log); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			if err := file.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+		}
+		return storage
+	}}
+}
+
+// testLog returns a valid .mus3 log of the work, with a record for each author.
+func testLog(t *testing.T, work musical.WorkID, authors ...musical.Author) []byte {
+	t.Helper()
+	log := []byte(musical.MagicHeader)
+	for _, author := range authors {
+		var buf bytes.Buffer
+		if err := musical.Fork(&buf, musical.WorkID{}, work, author, false); err != nil {
+			t.Fatal(err)
+		}
+		log = append(log, buf.Bytes()[len(musical.MagicHeader):]...)
+	}
+	return log
+}
+
+// readAll reads the whole log of the work, checking that it is as long as the
+// file says it is.
+func readAll(t *testing.T, storage musical.Storage, work musical.WorkID) []byte {
+	t.Helper()
+	file, err := storage.Open(work)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if stat.Size() != int64(len(data)) {
+		t.Errorf("Stat().Size() = %d, but read %d bytes", stat.Size(), len(data))
+	}
+	return data
+}
+
+// testConformance checks that the backend behaves as a [musical.Storage] is
+// expected to.
+func testConformance(t *testing.T, backend backend) {
+	first, second := musical.WorkID{1}, musical.WorkID{2}
+	logs := map[musical.WorkID][]byte{
+		first:  testLog(t, first, 1, 2),
+		second: testLog(t, second, 3),
+	}
+
+	t.Run("reopen", func(t *testing.T) {
+		storage := backend.open(t, logs)
+		for work, log := range logs {
+			if data := readAll(t, storage, work); !bytes.Equal(data, log) {
+				t.Errorf("log of %s = %q, want %q", Name(work), data, log)
+			}
+		}
+	})
+	t.Run("replay", func(t *testing.T) {
+		storage := backend.open(t, logs)
+		file, err := storage.Open(first)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		defer file.Close()
+		n, err := musical.Replay(file, musical.Stubbed{})
+		if err != nil || n != 2 {
+			t.Errorf("Replay = %d, %v, want 2 records", n, err)
+		}
+	})
+	if backend.readOnly {
+		t.Run("missing", func(t *testing.T) {
+			storage := backend.open(t, nil)
+			if _, err := storage.Open(first); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Open of a missing work = %v, want fs.ErrNotExist", err)
+			}
+		})
+		t.Run("read-only", func(t *testing.T) {
+			storage := backend.open(t, logs)
+			file, err := storage.Open(first)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer file.Close()
+			if _, ok := file.(io.Writer); ok {
+				t.Error("read-only log can be written to")
+			}
+		})
+		return
+	}
+	t.Run("empty", func(t *testing.T) {
+		storage := backend.open(t, nil)
+		if data := readAll(t, storage, first); len(data) != 0 {
+			t.Errorf("new log = %q, want it empty", data)
+		}
+	})
+	t.Run("append", func(t *testing.T) {
+		storage := backend.open(t, logs)
+		file, err := storage.Open(first)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if _, err := io.ReadAll(file); err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		records := testLog(t, first, 4, 5)[len(musical.MagicHeader):]
+		half := len(records) / 2
+		for _, record := range [][]byte{records[:half], records[half:]} {
+			if n, err := file.(io.Writer).Write(record); err != nil || n != len(record) {
+				t.Fatalf("Write = %d, %v, want %d", n, err, len(record))
+			}
+		}
+		stat, err := file.Stat()
+		if err != nil {
+			t.Fatalf("Stat: %v", err)
+		}
+		want := append(append([]byte(nil), logs[first]...), records...)
+		if stat.Size() != int64(len(want)) {
+			t.Errorf("after Write, Stat().Size() = %d, want %d", stat.Size(), len(want))
+		}
+		if err := file.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		if data := readAll(t, storage, first); !bytes.Equal(data, want) {
+			t.Errorf("after Write, log = %q, want %q", data, want)
+		}
+		if data := readAll(t, storage, second); !bytes.Equal(data, logs[second]) {
+			t.Errorf("after Write to another work, log = %q, want %q", data, logs[second])
+		}
+	})
+	t.Run("concurrent", func(t *testing.T) {
+		// while a host appends to a log, a joiner is caught up from the
+		// same log opened again.
+		storage := backend.open(t, logs)
+		host, err := storage.Open(second)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if _, err := io.ReadAll(host); err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if data := readAll(t, storage, second); !bytes.Equal(data, logs[second]) {
+			t.Errorf("log opened again = %q, want %q", data, logs[second])
+		}
+		record := testLog(t, second, 6)[len(musical.MagicHeader):]
+		if _, err := host.(io.Writer).Write(record); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if err := host.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		if data := readAll(t, storage, second); !bytes.Equal(data, append(append([]byte(nil), logs[second]...), record...)) {
+			t.Errorf("after Write, log = %q", data)
+		}
+	})
+	t.Run("tail", func(t *testing.T) {
+		// a joiner is caught up on what the host has written, even before
+		// the host closes the log.
+		storage := backend.open(t, logs)
+		host, err := storage.Open(second)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		defer host.Close()
+		if _, err := io.ReadAll(host); err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		record := testLog(t, second, 7)[len(musical.MagicHeader):]
+		if _, err := host.(io.Writer).Write(record); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		want := append(append([]byte(nil), logs[second]...), record...)
+		if data := readAll(t, storage, second); !bytes.Equal(data, want) {
+			t.Errorf("log opened again after Write = %q, want %q", data, want)
+		}
+	})
+	t.Run("interleaved", func(t *testing.T) {
+		// joiners are caught up from the log opened again while the host is
+		// still appending to it, which must neither lose nor tear records.
+		storage := backend.open(t, logs)
+		host, err := storage.Open(first)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if _, err := io.ReadAll(host); err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		want := append([]byte(nil), logs[first]...)
+		done := make(chan error)
+		var records [][]byte
+		for author := range musical.Author(20) {
+			records = append(records, testLog(t, first, author)[len(musical.MagicHeader):])
+		}
+		go func() {
+			for _, record := range records {
+				if _, err := host.(io.Writer).Write(record); err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+		for _, record := range records {
+			want = append(want, record...)
+		}
+		var joined [][]byte
+		for range records {
+			joined = append(joined, readAll(t, storage, first))
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if err := host.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		for _, data := range joined {
+			if !bytes.HasPrefix(want, data) {
+				t.Errorf("joiner read %q, which is not a prefix of the log", data)
+			}
+		}
+		if data := readAll(t, storage, first); !bytes.Equal(data, want) {
+			t.Errorf("after writing while joiners read, log = %q, want %q", data, want)
+		}
+	})
+}
+
+func TestMemory(t *testing.T) {
+	testConformance(t, writable(func(t *testing.T) musical.Storage { return new(Memory) }))
+}
+
+func TestDir(t *testing.T) {
+	testConformance(t, writable(func(t *testing.T) musical.Storage { return Dir(t.TempDir()) }))
+}
+
+// TestHTTP publishes logs in a directory laid out like a [Dir].
+func TestHTTP(t *testing.T) {
+	testConformance(t, backend{readOnly: true, open: func(t *testing.T, logs map[musical.WorkID][]byte) musical.Storage {
+		dir := t.TempDir()
+		for work, log := range logs {
+			if err := os.WriteFile(filepath.Join(dir, Name(work)+".mus3"), log, 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+		srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+		t.Cleanup(srv.Close)
+		return HTTP(srv.URL + "/")
+	}})
+}
+
+func TestDirRecoversTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	work := musical.WorkID{1}
+	log := testLog(t, work, 1, 2)
+	torn := append(append([]byte(nil), log...), testLog(t, work, 3)[len(musical.MagicHeader):][:5]...)
+	if err := os.WriteFile(filepath.Join(dir, Name(work)+".mus3"), torn, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if data := readAll(t, Dir(dir), work); !bytes.Equal(data, log) {
+		t.Errorf("log with a torn record = %q, want %q", data, log)
+	}
+}
+
+// TestDirJoinerDoesNotRecover opens a log while the host is part way through
+// writing a record to it, which a joiner must leave alone.
+func TestDirJoinerDoesNotRecover(t *testing.T) {
+	dir := t.TempDir()
+	work := musical.WorkID{1}
+	log := testLog(t, work, 1, 2)
+	path := filepath.Join(dir, Name(work)+".mus3")
+	if err := os.WriteFile(path, log, 0666); err != nil {
+		t.Fatal(err)
+	}
+	host, err := Dir(dir).Open(work)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer host.Close()
+	partial := append(append([]byte(nil), log...), testLog(t, work, 3)[len(musical.MagicHeader):][:5]...)
+	if err := os.WriteFile(path, partial, 0666); err != nil {
+		t.Fatal(err)
+	}
+	joiner, err := Dir(dir).Open(work)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer joiner.Close()
+	if _, ok := joiner.(io.Writer); ok {
+		t.Error("log opened again while open for writing can be written to")
+	}
+	if data, err := os.ReadFile(path); err != nil || !bytes.Equal(data, partial) {
+		t.Errorf("after opening it again, log = %q (%v), want %q", data, err, partial)
+	}
+}
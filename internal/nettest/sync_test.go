@@ -19,16 +19,21 @@ package nettest
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"iter"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"the.quetzal.community/aviary/internal/musical"
+	"the.quetzal.community/aviary/internal/musical/storage"
 	"the.quetzal.community/aviary/internal/networking"
 )
 
@@ -77,6 +82,153 @@ func TestMusicalSyncHeadless(t *testing.T) {
 	runSyncChecks(t, hostSpace, clientSpace, host, client, 5*time.Second)
 }
 
+// TestMusicalPasswordHeadless checks that a host requiring a room password
+// tells a joiner that presents the wrong one why, and disconnects it before
+// assigning it an author, and admits one that presents the right one.
+func TestMusicalPasswordHeadless(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	var errs, rejected errSink
+
+	const password = "correct horse"
+
+	intruderInstr, hostIntruderInstr := newPipe()
+	intruderMedia, hostIntruderMedia := newPipe()
+	guestInstr, hostGuestInstr := newPipe()
+	guestMedia, hostGuestMedia := newPipe()
+	defer guestInstr.Close()
+	defer guestMedia.Close()
+
+	clients := iter.Seq[musical.Networking](func(yield func(musical.Networking) bool) {
+		for _, network := range []musical.Networking{
+			{Instructions: hostIntruderInstr, MediaUploads: hostIntruderMedia, ErrorReports: &errs, Access: password},
+			{Instructions: hostGuestInstr, MediaUploads: hostGuestMedia, ErrorReports: &errs, Access: password},
+		} {
+			if !yield(network) {
+				return
+			}
+		}
+		<-stop
+	})
+
+	host := newRecorder()
+	intruder := newRecorder()
+	guest := newRecorder()
+
+	hostSpace, _, err := musical.Host("headless-test", clients, musical.WorkID{}, memStorage{}, host, &errs, hostAuthor)
+	if err != nil {
+		t.Fatalf("host: %v", err)
+	}
+	if _, err := musical.Join(musical.Networking{
+		Instructions: intruderInstr, MediaUploads: intruderMedia, ErrorReports: &rejected, Access: "wrong",
+	}, musical.WorkID{}, intruder); err != nil {
+		t.Fatalf("intruder join: %v", err)
+	}
+	select {
+	case <-intruderInstr.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("host did not disconnect a joiner with the wrong room password")
+	}
+	if err := rejected.wait(t, musical.ErrRejected, 5*time.Second); !strings.Contains(err.Error(), "wrong room password") {
+		t.Errorf("joiner with the wrong room password was told %q", err)
+	}
+	select {
+	case m := <-intruder.members:
+		t.Fatalf("joiner with the wrong room password was assigned an author: %+v", m)
+	default:
+	}
+
+	guestSpace, err := musical.Join(musical.Networking{
+		Instructions: guestInstr, MediaUploads: guestMedia, ErrorReports: &errs, Access: password,
+	}, musical.WorkID{}, guest)
+	if err != nil {
+		t.Fatalf("guest join: %v", err)
+	}
+	runSyncChecks(t, hostSpace, guestSpace, host, guest, 5*time.Second)
+}
+
+// TestMusicalLimitHeadless checks that a host with a joiner limit counts only
+// the joiners it has admitted (not one still to present the room password),
+// turns away a joiner past the limit, telling it why, and admits another once
+// an admitted joiner has left.
+func TestMusicalLimitHeadless(t *testing.T) {
+	const timeout = 5 * time.Second
+	const password = "correct horse"
+	stop := make(chan struct{})
+	defer close(stop)
+	var errs errSink
+
+	joiners := make(chan musical.Networking)
+	clients := iter.Seq[musical.Networking](func(yield func(musical.Networking) bool) {
+		for {
+			select {
+			case network := <-joiners:
+				if !yield(network) {
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	})
+	host := newRecorder()
+	if _, _, err := musical.Host("headless-test", clients, musical.WorkID{}, memStorage{}, host, &errs, hostAuthor); err != nil {
+		t.Fatalf("host: %v", err)
+	}
+	// join connects a joiner that presents access to a host that admits one
+	// joiner at once.
+	join := func(access string, reports musical.ErrorReporter) (*recorder, *memConn) {
+		instr, hostInstr := newPipe()
+		media, hostMedia := newPipe()
+		t.Cleanup(func() { instr.Close(); media.Close() })
+		joiners <- musical.Networking{Instructions: hostInstr, MediaUploads: hostMedia, ErrorReports: &errs, Access: password, Limit: 1}
+		replica := newRecorder()
+		if _, err := musical.Join(musical.Networking{Instructions: instr, MediaUploads: media, ErrorReports: reports, Access: access}, musical.WorkID{}, replica); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		return replica, instr
+	}
+
+	// yet to present the room password, so not counted.
+	join("", &errs)
+	admitted, first := join(password, &errs)
+	recv(t, admitted.members, timeout, "first joiner author assignment")
+
+	var full errSink
+	overflow, overflowInstr := join(password, &full)
+	select {
+	case <-overflowInstr.closed:
+	case <-time.After(timeout):
+		t.Fatal("host did not disconnect a joiner past the limit")
+	}
+	if err := full.wait(t, musical.ErrRejected, timeout); !strings.Contains(err.Error(), "full") {
+		t.Errorf("joiner past the limit was told %q", err)
+	}
+	select {
+	case m := <-overflow.members:
+		t.Fatalf("joiner past the limit was assigned an author: %+v", m)
+	default:
+	}
+
+	first.Close()
+	deadline := time.Now().Add(timeout)
+	for {
+		var again errSink
+		next, _ := join(password, &again)
+		select {
+		case m := <-next.members:
+			if !m.Assign || m.Author == 0 {
+				t.Fatalf("joiner after the first left was not assigned an author: %+v", m)
+			}
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("host did not admit a joiner after the first left")
+		}
+	}
+}
+
 func TestMusicalSyncIntegration(t *testing.T) {
 	if os.Getenv("AVIARY_INTEGRATION") == "" {
 		t.Skip("set AVIARY_INTEGRATION=1 to run the live signalling integration test")
@@ -151,6 +303,156 @@ func TestMusicalSyncIntegration(t *testing.T) {
 	runSyncChecks(t, hostSpace, clientSpace, host, client, 35*time.Second)
 }
 
+// TestMusicalHandoverHeadless has a host nominate the first joiner as its
+// successor, then drops the host: the successor resumes hosting from the log it
+// recorded while joined, and the other joiner rejoins it, is assigned the next
+// author, and is not replayed the instructions it already has.
+func TestMusicalHandoverHeadless(t *testing.T) {
+	const timeout = 5 * time.Second
+	stop := make(chan struct{})
+	defer close(stop)
+	var errs errSink
+
+	hostA, joinA := newPipe()
+	hostAMedia, joinAMedia := newPipe()
+	hostB, joinB := newPipe()
+	hostBMedia, joinBMedia := newPipe()
+	defer joinA.Close()
+	defer joinAMedia.Close()
+	defer joinB.Close()
+	defer joinBMedia.Close()
+	tappedB := &tapConn{Connection: joinB}
+
+	clients := iter.Seq[musical.Networking](func(yield func(musical.Networking) bool) {
+		if !yield(musical.Networking{Instructions: hostA, MediaUploads: hostAMedia, ErrorReports: &errs, Limit: 2}) {
+			return
+		}
+		if !yield(musical.Networking{Instructions: hostB, MediaUploads: hostBMedia, ErrorReports: &errs, Limit: 2}) {
+			return
+		}
+		<-stop
+	})
+	host := newRecorder()
+	a := newRecorder()
+	b := newRecorder()
+	hostSpace, _, err := musical.Host("handover-test", clients, musical.WorkID{}, memStorage{}, host, &errs, hostAuthor)
+	if err != nil {
+		t.Fatalf("host: %v", err)
+	}
+
+	const rendezvous = "successor-code"
+	standby := make(chan musical.Networking, 1)
+	spaceA, err := musical.JoinHandover(musical.Networking{Instructions: joinA, MediaUploads: joinAMedia, ErrorReports: &errs}, a, musical.Handover{
+		Storage: new(storage.Memory),
+		Standby: func() (string, iter.Seq[musical.Networking], error) {
+			return rendezvous, func(yield func(musical.Networking) bool) {
+				for {
+					select {
+					case n := <-standby:
+						if !yield(n) {
+							return
+						}
+					case <-stop:
+						return
+					}
+				}
+			}, nil
+		},
+		Server: "handover-test",
+	})
+	if err != nil {
+		t.Fatalf("join a: %v", err)
+	}
+	spaceB, err := musical.JoinHandover(musical.Networking{Instructions: tappedB, MediaUploads: joinBMedia, ErrorReports: &errs}, b, musical.Handover{
+		Storage: new(storage.Memory),
+		Rejoin: func(code string) (musical.Networking, error) {
+			if code != rendezvous {
+				return musical.Networking{}, fmt.Errorf("rejoin via %q, want %q", code, rendezvous)
+			}
+			hostSide, joinSide := newPipe()
+			hostMedia, joinMedia := newPipe()
+			standby <- musical.Networking{Instructions: hostSide, MediaUploads: hostMedia, ErrorReports: &errs}
+			return musical.Networking{Instructions: joinSide, MediaUploads: joinMedia, ErrorReports: &errs}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("join b: %v", err)
+	}
+
+	authorA := recv(t, a.members, timeout, "a author assignment").Author
+	authorB := recv(t, b.members, timeout, "b author assignment").Author
+	if authorA != 1 || authorB != 2 {
+		t.Fatalf("joiners assigned authors %d and %d, want 1 and 2", authorA, authorB)
+	}
+	design := musical.Design{Author: hostAuthor, Number: 1}
+	hostSpace.Import(musical.Import{Design: design, Import: "res://library/everything/avatar/bald_eagle.glb"})
+	hostSpace.Change(musical.Change{Author: hostAuthor, Entity: musical.Entity{Author: hostAuthor, Number: 1}, Design: design, Commit: true})
+	recv(t, a.changes, timeout, "a receives Change")
+	recv(t, b.imports, timeout, "b receives Import")
+	recv(t, b.changes, timeout, "b receives Change")
+	// Assign, Import and Change, plus at least one accepted nomination.
+	deadline := time.Now().Add(timeout)
+	for tappedB.packets.Load() < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the successor nomination to reach b")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Lose the host.
+	hostA.Close()
+	hostAMedia.Close()
+	hostB.Close()
+	hostBMedia.Close()
+
+	if m := recv(t, a.members, timeout, "a resumes hosting"); !m.Assign || m.Author != authorA || m.Host != authorA {
+		t.Fatalf("successor resumed as %+v, want to host as author %d", m, authorA)
+	}
+	m := recv(t, b.members, timeout, "b rejoins the successor")
+	if m.Author != 3 || m.Host != authorA {
+		t.Fatalf("b rejoined as %+v, want author 3 following host %d", m, authorA)
+	}
+	select {
+	case v := <-b.imports:
+		t.Fatalf("b was replayed an Import it already had: %+v", v)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := spaceA.Change(musical.Change{Author: authorA, Entity: musical.Entity{Author: authorA, Number: 1}, Design: design, Commit: true}); err != nil {
+		t.Fatalf("successor change: %v", err)
+	}
+	recvMatch(t, b.changes, timeout, "b receives the successor's Change",
+		func(c musical.Change) bool { return c.Author == authorA })
+	if err := spaceB.Change(musical.Change{Author: m.Author, Entity: musical.Entity{Author: m.Author, Number: 1}, Design: design, Commit: true}); err != nil {
+		t.Fatalf("rejoined change: %v", err)
+	}
+	recvMatch(t, a.changes, timeout, "successor receives b's Change",
+		func(c musical.Change) bool { return c.Author == m.Author })
+
+	// The successor admits as many joiners as the host did: b and one more.
+	join := func(reports musical.ErrorReporter) (*recorder, *memConn) {
+		instr, hostInstr := newPipe()
+		media, hostMedia := newPipe()
+		t.Cleanup(func() { instr.Close(); media.Close() })
+		standby <- musical.Networking{Instructions: hostInstr, MediaUploads: hostMedia, ErrorReports: &errs}
+		replica := newRecorder()
+		if _, err := musical.Join(musical.Networking{Instructions: instr, MediaUploads: media, ErrorReports: reports}, musical.WorkID{}, replica); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		return replica, instr
+	}
+	c, _ := join(&errs)
+	recv(t, c.members, timeout, "c author assignment")
+	var full errSink
+	_, overflow := join(&full)
+	select {
+	case <-overflow.closed:
+	case <-time.After(timeout):
+		t.Fatal("successor did not disconnect a joiner past the host's limit")
+	}
+	full.wait(t, musical.ErrRejected, timeout)
+}
+
 // runSyncChecks drives the shared scenario: the client is assigned an author,
 // a design the host imports and places reaches the client, and a change the
 // client makes (as its assigned author) reaches the host.
@@ -323,6 +625,26 @@ func (e *errSink) ReportError(err error) {
 	e.mu.Unlock()
 }
 
+// wait for an error that matches target to be reported, and return it.
+func (e *errSink) wait(t *testing.T, target error, timeout time.Duration) error {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		e.mu.Lock()
+		for _, err := range e.errs {
+			if errors.Is(err, target) {
+				e.mu.Unlock()
+				return err
+			}
+		}
+		e.mu.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out after %s waiting for %v", timeout, target)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 // --- harness: in-memory storage --------------------------------------------
 
 // memStorage hands out a fresh empty in-memory .mus3 per Open. A joiner that
@@ -398,7 +720,13 @@ func (m *memConn) Send(b []byte) error {
 	}
 }
 
+// Recv delivers what was sent before the pipe was closed, ahead of io.EOF.
 func (m *memConn) Recv() ([]byte, error) {
+	select {
+	case b := <-m.in:
+		return b, nil
+	default:
+	}
 	select {
 	case b := <-m.in:
 		return b, nil
@@ -420,6 +748,20 @@ func newPipe() (*memConn, *memConn) {
 	return a, b
 }
 
+// tapConn counts the packets received over a connection.
+type tapConn struct {
+	musical.Connection
+	packets atomic.Int64
+}
+
+func (t *tapConn) Recv() ([]byte, error) {
+	b, err := t.Connection.Recv()
+	if err == nil {
+		t.packets.Add(1)
+	}
+	return b, err
+}
+
 // --- harness: networking.Connectivity adapters (integration transport) -----
 
 // forPeer adapts a host-side networking.Client into a musical.Connection. It
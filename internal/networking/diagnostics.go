@@ -0,0 +1,245 @@
+package networking
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// EventType identifies what an [Event] reports.
+type EventType int
+
+const (
+	EventServers   EventType = iota + 1 // ICE servers received from the signalling service.
+	EventCandidate                      // a local ICE candidate was gathered.
+	EventSelected                       // ICE selected the candidate pair a peer communicates over.
+	EventState                          // a peer connection changed state.
+	EventOpen                           // a data channel opened.
+	EventClose                          // a data channel closed.
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventServers:
+		return "servers"
+	case EventCandidate:
+		return "candidate"
+	case EventSelected:
+		return "selected"
+	case EventState:
+		return "state"
+	case EventOpen:
+		return "open"
+	case EventClose:
+		return "close"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// Event is a structured connection diagnostic, delivered to [Connectivity.Events]
+// as the connection progresses, regardless of AVIARY_NET_DEBUG.
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	// Session identifies the peer the event is about. It is the signalling
+	// session of the offer, so both ends of a connection report the same one.
+	// Empty for [EventServers].
+	Session string
+
+	Servers   int                        // number of ICE servers, for [EventServers].
+	Candidate webrtc.ICECandidateType    // local candidate type, for [EventCandidate].
+	Local     webrtc.ICECandidateType    // local candidate type, for [EventSelected].
+	Remote    webrtc.ICECandidateType    // remote candidate type, for [EventSelected].
+	Address   string                     // candidate address(es), for [EventCandidate] and [EventSelected].
+	State     webrtc.PeerConnectionState // new state, for [EventState].
+	Label     string                     // data channel label, for [EventOpen] and [EventClose].
+}
+
+func (e Event) String() string {
+	var detail string
+	switch e.Type {
+	case EventServers:
+		detail = fmt.Sprintf("%d ICE server(s)", e.Servers)
+	case EventCandidate:
+		detail = fmt.Sprintf("%s candidate %s", e.Candidate, e.Address)
+	case EventSelected:
+		detail = fmt.Sprintf("%s <-> %s pair %s", e.Local, e.Remote, e.Address)
+	case EventState:
+		detail = e.State.String()
+	case EventOpen, EventClose:
+		detail = fmt.Sprintf("data channel %q", e.Label)
+	}
+	return fmt.Sprintf("%s %s [%s] %s", e.Time.Format(time.TimeOnly), e.Type, e.Session, detail)
+}
+
+// PeerStats is a snapshot of one peer connection, see [Connectivity.Stats].
+type PeerStats struct {
+	Session string
+
+	State webrtc.PeerConnectionState
+	Since time.Time // when the peer entered its current State.
+
+	Candidates map[webrtc.ICECandidateType]int // local candidates gathered, by type.
+	Selected   string                          // selected candidate pair, if any.
+
+	Open bool // whether the data channel is open.
+
+	BytesSent uint64
+	BytesRecv uint64
+}
+
+// Stats is a snapshot of a [Connectivity].
+type Stats struct {
+	Servers int         // ICE servers received from the signalling service.
+	Peers   []PeerStats // ordered by session.
+}
+
+// Diagnosis explains, in a sentence, why the connection is (or is not)
+// working, for display next to the online status indicator.
+func (s Stats) Diagnosis() string {
+	if s.Servers == 0 {
+		return "The signalling service returned no ICE servers, so peers behind NAT cannot be reached."
+	}
+	if len(s.Peers) == 0 {
+		return "Waiting for a peer to connect."
+	}
+	for _, peer := range s.Peers {
+		if peer.Open {
+			return fmt.Sprintf("Connected over %s.", peer.Selected)
+		}
+	}
+	peer := s.Peers[0]
+	for _, next := range s.Peers[1:] {
+		if next.Since.After(peer.Since) {
+			peer = next
+		}
+	}
+	switch {
+	case len(peer.Candidates) == 0:
+		return "No network candidates were gathered; check the network connection."
+	case peer.State == webrtc.PeerConnectionStateFailed && peer.Candidates[webrtc.ICECandidateTypeRelay] == 0:
+		return "No path to the peer was found, and no relay (TURN) candidates could be gathered; a firewall may be blocking the relay."
+	case peer.State == webrtc.PeerConnectionStateFailed:
+		return "No path to the peer was found, even through the relay (TURN)."
+	case peer.Selected != "":
+		return fmt.Sprintf("Connecting over %s.", peer.Selected)
+	}
+	return fmt.Sprintf("Connecting (%s).", peer.State)
+}
+
+// diagnostics tracks the [Stats] of a [Connectivity], and emits its [Event]s.
+type diagnostics struct {
+	mutex   sync.Mutex
+	servers int
+	peers   map[string]*PeerStats
+}
+
+// Stats returns a snapshot of the connection's ICE servers and peers.
+func (c *Connectivity) Stats() Stats {
+	c.diagnostics.mutex.Lock()
+	defer c.diagnostics.mutex.Unlock()
+	stats := Stats{Servers: c.diagnostics.servers}
+	for _, peer := range c.diagnostics.peers {
+		snapshot := *peer
+		snapshot.Candidates = make(map[webrtc.ICECandidateType]int, len(peer.Candidates))
+		for typ, n := range peer.Candidates {
+			snapshot.Candidates[typ] = n
+		}
+		stats.Peers = append(stats.Peers, snapshot)
+	}
+	sort.Slice(stats.Peers, func(i, j int) bool {
+		return stats.Peers[i].Session < stats.Peers[j].Session
+	})
+	return stats
+}
+
+// observe records the event into the stats, then delivers it to Events.
+func (c *Connectivity) observe(event Event) {
+	event.Time = time.Now()
+	d := &c.diagnostics
+	d.mutex.Lock()
+	if event.Type == EventServers {
+		d.servers = event.Servers
+	} else if peer, ok := d.peers[event.Session]; ok {
+		switch event.Type {
+		case EventCandidate:
+			peer.Candidates[event.Candidate]++
+		case EventSelected:
+			peer.Selected = fmt.Sprintf("%s <-> %s (%s)", event.Local, event.Remote, event.Address)
+		case EventState:
+			peer.State = event.State
+			peer.Since = event.Time
+		case EventOpen:
+			peer.Open = true
+		case EventClose:
+			peer.Open = false
+		}
+	}
+	d.mutex.Unlock()
+	if c.Events != nil {
+		c.Events(event)
+	}
+}
+
+// observeTraffic counts the bytes sent to and received from a peer.
+func (c *Connectivity) observeTraffic(session string, sent, recv int) {
+	d := &c.diagnostics
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if peer, ok := d.peers[session]; ok {
+		peer.BytesSent += uint64(sent)
+		peer.BytesRecv += uint64(recv)
+	}
+}
+
+// forget drops a peer that has been torn down from the stats.
+func (c *Connectivity) forget(session string) {
+	d := &c.diagnostics
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.peers, session)
+}
+
+// observePeer starts tracking the stats of a peer, and reports the candidate
+// pair that ICE selects for it. Events for a peer are only recorded into the
+// stats between observePeer and forget.
+func (c *Connectivity) observePeer(session string, peer *webrtc.PeerConnection) {
+	d := &c.diagnostics
+	d.mutex.Lock()
+	if d.peers == nil {
+		d.peers = make(map[string]*PeerStats)
+	}
+	d.peers[session] = &PeerStats{
+		Session:    session,
+		State:      webrtc.PeerConnectionStateNew,
+		Since:      time.Now(),
+		Candidates: make(map[webrtc.ICECandidateType]int),
+	}
+	d.mutex.Unlock()
+	peer.SCTP().Transport().ICETransport().OnSelectedCandidatePairChange(func(pair *webrtc.ICECandidatePair) {
+		if pair == nil || pair.Local == nil || pair.Remote == nil {
+			return
+		}
+		c.observe(Event{
+			Type:    EventSelected,
+			Session: session,
+			Local:   pair.Local.Typ,
+			Remote:  pair.Remote.Typ,
+			Address: fmt.Sprintf("%s:%d <-> %s:%d", pair.Local.Address, pair.Local.Port, pair.Remote.Address, pair.Remote.Port),
+		})
+	})
+}
+
+// observeCandidate reports a gathered local candidate.
+func (c *Connectivity) observeCandidate(session string, candidate *webrtc.ICECandidate) {
+	c.observe(Event{
+		Type:      EventCandidate,
+		Session:   session,
+		Candidate: candidate.Typ,
+		Address:   fmt.Sprintf("%s:%d", candidate.Address, candidate.Port),
+	})
+}
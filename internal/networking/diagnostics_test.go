@@ -0,0 +1,65 @@
+package networking
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// TestDiagnostics drives a Connectivity's diagnostics through a failed and then
+// a successful connection, without any network, checking that the Stats
+// snapshot and its Diagnosis follow along and that every Event is delivered.
+func TestDiagnostics(t *testing.T) {
+	var events []Event
+	c := &Connectivity{Events: func(e Event) { events = append(events, e) }}
+	if got := c.Stats().Diagnosis(); !strings.Contains(got, "no ICE servers") {
+		t.Errorf("diagnosis before setup = %q", got)
+	}
+	c.observe(Event{Type: EventServers, Servers: 2})
+
+	peer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	c.observePeer("a", peer)
+	c.observe(Event{Type: EventCandidate, Session: "a", Candidate: webrtc.ICECandidateTypeHost})
+	c.observe(Event{Type: EventCandidate, Session: "a", Candidate: webrtc.ICECandidateTypeSrflx})
+	c.observe(Event{Type: EventState, Session: "a", State: webrtc.PeerConnectionStateFailed})
+	if got := c.Stats().Diagnosis(); !strings.Contains(got, "no relay (TURN)") {
+		t.Errorf("diagnosis without relay candidates = %q", got)
+	}
+
+	c.observePeer("b", peer)
+	c.observe(Event{Type: EventCandidate, Session: "b", Candidate: webrtc.ICECandidateTypeRelay})
+	c.observe(Event{Type: EventSelected, Session: "b", Local: webrtc.ICECandidateTypeRelay, Remote: webrtc.ICECandidateTypeHost, Address: "x <-> y"})
+	c.observe(Event{Type: EventOpen, Session: "b", Label: "data"})
+	c.observeTraffic("b", 10, 20)
+	c.observeTraffic("b", 1, 2)
+	if got := c.Stats().Diagnosis(); !strings.HasPrefix(got, "Connected over relay <-> host") {
+		t.Errorf("diagnosis once open = %q", got)
+	}
+
+	stats := c.Stats()
+	if stats.Servers != 2 || len(stats.Peers) != 2 {
+		t.Fatalf("stats = %+v, want 2 servers and 2 peers", stats)
+	}
+	b := stats.Peers[1]
+	if b.Session != "b" || !b.Open || b.BytesSent != 11 || b.BytesRecv != 22 || b.Candidates[webrtc.ICECandidateTypeRelay] != 1 {
+		t.Errorf("peer b stats = %+v", b)
+	}
+	b.Candidates[webrtc.ICECandidateTypeHost] = 99
+	if c.Stats().Peers[1].Candidates[webrtc.ICECandidateTypeHost] != 0 {
+		t.Errorf("Stats snapshot shares its candidate counts with the live stats")
+	}
+
+	c.forget("a")
+	c.observe(Event{Type: EventState, Session: "a", State: webrtc.PeerConnectionStateClosed})
+	if n := len(c.Stats().Peers); n != 1 {
+		t.Errorf("forgotten peer reappeared in stats: %d peers", n)
+	}
+	if len(events) != 8 {
+		t.Errorf("delivered %d events, want 8", len(events))
+	}
+}
@@ -45,11 +45,22 @@ type Connectivity struct {
 	local_recv chan<- []byte
 	server     Server
 
-	closed chan struct{} // closed when a session joined via Join disconnects
+	hosting sync.Mutex
+	revoke  func() // stops serving the current join code, nil if there is none
+
+	closed  chan struct{} // closed when a session joined via Join disconnects
+	session string        // signalling session joined via Join
+
+	diagnostics diagnostics
 
 	Raise func(error)
 	Print func(string, ...any)
 
+	// Events, if set, receives structured diagnostics as connections are
+	// established and torn down (see [Event] and [Connectivity.Stats]).
+	// Called from networking goroutines, so it must not block.
+	Events func(Event)
+
 	Authentication string
 }
 
@@ -118,6 +129,7 @@ func (c *Connectivity) setup() (err error) {
 		return xray.New(err)
 	}
 	c.ice = ice.Servers
+	c.observe(Event{Type: EventServers, Servers: len(c.ice)})
 	if debug {
 		c.Print("Received %d ICE server(s) from the signalling service.\n", len(c.ice))
 		for _, s := range c.ice {
@@ -142,7 +154,9 @@ func (c *Connectivity) Send(data []byte) {
 	if debug {
 		c.Print("Sending data on data channel\n")
 	}
-	c.data_channel.Send(data)
+	if err := c.data_channel.Send(data); err == nil {
+		c.observeTraffic(c.session, len(data), 0)
+	}
 	if debug {
 		c.Print("Data sent.\n")
 	}
@@ -173,6 +187,8 @@ func (c *Connectivity) Join(code Code, updates chan<- []byte) error {
 		return xray.New(err)
 	}
 	c.closed = make(chan struct{})
+	c.session = message.SessionID
+	c.observePeer(c.session, c.peer)
 	var closeOnce sync.Once
 	var sessionDown atomic.Bool
 	// closeSession tears the joined session down exactly once, signalling the
@@ -202,6 +218,7 @@ func (c *Connectivity) Join(code Code, updates chan<- []byte) error {
 		if candidate == nil {
 			return // no more candidates
 		}
+		c.observeCandidate(c.session, candidate)
 		if debug {
 			c.Print("ICE candidate: %s\n", candidate.ToJSON().Candidate)
 		}
@@ -225,21 +242,25 @@ func (c *Connectivity) Join(code Code, updates chan<- []byte) error {
 		if debug {
 			c.Print("Data channel opened: %s\n", ch.Label())
 		}
+		c.observe(Event{Type: EventOpen, Session: c.session, Label: ch.Label()})
 		data_channels <- ch
 		ch.OnMessage(func(msg webrtc.DataChannelMessage) {
 			if debug {
 				c.Print("Received message on data channel: %s\n", string(msg.Data))
 			}
+			c.observeTraffic(c.session, 0, len(msg.Data))
 			select {
 			case updates <- msg.Data:
 			case <-c.closed:
 			}
 		})
 		ch.OnClose(func() {
+			c.observe(Event{Type: EventClose, Session: c.session, Label: ch.Label()})
 			closeSession()
 		})
 	})
 	c.peer.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		c.observe(Event{Type: EventState, Session: c.session, State: state})
 		if debug {
 			c.Print("Connection state changed: %s\n", state.String())
 		}
@@ -337,7 +358,7 @@ const (
 	offerGrace   = 10 * time.Second
 )
 
-func (c *Connectivity) addPeers(sock *websocket.Conn) {
+func (c *Connectivity) addPeers(sock *websocket.Conn, revoked <-chan struct{}) {
 	type peerState struct {
 		conn     *webrtc.PeerConnection
 		timer    *time.Timer
@@ -410,6 +431,11 @@ func (c *Connectivity) addPeers(sock *websocket.Conn) {
 		for {
 			msg, err := wsRecv[iceMessage](sock)
 			if err != nil {
+				select {
+				case <-revoked:
+					return // closed by Revoke or Rotate
+				default:
+				}
 				if !errors.Is(err, websocket.ErrCloseSent) {
 					c.Raise(xray.New(err))
 				}
@@ -460,7 +486,6 @@ func (c *Connectivity) addPeers(sock *websocket.Conn) {
 			return // signalling socket closed; existing peers keep running
 		case <-make_offer:
 		}
-
 		peer, err := webrtc.NewPeerConnection(webrtc.Configuration{
 			ICEServers: c.ice,
 		})
@@ -486,6 +511,7 @@ func (c *Connectivity) addPeers(sock *websocket.Conn) {
 				delete(pending, sessionID)
 				mutex.Unlock()
 				close(done)
+				c.forget(sessionID)
 				go peer.Close()
 			})
 		}
@@ -508,6 +534,7 @@ func (c *Connectivity) addPeers(sock *websocket.Conn) {
 			if debug {
 				c.Print("Received message on data channel")
 			}
+			c.observeTraffic(sessionID, 0, len(msg.Data))
 			select {
 			case client_recv <- msg.Data:
 			case <-done:
@@ -517,6 +544,7 @@ func (c *Connectivity) addPeers(sock *websocket.Conn) {
 			if debug {
 				c.Print("Data channel closed.\n")
 			}
+			c.observe(Event{Type: EventClose, Session: sessionID, Label: ch.Label()})
 			teardown()
 		})
 		offer, err := peer.CreateOffer(nil)
@@ -527,6 +555,7 @@ func (c *Connectivity) addPeers(sock *websocket.Conn) {
 			time.Sleep(time.Second)
 			continue
 		}
+		c.observePeer(sessionID, peer)
 		ps := &peerState{conn: peer}
 		mutex.Lock()
 		pending[sessionID] = ps
@@ -550,6 +579,7 @@ func (c *Connectivity) addPeers(sock *websocket.Conn) {
 			if candidate == nil {
 				return // no more candidates
 			}
+			c.observeCandidate(sessionID, candidate)
 			msg := iceMessage{
 				Type:      string(iceMessageTypeCandidate),
 				SessionID: sessionID,
@@ -560,6 +590,7 @@ func (c *Connectivity) addPeers(sock *websocket.Conn) {
 			}
 		})
 		ch.OnOpen(func() {
+			c.observe(Event{Type: EventOpen, Session: sessionID, Label: ch.Label()})
 			go func() {
 				for {
 					select {
@@ -571,6 +602,7 @@ func (c *Connectivity) addPeers(sock *websocket.Conn) {
 							c.Raise(xray.New(err))
 							return
 						}
+						c.observeTraffic(sessionID, len(msg), 0)
 					case <-done:
 						return
 					}
@@ -583,6 +615,7 @@ func (c *Connectivity) addPeers(sock *websocket.Conn) {
 			})
 		})
 		peer.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+			c.observe(Event{Type: EventState, Session: sessionID, State: state})
 			if debug {
 				c.Print("Peer connection state changed: %s\n", state.String())
 			}
@@ -630,11 +663,21 @@ func (c *Connectivity) addPeers(sock *websocket.Conn) {
 	}
 }
 
+// Host serves offers to the peers that join the returned code, handing each of
+// them to server once connected. See [Connectivity.Rotate] and
+// [Connectivity.Revoke] to control who else may join.
 func (c *Connectivity) Host(updates chan<- []byte, server Server) (Code, error) {
 	c.server = server
 	if err := c.setup(); err != nil {
 		return "", err
 	}
+	return c.Rotate()
+}
+
+// Rotate replaces the join code being hosted with a fresh one. Peers that have
+// already joined stay connected, but nobody else can join with the old code.
+// After [Connectivity.Revoke], Rotate starts hosting again.
+func (c *Connectivity) Rotate() (Code, error) {
 	signalling, _, err := websocket.DefaultDialer.Dial("wss://via.quetzal.community/code", http.Header{
 		"Authorization": []string{"Bearer " + c.Authentication},
 	})
@@ -643,11 +686,39 @@ func (c *Connectivity) Host(updates chan<- []byte, server Server) (Code, error)
 	}
 	msg, err := wsRecv[iceMessage](signalling)
 	if err != nil {
+		signalling.Close()
 		return "", xray.New(err)
 	}
 	if msg.Type != string(iceMessageTypeCode) {
+		signalling.Close()
 		return "", fmt.Errorf("unexpected message type: %s", msg.Type)
 	}
-	go c.addPeers(signalling)
+	revoked := make(chan struct{})
+	var once sync.Once
+	c.hosting.Lock()
+	previous := c.revoke
+	c.revoke = func() {
+		once.Do(func() {
+			close(revoked)
+			signalling.Close()
+		})
+	}
+	c.hosting.Unlock()
+	if previous != nil {
+		previous()
+	}
+	go c.addPeers(signalling, revoked)
 	return msg.Code, nil
 }
+
+// Revoke stops serving the current join code, so that nobody else can join
+// with it. Peers that have already joined stay connected.
+func (c *Connectivity) Revoke() {
+	c.hosting.Lock()
+	revoke := c.revoke
+	c.revoke = nil
+	c.hosting.Unlock()
+	if revoke != nil {
+		revoke()
+	}
+}
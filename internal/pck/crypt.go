@@ -0,0 +1,96 @@
+package pck
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"runtime.link/api/xray"
+)
+
+// KeySize is the size of the key that Godot encrypts pcks with, which is the
+// script encryption key it is exported with.
+const KeySize = 32
+
+// encryptedSize returns the number of bytes that size bytes take up once they
+// are encrypted: the md5 of the plaintext, its size and the iv, followed by
+// the plaintext padded to the AES block size.
+func encryptedSize(size int64) int64 {
+	return md5.Size + 8 + aes.BlockSize + pad(size, aes.BlockSize)
+}
+
+// pad rounds size up to a multiple of align.
+func pad(size, align int64) int64 {
+	return (size + align - 1) / align * align
+}
+
+func newCipher(key []byte) (cipher.Block, error) {
+	if len(key) != KeySize {
+		return nil, xray.New(fmt.Errorf("pck encryption key must be %d bytes, not %d", KeySize, len(key)))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, xray.New(err)
+	}
+	return block, nil
+}
+
+// decrypt reads data encrypted the way Godot's FileAccessEncrypted does (without
+// its magic, as within a pck), checking it against its md5.
+func decrypt(src io.Reader, key []byte) ([]byte, error) {
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		hash [md5.Size]byte
+		size uint64
+		iv   [aes.BlockSize]byte
+	)
+	if err := errors.Join(
+		binary.Read(src, binary.LittleEndian, &hash),
+		binary.Read(src, binary.LittleEndian, &size),
+		binary.Read(src, binary.LittleEndian, &iv),
+	); err != nil {
+		return nil, xray.New(err)
+	}
+	if size > 1<<40 {
+		return nil, xray.New(fmt.Errorf("invalid encrypted data: size %d", size))
+	}
+	data := make([]byte, pad(int64(size), aes.BlockSize))
+	if _, err := io.ReadFull(src, data); err != nil {
+		return nil, xray.New(err)
+	}
+	cipher.NewCFBDecrypter(block, iv[:]).XORKeyStream(data, data)
+	data = data[:size]
+	if md5.Sum(data) != hash {
+		return nil, xray.New(errors.New("invalid encrypted data: wrong key or corrupted"))
+	}
+	return data, nil
+}
+
+// encrypt data the way Godot's FileAccessEncrypted does (without its magic, as
+// within a pck), with a random iv.
+func encrypt(data []byte, key []byte) ([]byte, error) {
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	hash := md5.Sum(data)
+	out := make([]byte, encryptedSize(int64(len(data))))
+	copy(out, hash[:])
+	binary.LittleEndian.PutUint64(out[md5.Size:], uint64(len(data)))
+	iv := out[md5.Size+8 : md5.Size+8+aes.BlockSize]
+	if _, err := rand.Read(iv); err != nil {
+		return nil, xray.New(err)
+	}
+	body := out[md5.Size+8+aes.BlockSize:]
+	copy(body, data)
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(body, body)
+	return out, nil
+}
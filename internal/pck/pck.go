@@ -1,33 +1,128 @@
+// Package pck reads and writes Godot .pck files, in format versions 2 (Godot
+// 4.0 to 4.3) and 3 (Godot 4.4 onwards), including those with an encrypted
+// directory, encrypted files, or whose files are bundled alongside them.
 package pck
 
 import (
+	"bytes"
+	"crypto/md5"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"path/filepath"
+	"strings"
 
 	"runtime.link/api/xray"
 )
 
+// Magic identifies a pck ("GDPC").
+const Magic = 0x43504447
+
+// Format versions that can be read and written.
+const (
+	Version2 = 2 // Godot 4.0 to 4.3, with the directory right after the header.
+	Version3 = 3 // Godot 4.4 onwards, with the directory at DirOffset.
+)
+
+// PackFlag is a flag of a pck, in its header.
+type PackFlag uint32
+
+const (
+	PackDirEncrypted PackFlag = 1 << 0 // the directory is encrypted.
+	PackRelFileBase  PackFlag = 1 << 1 // FileBase is relative to the start of the pck (always, in version 3).
+	PackSparseBundle PackFlag = 1 << 2 // the files are stored alongside the pck, see [SparsePath].
+)
+
+// Flag is a flag of a file, in the directory of a pck.
 type Flag uint32
 
 const (
+	FlagEncrypted Flag = 1 << 0 // the file is encrypted.
+	FlagRemoval   Flag = 1 << 1 // the file is removed from the packs loaded before this one.
+
+	// FlagMissing marks a file whose space in the pck has been allocated by
+	// [Append], but is yet to be filled in by [Remap]. It is our own.
 	FlagMissing Flag = 1 << 31
 )
 
+// Header of a pck.
+type Header struct {
+	Version  uint32    // format version, [Version2] or [Version3].
+	Engine   [3]uint32 // major, minor and patch version of the Godot that made it.
+	Flags    PackFlag
+	FileBase int64 // offset of the files, which the offset of each is relative to.
+
+	// DirOffset is the offset of the directory, in version 3 (relative to the
+	// start of the pck). In version 2, the directory is right after the header.
+	DirOffset int64
+
+	Reserved [16]uint32
+}
+
+// size of the encoded header.
+func (h Header) size() int64 {
+	size := int64(4*6 + 8 + 4*16)
+	if h.Version >= Version3 {
+		size += 8
+	}
+	return size
+}
+
+func (h Header) encode() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(Magic))
+	binary.Write(&buf, binary.LittleEndian, h.Version)
+	binary.Write(&buf, binary.LittleEndian, h.Engine)
+	binary.Write(&buf, binary.LittleEndian, h.Flags)
+	binary.Write(&buf, binary.LittleEndian, h.FileBase)
+	if h.Version >= Version3 {
+		binary.Write(&buf, binary.LittleEndian, h.DirOffset)
+	}
+	binary.Write(&buf, binary.LittleEndian, h.Reserved)
+	return buf.Bytes()
+}
+
+// relative reports whether FileBase is relative to the start of the pck.
+func (h Header) relative() bool {
+	return h.Version >= Version3 || h.Flags&PackRelFileBase != 0
+}
+
 type File struct {
-	Head int64
+	Head int64 // offset of the file's flags in the directory, zero if the directory is encrypted.
 
-	Seek int64
-	Size int64
+	Seek int64 // offset of the file in the pck, unless it is Sparse.
+	Size int64 // of the file's content (which is larger, if encrypted).
 	Hash [16]byte
 	Flag Flag
+
+	Sparse bool // stored alongside the pck, see [SparsePath].
 }
 
+// Encrypted reports whether the file is encrypted (see [File.Decrypt]).
+func (f File) Encrypted() bool {
+	return f.Flag&FlagEncrypted != 0
+}
+
+// Stored returns the number of bytes the file takes up in the pck.
+func (f File) Stored() int64 {
+	if f.Encrypted() {
+		return encryptedSize(f.Size)
+	}
+	return f.Size
+}
+
+// Bytes returns the content of the file, which must not be encrypted or sparse.
 func (f File) Bytes(src io.ReadWriteSeeker) ([]byte, error) {
 	if f.Missing() {
 		return nil, fmt.Errorf("cannot read missing file at seek %d", f.Seek)
 	}
+	if f.Sparse {
+		return nil, fmt.Errorf("cannot read sparse file from the pck")
+	}
+	if f.Encrypted() {
+		return nil, fmt.Errorf("cannot read encrypted file at seek %d without a key", f.Seek)
+	}
 	if _, err := src.Seek(f.Seek, io.SeekStart); err != nil {
 		return nil, xray.New(err)
 	}
@@ -38,17 +133,52 @@ func (f File) Bytes(src io.ReadWriteSeeker) ([]byte, error) {
 	return buf, nil
 }
 
+// Decrypt returns the content of the encrypted file, which is read from src:
+// the pck, or, for a sparse file, the file alongside it (see [SparsePath]).
+func (f File) Decrypt(src io.ReadSeeker, key []byte) ([]byte, error) {
+	if f.Missing() {
+		return nil, fmt.Errorf("cannot read missing file at seek %d", f.Seek)
+	}
+	if !f.Encrypted() {
+		return nil, fmt.Errorf("file at seek %d is not encrypted", f.Seek)
+	}
+	seek := f.Seek
+	if f.Sparse {
+		seek = 0
+	}
+	if _, err := src.Seek(seek, io.SeekStart); err != nil {
+		return nil, xray.New(err)
+	}
+	data, err := decrypt(src, key)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != f.Size {
+		return nil, xray.New(fmt.Errorf("encrypted file at seek %d is %d bytes, not %d", f.Seek, len(data), f.Size))
+	}
+	return data, nil
+}
+
+// SparsePath returns where the file at path (as indexed) of the sparse pck at
+// pack is stored: relative to the directory of the pck.
+func SparsePath(pack, path string) string {
+	return filepath.Join(filepath.Dir(pack), filepath.FromSlash(strings.TrimPrefix(path, "res://")))
+}
+
 func (f File) Missing() bool {
 	return f.Flag&FlagMissing != 0
 }
 
 func (f File) SetMissing(missing bool, dst io.WriteSeeker) error {
+	if f.Head == 0 {
+		return xray.New(errors.New("cannot mark files of an encrypted directory"))
+	}
 	if _, err := dst.Seek(f.Head, io.SeekStart); err != nil {
 		return xray.New(err)
 	}
-	var val uint32
+	val := uint32(f.Flag &^ FlagMissing)
 	if missing {
-		val = uint32(FlagMissing)
+		val |= uint32(FlagMissing)
 	}
 	if err := binary.Write(dst, binary.LittleEndian, val); err != nil {
 		return xray.New(err)
@@ -56,63 +186,94 @@ func (f File) SetMissing(missing bool, dst io.WriteSeeker) error {
 	return nil
 }
 
-// Index reads the pck from the given ReadCloser and returns a map of its files
-// indexed by their path.
-func Index(pck io.ReadSeeker) (map[string]File, error) {
+// Entry is a file in the directory of a pck.
+type Entry struct {
+	Path string // as stored, which may start with res:// (see [Pack.Index]).
+	File
+}
+
+// Pack is the header and directory of a pck.
+type Pack struct {
+	Header
+	Start   int64   // offset of the pck in its file (non-zero, when embedded in an executable).
+	Entries []Entry // in the order of the directory.
+}
+
+// Read reads the header and directory of the pck that starts at the current
+// offset of src, decrypting the directory with the key, if it is encrypted.
+func Read(src io.ReadSeeker, key []byte) (Pack, error) {
+	start, err := src.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Pack{}, xray.New(err)
+	}
+	pack := Pack{Start: start}
 	var magic uint32
-	if err := binary.Read(pck, binary.LittleEndian, &magic); err != nil {
-		return nil, xray.New(err)
+	if err := binary.Read(src, binary.LittleEndian, &magic); err != nil {
+		return Pack{}, xray.New(err)
 	}
-	if magic != 0x43504447 { // 'PCKC'
-		return nil, xray.New(errors.New("invalid pck file: bad magic"))
+	if magic != Magic {
+		return Pack{}, xray.New(errors.New("invalid pck file: bad magic"))
 	}
-	var (
-		version       uint32
-		version_major uint32
-		version_minor uint32
-		version_patch uint32
-	)
+	h := &pack.Header
 	if err := errors.Join(
-		binary.Read(pck, binary.LittleEndian, &version),
-		binary.Read(pck, binary.LittleEndian, &version_major),
-		binary.Read(pck, binary.LittleEndian, &version_minor),
-		binary.Read(pck, binary.LittleEndian, &version_patch),
+		binary.Read(src, binary.LittleEndian, &h.Version),
+		binary.Read(src, binary.LittleEndian, &h.Engine),
 	); err != nil {
-		return nil, xray.New(err)
+		return Pack{}, xray.New(err)
 	}
-	if version != 3 {
-		return nil, xray.New(errors.New("invalid pck file: unsupported version"))
+	if h.Version != Version2 && h.Version != Version3 {
+		return Pack{}, xray.New(fmt.Errorf("invalid pck file: unsupported version %d", h.Version))
 	}
-	var (
-		pack_flags uint32
-		file_base  int64
-		dir_offset int64
-	)
 	if err := errors.Join(
-		binary.Read(pck, binary.LittleEndian, &pack_flags),
-		binary.Read(pck, binary.LittleEndian, &file_base),
-		binary.Read(pck, binary.LittleEndian, &dir_offset),
+		binary.Read(src, binary.LittleEndian, &h.Flags),
+		binary.Read(src, binary.LittleEndian, &h.FileBase),
 	); err != nil {
-		return nil, xray.New(err)
+		return Pack{}, xray.New(err)
 	}
-	if _, err := pck.Seek(dir_offset, io.SeekStart); err != nil {
-		return nil, xray.New(err)
+	if h.Version >= Version3 {
+		if err := binary.Read(src, binary.LittleEndian, &h.DirOffset); err != nil {
+			return Pack{}, xray.New(err)
+		}
 	}
-	var file_count uint32
-
-	if err := binary.Read(pck, binary.LittleEndian, &file_count); err != nil {
-		return nil, xray.New(err)
+	// packs made by [Create] before it wrote the reserved fields have their
+	// directory where those would be.
+	if h.Version < Version3 || h.DirOffset >= h.size() {
+		if err := binary.Read(src, binary.LittleEndian, &h.Reserved); err != nil {
+			return Pack{}, xray.New(err)
+		}
+	}
+	dir := start + h.size()
+	if h.Version >= Version3 {
+		dir = start + h.DirOffset
+	}
+	if _, err := src.Seek(dir, io.SeekStart); err != nil {
+		return Pack{}, xray.New(err)
 	}
-	files := make(map[string]File)
-	head := dir_offset + 4
-	for i := uint32(0); i < file_count; i++ {
+	var count uint32
+	if err := binary.Read(src, binary.LittleEndian, &count); err != nil {
+		return Pack{}, xray.New(err)
+	}
+	base := h.FileBase
+	if h.relative() {
+		base += start
+	}
+	var entries io.Reader = src
+	head := dir + 4
+	if h.Flags&PackDirEncrypted != 0 {
+		data, err := decrypt(src, key)
+		if err != nil {
+			return Pack{}, fmt.Errorf("cannot read encrypted directory: %w", err)
+		}
+		entries, head = bytes.NewReader(data), 0
+	}
+	for range count {
 		var name_len uint32
-		if err := binary.Read(pck, binary.LittleEndian, &name_len); err != nil {
-			return nil, xray.New(err)
+		if err := binary.Read(entries, binary.LittleEndian, &name_len); err != nil {
+			return Pack{}, xray.New(err)
 		}
 		name_buf := make([]byte, name_len)
-		if _, err := io.ReadFull(pck, name_buf); err != nil {
-			return nil, xray.New(err)
+		if _, err := io.ReadFull(entries, name_buf); err != nil {
+			return Pack{}, xray.New(err)
 		}
 		for j := 0; j < len(name_buf); j++ {
 			if name_buf[j] == 0 {
@@ -120,31 +281,67 @@ func Index(pck io.ReadSeeker) (map[string]File, error) {
 				break
 			}
 		}
-		var f File
+		f := File{Sparse: h.Flags&PackSparseBundle != 0}
 		if err := errors.Join(
-			binary.Read(pck, binary.LittleEndian, &f.Seek),
-			binary.Read(pck, binary.LittleEndian, &f.Size),
-			binary.Read(pck, binary.LittleEndian, &f.Hash),
-			binary.Read(pck, binary.LittleEndian, &f.Flag),
+			binary.Read(entries, binary.LittleEndian, &f.Seek),
+			binary.Read(entries, binary.LittleEndian, &f.Size),
+			binary.Read(entries, binary.LittleEndian, &f.Hash),
+			binary.Read(entries, binary.LittleEndian, &f.Flag),
 		); err != nil {
-			return nil, xray.New(err)
+			return Pack{}, xray.New(err)
+		}
+		if head != 0 {
+			head += 4 + int64(name_len) + 32
+			f.Head = head
+			head += 4
 		}
-		head += 4 + int64(name_len) + 32
-		f.Head = head
-		head += 4
-		f.Seek += file_base
-		files[string(name_buf)] = f
+		f.Seek += base
+		pack.Entries = append(pack.Entries, Entry{Path: string(name_buf), File: f})
 	}
-	return files, nil
+	return pack, nil
+}
+
+// Index returns the files of the pck by their path (without any res://
+// prefix), leaving out those that it removes.
+func (pack Pack) Index() map[string]File {
+	files := make(map[string]File, len(pack.Entries))
+	for _, entry := range pack.Entries {
+		path := strings.TrimPrefix(entry.Path, "res://")
+		if entry.Flag&FlagRemoval != 0 {
+			delete(files, path)
+			continue
+		}
+		files[path] = entry.File
+	}
+	return files
+}
+
+// Index reads the pck from the given ReadCloser and returns a map of its files
+// indexed by their path (see [Pack.Index]). The directory must not be
+// encrypted, see [Read] for those that are.
+func Index(pck io.ReadSeeker) (map[string]File, error) {
+	pack, err := Read(pck, nil)
+	if err != nil {
+		return nil, err
+	}
+	return pack.Index(), nil
 }
 
 // Append allocates missing files into dst from any new files from the
-// given index and rewrites the index.
+// given index and rewrites the index. Only version 3 packs, with their files
+// in the pck and an unencrypted directory, can be appended to.
 func Append(pck io.ReadWriteSeeker, files map[string]File) error {
-	index, err := Index(pck)
+	pack, err := Read(pck, nil)
 	if err != nil {
 		return xray.New(err)
 	}
+	switch {
+	case pack.Version != Version3:
+		return xray.New(fmt.Errorf("cannot append to a version %d pck", pack.Version))
+	case pack.Flags&PackSparseBundle != 0:
+		return xray.New(errors.New("cannot append to a sparse pck"))
+	}
+	index := pack.Index()
 	end, err := pck.Seek(0, io.SeekEnd)
 	if err != nil {
 		return xray.New(err)
@@ -156,7 +353,8 @@ func Append(pck io.ReadWriteSeeker, files map[string]File) error {
 		}
 		file.Seek = end
 		file.Flag |= FlagMissing
-		end += file.Size
+		file.Sparse = false
+		end += file.Stored()
 		index[path] = file
 		added = true
 	}
@@ -166,7 +364,7 @@ func Append(pck io.ReadWriteSeeker, files map[string]File) error {
 	if _, err := pck.Seek(end, io.SeekStart); err != nil {
 		return xray.New(err)
 	}
-	dir_offset := end
+	dir_offset := end - pack.Start
 	if err := binary.Write(pck, binary.LittleEndian, uint32(len(index))); err != nil {
 		return xray.New(err)
 	}
@@ -178,7 +376,7 @@ func Append(pck io.ReadWriteSeeker, files map[string]File) error {
 			return xray.New(err)
 		}
 		if err := errors.Join(
-			binary.Write(pck, binary.LittleEndian, file.Seek),
+			binary.Write(pck, binary.LittleEndian, file.Seek-pack.Start),
 			binary.Write(pck, binary.LittleEndian, file.Size),
 			binary.Write(pck, binary.LittleEndian, file.Hash),
 			binary.Write(pck, binary.LittleEndian, file.Flag),
@@ -186,13 +384,13 @@ func Append(pck io.ReadWriteSeeker, files map[string]File) error {
 			return xray.New(err)
 		}
 	}
-	if _, err := pck.Seek(24, io.SeekStart); err != nil {
+	if _, err := pck.Seek(pack.Start+24, io.SeekStart); err != nil {
 		return xray.New(err)
 	}
 	if err := binary.Write(pck, binary.LittleEndian, uint64(0)); err != nil {
 		return xray.New(err)
 	}
-	if _, err := pck.Seek(32, io.SeekStart); err != nil {
+	if _, err := pck.Seek(pack.Start+32, io.SeekStart); err != nil {
 		return xray.New(err)
 	}
 	if err := binary.Write(pck, binary.LittleEndian, dir_offset); err != nil {
@@ -203,21 +401,30 @@ func Append(pck io.ReadWriteSeeker, files map[string]File) error {
 
 // Create makes a new empty pck file in the given WriteSeeker.
 func Create(pck io.WriteSeeker) error {
-	return errors.Join(
-		binary.Write(pck, binary.LittleEndian, uint32(0x43504447)), // 0 magic
-		binary.Write(pck, binary.LittleEndian, uint32(3)),          // 4 version
-		binary.Write(pck, binary.LittleEndian, uint32(4)),          // 8 version_major
-		binary.Write(pck, binary.LittleEndian, uint32(5)),          // 12 version_minor
-		binary.Write(pck, binary.LittleEndian, uint32(1)),          // 16 version_patch
-		binary.Write(pck, binary.LittleEndian, uint32(1<<1)),       // 20 pack_flags
-		binary.Write(pck, binary.LittleEndian, int64(0)),           // 24 file_base
-		binary.Write(pck, binary.LittleEndian, int64(40)),          // 32 dir_offset
-		binary.Write(pck, binary.LittleEndian, uint32(0)),          // 40 file_count
-	)
+	header := Header{
+		Version: Version3,
+		Engine:  [3]uint32{4, 5, 1},
+		Flags:   PackRelFileBase,
+	}
+	header.DirOffset = header.size()
+	if _, err := pck.Write(header.encode()); err != nil {
+		return xray.New(err)
+	}
+	if err := binary.Write(pck, binary.LittleEndian, uint32(0)); err != nil {
+		return xray.New(err)
+	}
+	return nil
 }
 
+// ErrHashMismatch is returned by [Remap] when the content it copied does not
+// hash to the file's Hash, which leaves the file marked as missing.
+var ErrHashMismatch = errors.New("content does not match its hash")
+
 // Remap a file from a src pck, to the given file in the dst pck, the files
-// must have the same size and hash. Updates the index to mark the file as present.
+// must have the same size and hash. Updates the index to mark the file as
+// present, once the content copied is checked against the hash. An encrypted
+// file is copied as is (and can't be checked without its key), so it must be
+// encrypted with the same key as the rest of the dst pck.
 func Remap(dst io.WriteSeeker, src io.ReadSeeker, next, prev File) error {
 	if next.Size != prev.Size {
 		return xray.New(errors.New("cannot remap file: size mismatch"))
@@ -225,15 +432,25 @@ func Remap(dst io.WriteSeeker, src io.ReadSeeker, next, prev File) error {
 	if next.Hash != prev.Hash {
 		return xray.New(errors.New("cannot remap file: hash mismatch"))
 	}
+	if next.Encrypted() != prev.Encrypted() {
+		return xray.New(errors.New("cannot remap file: encryption mismatch"))
+	}
+	if prev.Sparse || next.Sparse {
+		return xray.New(errors.New("cannot remap sparse file"))
+	}
 	if _, err := src.Seek(prev.Seek, io.SeekStart); err != nil {
 		return xray.New(err)
 	}
 	if _, err := dst.Seek(next.Seek, io.SeekStart); err != nil {
 		return xray.New(err)
 	}
-	if _, err := io.CopyN(dst, src, next.Size); err != nil {
+	hash := md5.New()
+	if _, err := io.CopyN(io.MultiWriter(dst, hash), src, next.Stored()); err != nil {
 		return xray.New(err)
 	}
+	if !next.Encrypted() && [md5.Size]byte(hash.Sum(nil)) != next.Hash {
+		return xray.New(fmt.Errorf("cannot remap file at seek %d: %w", prev.Seek, ErrHashMismatch))
+	}
 	if next.Head > 0 {
 		next.SetMissing(false, dst)
 	}
@@ -0,0 +1,305 @@
+package pck
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte{0x5a}, KeySize)
+
+var testFiles = []Source{
+	{Path: "library/tree.png", Data: []byte("a picture of a tree")},
+	{Path: "library/secret.tres", Flag: FlagEncrypted, Data: bytes.Repeat([]byte("secret "), 9)},
+	{Path: "library/old.png", Flag: FlagRemoval},
+}
+
+// buffer is an in-memory pck file.
+type buffer struct {
+	bytes.Reader
+	data []byte
+}
+
+func newBuffer(data []byte) *buffer {
+	b := &buffer{data: data}
+	b.Reset(data)
+	return b
+}
+
+func (b *buffer) Write(p []byte) (int, error) {
+	pos, _ := b.Seek(0, 1)
+	if end := int(pos) + len(p); end > len(b.data) {
+		b.data = append(b.data, make([]byte, end-len(b.data))...)
+	}
+	copy(b.data[pos:], p)
+	b.Reset(b.data)
+	b.Seek(pos+int64(len(p)), 0)
+	return len(p), nil
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, header := range []Header{
+		{Version: Version2, Engine: [3]uint32{4, 3, 0}},
+		{Version: Version2, Engine: [3]uint32{4, 3, 0}, Flags: PackRelFileBase | PackDirEncrypted},
+		{Version: Version3, Engine: [3]uint32{4, 5, 1}, Flags: PackRelFileBase, Reserved: [16]uint32{1, 2, 3}},
+		{Version: Version3, Engine: [3]uint32{4, 5, 1}, Flags: PackRelFileBase | PackDirEncrypted},
+	} {
+		var buf bytes.Buffer
+		if err := Write(&buf, header, testKey, testFiles); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		// embedded at the end of an executable, relative offsets still hold.
+		embedded := append([]byte("an executable"), buf.Bytes()...)
+		for _, data := range [][]byte{buf.Bytes(), embedded} {
+			if header.Flags&PackRelFileBase == 0 && len(data) != buf.Len() {
+				continue
+			}
+			src := newBuffer(data)
+			src.Seek(int64(len(data)-buf.Len()), 0)
+			pack, err := Read(src, testKey)
+			if err != nil {
+				t.Fatalf("v%d flags %d: Read: %v", header.Version, header.Flags, err)
+			}
+			got := pack.Header
+			got.FileBase, got.DirOffset = 0, 0
+			if got != header {
+				t.Errorf("header = %+v, want %+v", got, header)
+			}
+			if len(pack.Entries) != len(testFiles) {
+				t.Fatalf("read %d entries, want %d", len(pack.Entries), len(testFiles))
+			}
+			index := pack.Index()
+			if _, ok := index["library/old.png"]; ok {
+				t.Error("removed file is indexed")
+			}
+			tree, ok := index["library/tree.png"]
+			if !ok {
+				t.Fatalf("index = %v, missing library/tree.png", index)
+			}
+			if data, err := tree.Bytes(src); err != nil || string(data) != string(testFiles[0].Data) {
+				t.Errorf("tree.Bytes = %q, %v", data, err)
+			}
+			secret := index["library/secret.tres"]
+			if _, err := secret.Bytes(src); err == nil {
+				t.Error("encrypted file read without its key")
+			}
+			if data, err := secret.Decrypt(src, testKey); err != nil || string(data) != string(testFiles[1].Data) {
+				t.Errorf("secret.Decrypt = %q, %v", data, err)
+			}
+			if _, err := secret.Decrypt(src, bytes.Repeat([]byte{1}, KeySize)); err == nil {
+				t.Error("encrypted file decrypted with the wrong key")
+			}
+		}
+		if header.Flags&PackDirEncrypted != 0 {
+			if _, err := Index(bytes.NewReader(buf.Bytes())); err == nil {
+				t.Error("encrypted directory indexed without its key")
+			}
+		}
+	}
+}
+
+func TestSparse(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "library.pck")
+	var buf bytes.Buffer
+	if err := Write(&buf, Header{Version: Version3, Flags: PackRelFileBase | PackSparseBundle}, testKey, testFiles[:2]); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	for _, src := range testFiles[:2] {
+		data := src.Data
+		if src.Flag&FlagEncrypted != 0 {
+			var err error
+			if data, err = Encrypt(data, testKey); err != nil {
+				t.Fatal(err)
+			}
+		}
+		file := SparsePath(path, src.Path)
+		os.MkdirAll(filepath.Dir(file), 0755)
+		if err := os.WriteFile(file, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index, err := Index(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	secret := index["library/secret.tres"]
+	if !secret.Sparse {
+		t.Fatal("file of a sparse pck is not sparse")
+	}
+	file, err := os.Open(SparsePath(path, "library/secret.tres"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if data, err := secret.Decrypt(file, testKey); err != nil || string(data) != string(testFiles[1].Data) {
+		t.Errorf("Decrypt = %q, %v", data, err)
+	}
+	if err := Append(newBuffer(buf.Bytes()), nil); err == nil {
+		t.Error("appended to a sparse pck")
+	}
+}
+
+func TestAppendRemap(t *testing.T) {
+	var src bytes.Buffer
+	if err := Write(&src, Header{Version: Version3, Engine: [3]uint32{4, 5, 1}, Flags: PackRelFileBase}, testKey, testFiles[:2]); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	from := newBuffer(src.Bytes())
+	files, err := Index(from)
+	if err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	dst := newBuffer(nil)
+	if err := Create(dst); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	dst.Seek(0, 0)
+	if empty, err := Index(dst); err != nil || len(empty) != 0 {
+		t.Fatalf("Index of a new pck = %v, %v", empty, err)
+	}
+	dst.Seek(0, 0)
+	if err := Append(dst, files); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	dst.Seek(0, 0)
+	slots, err := Index(dst)
+	if err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	for path, slot := range slots {
+		if !slot.Missing() {
+			t.Errorf("%s is not missing before it is remapped", path)
+		}
+		if err := Remap(dst, from, slot, files[path]); err != nil {
+			t.Fatalf("Remap: %v", err)
+		}
+	}
+	dst.Seek(0, 0)
+	remapped, err := Index(dst)
+	if err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if data, err := remapped["library/tree.png"].Bytes(dst); err != nil || string(data) != string(testFiles[0].Data) {
+		t.Errorf("Bytes = %q, %v", data, err)
+	}
+	secret := remapped["library/secret.tres"]
+	if secret.Missing() || !secret.Encrypted() {
+		t.Errorf("remapped flags = %b", secret.Flag)
+	}
+	if data, err := secret.Decrypt(dst, testKey); err != nil || string(data) != string(testFiles[1].Data) {
+		t.Errorf("Decrypt = %q, %v", data, err)
+	}
+
+	var v2 bytes.Buffer
+	if err := Write(&v2, Header{Version: Version2}, nil, testFiles[:1]); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := Append(newBuffer(v2.Bytes()), files); err == nil {
+		t.Error("appended to a version 2 pck")
+	}
+	if err := Write(&v2, Header{Version: 1}, nil, nil); err == nil {
+		t.Error("wrote a version 1 pck")
+	}
+	if _, err := Read(bytes.NewReader([]byte("GDPC")), nil); err == nil {
+		t.Error("read a truncated pck")
+	}
+}
+
+func TestRemapHashMismatch(t *testing.T) {
+	var src bytes.Buffer
+	if err := Write(&src, Header{Version: Version3, Flags: PackRelFileBase}, nil, testFiles[:1]); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	files, err := Index(bytes.NewReader(src.Bytes()))
+	if err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	dst := newBuffer(nil)
+	if err := Create(dst); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	dst.Seek(0, 0)
+	if err := Append(dst, files); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	dst.Seek(0, 0)
+	slots, err := Index(dst)
+	if err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	// a truncated download, padded out to the size of the file.
+	prev := files["library/tree.png"]
+	garbage := bytes.Clone(src.Bytes())
+	clear(garbage[prev.Seek+4 : prev.Seek+prev.Size])
+	if err := Remap(dst, bytes.NewReader(garbage), slots["library/tree.png"], prev); !errors.Is(err, ErrHashMismatch) {
+		t.Fatalf("Remap of corrupted content = %v, want ErrHashMismatch", err)
+	}
+	dst.Seek(0, 0)
+	if slots, err = Index(dst); err != nil || !slots["library/tree.png"].Missing() {
+		t.Errorf("after a failed Remap, the file is not missing (%v)", err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	var src bytes.Buffer
+	if err := Write(&src, Header{Version: Version3, Flags: PackRelFileBase}, testKey, testFiles[:2]); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	from := newBuffer(src.Bytes())
+	files, err := Index(from)
+	if err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	dst := newBuffer(nil)
+	if err := Create(dst); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	// append twice, leaving a stale directory and a file that is never
+	// filled in.
+	for _, path := range []string{"library/tree.png", "library/secret.tres"} {
+		dst.Seek(0, 0)
+		if err := Append(dst, map[string]File{path: files[path]}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	dst.Seek(0, 0)
+	slots, err := Index(dst)
+	if err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if err := Remap(dst, from, slots["library/tree.png"], files["library/tree.png"]); err != nil {
+		t.Fatalf("Remap: %v", err)
+	}
+	dst.Seek(0, 0)
+	var compacted bytes.Buffer
+	if err := Compact(&compacted, dst, nil); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if compacted.Len() >= len(dst.data) {
+		t.Errorf("compacted to %d bytes, from %d", compacted.Len(), len(dst.data))
+	}
+	index, err := Index(bytes.NewReader(compacted.Bytes()))
+	if err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if len(index) != 1 {
+		t.Errorf("compacted index = %v, want only the present file", index)
+	}
+	tree := index["library/tree.png"]
+	if data, err := tree.Bytes(newBuffer(compacted.Bytes())); err != nil || string(data) != string(testFiles[0].Data) {
+		t.Errorf("Bytes = %q, %v", data, err)
+	}
+
+	// files can be evicted.
+	compacted.Reset()
+	dst.Seek(0, 0)
+	if err := Compact(&compacted, dst, func(string, File) bool { return false }); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if index, err := Index(bytes.NewReader(compacted.Bytes())); err != nil || len(index) != 0 {
+		t.Errorf("after evicting every file, index = %v, %v", index, err)
+	}
+}
@@ -0,0 +1,196 @@
+package pck
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"runtime.link/api/xray"
+)
+
+// Source is a file to [Write] into a pck.
+type Source struct {
+	Path string // without any res:// prefix, which is added for version 2.
+	Flag Flag   // [FlagEncrypted] to encrypt it, or [FlagRemoval].
+	Data []byte
+}
+
+// align of each file in a pck, which is that of the AES blocks they may be
+// encrypted in.
+const align = 16
+
+// Encrypt data with the key, as an encrypted file is stored. Write encrypts the
+// files it writes, this is for the files of a sparse pck, that are stored
+// alongside it (see [SparsePath]).
+func Encrypt(data, key []byte) ([]byte, error) {
+	return encrypt(data, key)
+}
+
+// Write a new pck to dst, with the given header and files, encrypting the
+// directory and any files flagged to be, with the key. FileBase and DirOffset
+// are worked out, the rest of the header is written as given, and the pck is
+// assumed to start at the beginning of its file (which matters only for
+// version 2, without [PackRelFileBase]). The files of a [PackSparseBundle] are
+// left for the caller to store, see [Encrypt] and [SparsePath].
+func Write(dst io.Writer, header Header, key []byte, files []Source) error {
+	if header.Version != Version2 && header.Version != Version3 {
+		return xray.New(errors.New("cannot write pck: unsupported version"))
+	}
+	sparse := header.Flags&PackSparseBundle != 0
+	var (
+		entries []Entry
+		data    [][]byte
+	)
+	for _, src := range files {
+		path := src.Path
+		if header.Version < Version3 {
+			path = "res://" + path
+		}
+		stored := src.Data
+		if src.Flag&FlagEncrypted != 0 {
+			var err error
+			if stored, err = encrypt(src.Data, key); err != nil {
+				return err
+			}
+		}
+		entries = append(entries, Entry{Path: path, File: File{
+			Size:   int64(len(src.Data)),
+			Hash:   md5.Sum(src.Data),
+			Flag:   src.Flag,
+			Sparse: sparse,
+		}})
+		data = append(data, stored)
+	}
+	return write(dst, header, key, entries, func(w io.Writer, i int) error {
+		_, err := w.Write(data[i])
+		return err
+	})
+}
+
+// Compact writes a copy of the version 3 pck in src to dst, without the files
+// that are missing, or that keep returns false for, and without the space
+// taken up by any files and directories it no longer refers to. The content of
+// each file is copied as is, so that the pck need not fit in memory.
+func Compact(dst io.Writer, src io.ReadSeeker, keep func(path string, file File) bool) error {
+	pack, err := Read(src, nil)
+	if err != nil {
+		return err
+	}
+	switch {
+	case pack.Version != Version3:
+		return xray.New(errors.New("cannot compact a pck older than version 3"))
+	case pack.Flags&PackSparseBundle != 0:
+		return xray.New(errors.New("cannot compact a sparse pck"))
+	}
+	var kept []Entry
+	for _, entry := range pack.Entries {
+		if entry.Missing() || (keep != nil && !keep(entry.Path, entry.File)) {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return write(dst, pack.Header, nil, kept, func(w io.Writer, i int) error {
+		if _, err := src.Seek(kept[i].Seek, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := io.CopyN(w, src, kept[i].Stored())
+		return err
+	})
+}
+
+// write the pck, with the content of each entry written by content, which
+// must write as many bytes as the entry takes up in the pck (see
+// [File.Stored]), then lays out and writes the directory.
+func write(dst io.Writer, header Header, key []byte, entries []Entry, content func(w io.Writer, i int) error) error {
+	sparse := header.Flags&PackSparseBundle != 0
+	dir := make([]Entry, len(entries))
+	var offset int64
+	for i, entry := range entries {
+		entry.Seek = 0
+		if !sparse {
+			entry.Seek = offset
+			offset = pad(offset+entry.Stored(), align)
+		}
+		dir[i] = entry
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(len(dir)))
+	var encoded bytes.Buffer
+	for _, entry := range dir {
+		name := make([]byte, pad(int64(len(entry.Path)), 4))
+		copy(name, entry.Path)
+		binary.Write(&encoded, binary.LittleEndian, uint32(len(name)))
+		encoded.Write(name)
+		binary.Write(&encoded, binary.LittleEndian, entry.Seek)
+		binary.Write(&encoded, binary.LittleEndian, entry.Size)
+		binary.Write(&encoded, binary.LittleEndian, entry.Hash)
+		binary.Write(&encoded, binary.LittleEndian, entry.Flag)
+	}
+	if header.Flags&PackDirEncrypted != 0 {
+		sealed, err := encrypt(encoded.Bytes(), key)
+		if err != nil {
+			return err
+		}
+		buf.Write(sealed)
+	} else {
+		buf.Write(encoded.Bytes())
+	}
+	h := header
+	if h.Version >= Version3 {
+		h.FileBase = pad(h.size(), align)
+		h.DirOffset = pad(h.FileBase+offset, align)
+	} else {
+		h.FileBase = pad(h.size()+int64(buf.Len()), align)
+		h.DirOffset = 0
+	}
+	w := &counter{w: dst}
+	w.Write(h.encode())
+	if h.Version < Version3 {
+		w.Write(buf.Bytes())
+	}
+	if !sparse {
+		for i, entry := range dir {
+			w.skip(h.FileBase + entry.Seek)
+			if w.err == nil {
+				if err := content(w, i); err != nil && w.err == nil {
+					w.err = err
+				}
+			}
+		}
+	}
+	if h.Version >= Version3 {
+		w.skip(h.DirOffset)
+		w.Write(buf.Bytes())
+	}
+	if w.err != nil {
+		return xray.New(w.err)
+	}
+	return nil
+}
+
+// counter counts the bytes written to w, and keeps the first error, after
+// which nothing more is written.
+type counter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+// skip zero pads up to the offset.
+func (c *counter) skip(to int64) {
+	if to > c.n {
+		c.Write(make([]byte, to-c.n))
+	}
+}
@@ -2,18 +2,23 @@ package internal
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"graphics.gd/classdb/Engine"
 	"graphics.gd/classdb/ProjectSettings"
 	"graphics.gd/classdb/ResourceFormatLoader"
 	"the.quetzal.community/aviary/internal/httpseek"
+	"the.quetzal.community/aviary/internal/library"
 	"the.quetzal.community/aviary/internal/pck"
 )
 
@@ -42,28 +47,84 @@ type CommunityResourceLoader struct {
 	preview map[string]pck.File
 
 	cache *httpseek.URL
+
+	reindexing atomic.Bool // see reindex.
+
+	used      map[string]int64 // when each library resource was last used, see touch.
+	usedSaved time.Time
 }
 
+// libraryVerifyFailures counts the library downloads (by the loader, or
+// prefetched) whose content did not match the hash in the index (truncated, or
+// replaced by a proxy along the way), see CloudControl.set_library_status_reason.
+var libraryVerifyFailures atomic.Int64
+
 func NewCommunityResourceLoader() *CommunityResourceLoader {
 	crl := &CommunityResourceLoader{}
 	if runtime.GOOS == "js" {
 		return crl
 	}
+	swapCompactedLibrary()
 	defer ProjectSettings.LoadResourcePack("user://library.pck", 0)
 	if os.Getenv("AVIARY_DOWNLOAD") == "0" {
 		crl.load(nil)
 		return crl
 	}
-	cloud, err := httpseek.New("https://vpk.quetzal.community/library.pck")
+	libraryRepublished = crl.reindex
+	cloud, err := openLibrary()
 	if err != nil {
 		Engine.Raise(err)
-	} else {
-		cloud.OnResourceModified(crl.load)
 	}
+	crl.cache = cloud
 	crl.load(cloud)
 	return crl
 }
 
+// libraryURL is where the community library.pck is published.
+const libraryURL = "https://vpk.quetzal.community/library.pck"
+
+// libraryRepublished is called whenever a connection opened with openLibrary
+// finds that the community library.pck has been republished since it was
+// opened, see [CommunityResourceLoader.reindex].
+var libraryRepublished func(*httpseek.URL)
+
+// openLibrary opens a connection to download from the community library.pck.
+func openLibrary() (*httpseek.URL, error) {
+	url, err := httpseek.New(libraryURL)
+	if err != nil {
+		return nil, err
+	}
+	if libraryRepublished != nil {
+		url.OnResourceModified(libraryRepublished)
+	}
+	return url, nil
+}
+
+// reindex reloads the index of the community library.pck, once it has been
+// republished (as noticed by any of the connections downloading from it),
+// on the loader thread, which the indexes belong to. The downloads that were
+// under way fail with [httpseek.ErrResourceChanged], and are downloaded again
+// from the new index when they are next asked for.
+func (crl *CommunityResourceLoader) reindex(*httpseek.URL) {
+	if !crl.reindexing.CompareAndSwap(false, true) {
+		return
+	}
+	postToLoader(func() {
+		defer crl.reindexing.Store(false)
+		if crl.cache != nil {
+			crl.cache.Close()
+			crl.cache = nil
+		}
+		cloud, err := openLibrary()
+		if err != nil {
+			Engine.Raise(err)
+			return
+		}
+		crl.cache = cloud
+		crl.load(cloud)
+	})
+}
+
 // Tells whether or not this loader should load a resource from its resource path for a given type.
 //
 // If it is not implemented, the default behavior returns whether the path's extension is within the ones provided by [GetRecognizedExtensions], and if the type is within the ones provided by [GetResourceType].
@@ -82,6 +143,7 @@ func (crl *CommunityResourceLoader) RecognizePath(requested string, atype string
 	path_import := clean + ".import"
 	path_remap := clean + ".remap"
 	if entry, ok := crl.local[clean]; ok && !entry.Missing() {
+		crl.touch(clean)
 		if cloud, ok := crl.cloud[clean]; ok {
 			if entry.Hash != cloud.Hash && cloud.Size <= entry.Size {
 				crl.download(clean)
@@ -96,9 +158,14 @@ func (crl *CommunityResourceLoader) RecognizePath(requested string, atype string
 		return crl.remap(entry)
 	}
 	if _, ok := crl.cloud[clean]; ok {
-		crl.download(clean)
+		if !crl.prefetched(clean) {
+			crl.download(clean)
+		}
 		return false
 	}
+	if _, ok := crl.local[clean]; ok && crl.cloud == nil {
+		profMark("library: %q is not available offline", clean)
+	}
 	return false
 }
 
@@ -124,83 +191,120 @@ func (crl *CommunityResourceLoader) remap(entry pck.File) bool {
 }
 
 func (crl *CommunityResourceLoader) download(path string) {
+	if err := fetchLibraryResource(context.Background(), &crl.cache, path, crl.local[path], crl.cloud[path], &libraryVerifyFailures); err != nil {
+		Engine.Raise(fmt.Errorf("failed to download resource %q from community library: %v", path, err))
+		return
+	}
+	// Success: clear missing flag in memory (fetchLibraryResource did on disk).
+	file := crl.local[path]
+	file.Flag &^= pck.FlagMissing
+	crl.local[path] = file
+	crl.touch(path)
+}
+
+// fetchLibraryResource downloads the file prev of the community library.pck
+// into its reserved slot next, in the local library.pck, over the connection
+// in cache (opened if nil, and replaced after a failed attempt). Content that
+// fails verification is counted in failures. Once ctx is done, the download
+// is abandoned, leaving the slot missing.
+func fetchLibraryResource(ctx context.Context, cache **httpseek.URL, path string, next, prev pck.File, failures *atomic.Int64) error {
 	const maxAttempts = 3
 	var lastErr error
 	for attempt := 0; attempt < maxAttempts; attempt++ {
-		if crl.cache == nil {
-			cache, err := httpseek.New("https://vpk.quetzal.community/library.pck")
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if *cache == nil {
+			url, err := openLibrary()
 			if err != nil {
-				Engine.Raise(err)
-				return
+				return err
 			}
-			crl.cache = cache
+			*cache = url
 		}
-		reader := crl.cache
+		reader := contextReader{ctx, *cache}
 		local, err := os.OpenFile(UserDataDir+"/library.pck", os.O_RDWR, 0644)
 		if err != nil {
-			Engine.Raise(err)
-			return
+			return err
 		}
 		// Note: defer close is per-attempt; we close explicitly on retry.
 		func() {
 			defer local.Close()
-			next := crl.local[path]
-			prev := crl.cloud[path]
 			if err := pck.Remap(local, reader, next, prev); err != nil {
 				lastErr = err
-				// Transient network error (e.g. "http2: response body closed").
+				if ctx.Err() != nil {
+					lastErr = ctx.Err()
+				}
+				if errors.Is(err, pck.ErrHashMismatch) {
+					n := failures.Add(1)
+					profMark("library: %q failed verification (attempt %d, %d failures so far)", path, attempt+1, n)
+				}
+				// Transient network error (e.g. "http2: response body closed"), or
+				// content that failed verification against its hash.
 				// Invalidate any partial bytes written to the reserved data slot
 				// in the pck. This prevents Godot from later reading corrupt
 				// .scn / .ctex data (leading to BasisUniversal unpack failures,
 				// OOB in cowdata, and hard crashes like illegal instruction).
 				// Zeroing ensures a clean "empty resource" parse failure instead.
-				if next.Size > 0 {
-					if _, seekErr := local.Seek(next.Seek, io.SeekStart); seekErr == nil {
-						zero := make([]byte, 64<<10)
-						rem := next.Size
-						for rem > 0 {
-							n := int64(len(zero))
-							if n > rem {
-								n = rem
-							}
-							local.Write(zero[:n])
-							rem -= n
-						}
-					}
-				}
-				// Re-mark missing on disk (defensive, in case dir was touched).
-				if next.Head > 0 {
-					next.SetMissing(true, local)
-				}
+				discardSlot(local, next)
 				// Force a fresh connection on next attempt.
-				if crl.cache != nil {
-					crl.cache.Close()
-					crl.cache = nil
+				if *cache != nil {
+					(*cache).Close()
+					*cache = nil
 				}
 				if attempt < maxAttempts-1 {
 					// small backoff
-					time.Sleep(time.Duration(1<<uint(attempt)) * 250 * time.Millisecond)
+					select {
+					case <-ctx.Done():
+					case <-time.After(time.Duration(1<<uint(attempt)) * 250 * time.Millisecond):
+					}
 				}
 				return
 			}
-			// Success: clear missing flag in memory and on disk.
-			file := crl.local[path]
-			file.Flag = 0
-			crl.local[path] = file
-			if file.Head > 0 {
-				file.SetMissing(false, local)
-			}
 			lastErr = nil
 		}()
 		if lastErr == nil {
-			return
+			return nil
+		}
+		if errors.Is(lastErr, httpseek.ErrResourceChanged) {
+			// library.pck was republished, so prev is no longer where (or
+			// what) it was, until the index is reloaded.
+			return lastErr
 		}
 	}
-	if lastErr != nil {
-		Engine.Raise(fmt.Errorf("failed to download resource %q from community library: %v", path, lastErr))
+	return lastErr
+}
+
+// discardSlot zeroes whatever was written into the slot reserved in the local
+// library.pck for a resource that failed to download, and marks it missing
+// again (defensive, in case the directory was touched).
+func discardSlot(local *os.File, next pck.File) {
+	if next.Size > 0 {
+		if _, err := local.Seek(next.Seek, io.SeekStart); err == nil {
+			zero := make([]byte, 64<<10)
+			rem := next.Stored()
+			for rem > 0 {
+				n := int64(len(zero))
+				if n > rem {
+					n = rem
+				}
+				local.Write(zero[:n])
+				rem -= n
+			}
+		}
+	}
+	if next.Head > 0 {
+		next.SetMissing(true, local)
 	}
 }
 
+// contextReader reads from the URL with ctx.
+type contextReader struct {
+	ctx context.Context
+	*httpseek.URL
+}
+
+func (r contextReader) Read(p []byte) (int, error) { return r.ReadContext(r.ctx, p) }
+
 type localFetcher struct {
 	*os.File
 }
@@ -268,6 +372,29 @@ func (crl *CommunityResourceLoader) load(resource *httpseek.URL) {
 			Engine.Raise(err)
 			return
 		}
+		size, err := local.Seek(0, io.SeekEnd)
+		if err != nil {
+			Engine.Raise(err)
+			return
+		}
+		defer crl.compact(size)
+		defer func() {
+			libraryPrefetch.reset(maps.Clone(crl.local), maps.Clone(crl.cloud), maps.Clone(crl.preview))
+		}()
+	} else {
+		// offline, so resolved exclusively from the local library.pck.
+		if _, err := local.Seek(0, io.SeekStart); err != nil {
+			Engine.Raise(err)
+			return
+		}
+		crl.local, err = pck.Index(local)
+		if err != nil {
+			Engine.Raise(err)
+			return
+		}
+		defer func() {
+			libraryOffline.reset(maps.Clone(crl.local), maps.Clone(crl.preview))
+		}()
 	}
 	preview, err := os.OpenFile(UserDataDir+"/preview.pck", os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
@@ -280,6 +407,16 @@ func (crl *CommunityResourceLoader) load(resource *httpseek.URL) {
 		Engine.Raise(err)
 		return
 	}
+	// The library's manifest of authors, where library.pck's (being the
+	// most recent) takes precedence over preview.pck's.
+	if _, err := library.LoadPCK(preview, crl.preview); err != nil {
+		Engine.Raise(fmt.Errorf("failed to load library authors from preview.pck: %w", err))
+	}
+	if resource != nil {
+		if _, err := library.LoadPCK(resource, crl.cloud); err != nil {
+			Engine.Raise(fmt.Errorf("failed to load library authors from library.pck: %w", err))
+		}
+	}
 	if _, err := local.Seek(0, io.SeekStart); err != nil {
 		Engine.Raise(err)
 		return
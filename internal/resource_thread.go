@@ -174,6 +174,17 @@ func ExistsSync(path string) bool {
 	return <-done
 }
 
+// postToLoader runs job on the loader thread, without waiting for it (or for
+// room in the queue), so that it is safe to call from any goroutine, the
+// loader thread included. Without a loader thread, job runs inline.
+func postToLoader(job func()) {
+	if !loaderRunning {
+		job()
+		return
+	}
+	go func() { resourceJobQueue <- job }()
+}
+
 // applyMeshMaterial assigns a freshly-loaded material to surface 0 of the
 // mesh identified by id, honouring the same AO-override sharing cache as
 // the synchronous path. Runs on the main thread (only ever called from a
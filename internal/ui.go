@@ -149,7 +149,13 @@ func (ui *UI) Setup() {
 	ui.Toolbar.Settings.AsBaseButton().OnPressed(ui.toggleSettings)
 	ui.Toolbar.Undo.AsBaseButton().OnPressed(ui.undo)
 	ui.Toolbar.Redo.AsBaseButton().OnPressed(ui.redo)
+	// Shift+click exports the whole world as a .aviary archive, rather
+	// than the active editor's content as glTF.
 	ui.Toolbar.Export.AsBaseButton().OnPressed(func() {
+		if Input.IsKeyPressed(Input.KeyShift) {
+			ui.client.ExportWork()
+			return
+		}
 		ui.client.Export()
 	})
 	// The Help button opens the online guide in the user's browser.
@@ -170,6 +176,10 @@ func (ui *UI) Setup() {
 	// Spin the cog / shading icon each time its menu rolls out or in.
 	ui.settingsRollout.icon = ui.Toolbar.Settings.AsControl()
 	ui.environmentRollout.icon = ui.EditorIndicator.Shading.AsControl()
+
+	if ui.client.checkpoint != nil {
+		ui.showCheckpoint(*ui.client.checkpoint)
+	}
 }
 
 func (ui *UI) SetMode(mode Mode) {
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"fmt"
 	"sync/atomic"
 	"time"
 
@@ -100,9 +101,17 @@ type CloudControl struct {
 	allowedGizmos map[Gizmo]bool
 
 	sharing    bool
+	shared     int // incremented each time a join code is shared
 	client     *Client
 	on_process chan func(*CloudControl)
 
+	// onlineReason and libraryReason make up the tooltip of the online
+	// status indicator, see set_online_status_reason and
+	// set_library_status_reason.
+	onlineReason   string
+	libraryReason  string
+	verifyFailures int64 // of the library downloads, as last shown.
+
 	// sizeSlider is the terrain brush-size slider built in code and
 	// parented to CloudControl (a sibling of GizmoTypes, like
 	// GizmoIndicator). It only shows while the terrain editor is active
@@ -259,9 +268,21 @@ func (ui *CloudControl) Ready() {
 					ui.on_process <- func(cc *CloudControl) { cc.set_join_code("") }
 					return
 				}
-				ui.on_process <- func(cc *CloudControl) { cc.set_join_code(code) }
+				var shared int
+				ui.on_process <- func(cc *CloudControl) {
+					cc.shared++
+					shared = cc.shared
+					cc.set_join_code(code)
+				}
+				// The code expires once it is hidden, unless it has since been
+				// rotated by sharing again.
 				time.Sleep(5 * time.Minute)
-				ui.on_process <- func(cc *CloudControl) { cc.set_join_code("") }
+				ui.on_process <- func(cc *CloudControl) {
+					if cc.shared == shared {
+						cc.set_join_code("")
+						go cc.client.apiRevoke()
+					}
+				}
 			}()
 		}
 	})
@@ -296,6 +317,31 @@ func (ui *CloudControl) set_online_status_indicator(online bool) {
 	grad.SetColors(cols)
 }
 
+// set_online_status_reason explains the online status indicator in its
+// tooltip, e.g. why a join failed (see networking.Stats.Diagnosis).
+func (ui *CloudControl) set_online_status_reason(reason string) {
+	ui.onlineReason = reason
+	ui.set_status_tooltip()
+}
+
+// set_library_status_reason explains, alongside the online status, any problem
+// with the library downloads, e.g. that some were corrupted along the way.
+func (ui *CloudControl) set_library_status_reason(reason string) {
+	ui.libraryReason = reason
+	ui.set_status_tooltip()
+}
+
+func (ui *CloudControl) set_status_tooltip() {
+	reason := ui.onlineReason
+	if ui.libraryReason != "" {
+		if reason != "" {
+			reason += "\n"
+		}
+		reason += ui.libraryReason
+	}
+	ui.HBoxContainer.Cloud.OnlineIndicator.AsControl().SetTooltipText(reason)
+}
+
 func (ui *CloudControl) set_join_code(code networking.Code) {
 	ui.JoinCode.ShareButton.AsCanvasItem().SetMaterial(Material.Nil)
 	size := ui.JoinCode.AsControl().Size()
@@ -313,6 +359,10 @@ func (ui *CloudControl) Process(dt Float.X) {
 	ui.positionSizeSlider()
 	ui.positionDensitySlider()
 	ui.positionPowerSlider()
+	if n := libraryVerifyFailures.Load(); n != ui.verifyFailures {
+		ui.verifyFailures = n
+		ui.set_library_status_reason(fmt.Sprintf("%d library downloads didn't match their hash and were discarded, a proxy may be altering them.", n))
+	}
 	for {
 		select {
 		case fn := <-ui.on_process:
@@ -30,6 +30,7 @@ import (
 	"graphics.gd/variant/Object"
 	"graphics.gd/variant/String"
 	"graphics.gd/variant/Vector2"
+	"the.quetzal.community/aviary/internal/library"
 	"the.quetzal.community/aviary/internal/musical"
 )
 
@@ -205,6 +206,13 @@ func (de *DesignExplorer) Ready() {
 			button.AsControl().
 				SetSizeFlagsHorizontal(Control.SizeShrinkBegin).
 				SetCustomMinimumSize(Vector2.New(72, 64))
+			if info, ok := library.Lookup(name); ok {
+				tooltip := info.Name
+				if info.License != "" {
+					tooltip += " (" + info.License + ")"
+				}
+				button.AsControl().SetTooltipText(tooltip)
+			}
 			button.AsBaseButton().OnPressed(func() {
 				for theme := range de.themes_available_for_editor[editorMode{
 					Editor: de.client.Editing,
@@ -657,6 +665,11 @@ func (ui *DesignExplorer) Refresh(editor Subject, author string, mode Mode) {
 					if tscn := library_path + "/" + tab + "/" + String.TrimSuffix(resource, ".png") + ".tscn"; FileAccess.FileExists(tscn) {
 						resource = tscn
 					}
+					// Offline, designs missing from the local library.pck
+					// can't be placed, so they aren't shown.
+					if !libraryOffline.Available(resource) {
+						continue
+					}
 					// Load the thumbnail off the main thread: the palette has
 					// hundreds of these and they aren't needed for the world to
 					// render, so blocking on each one stalled the whole load. The
@@ -696,6 +709,9 @@ func (ui *DesignExplorer) Refresh(editor Subject, author string, mode Mode) {
 					if FileAccess.FileExists(region_path) {
 						resource = region_path
 					}
+					if !libraryOffline.Available(resource) {
+						continue
+					}
 					// Thumbnail loaded off the main thread (see the glb case).
 					tile := TextureButton.New().
 						SetIgnoreTextureSize(true).
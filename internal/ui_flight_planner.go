@@ -4,20 +4,28 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
+	"maps"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"graphics.gd/classdb/BaseButton"
 	"graphics.gd/classdb/Button"
+	"graphics.gd/classdb/ConfirmationDialog"
 	"graphics.gd/classdb/Control"
 	"graphics.gd/classdb/DirAccess"
 	"graphics.gd/classdb/Engine"
+	"graphics.gd/classdb/GUI"
 	"graphics.gd/classdb/GridContainer"
 	"graphics.gd/classdb/Image"
 	"graphics.gd/classdb/ImageTexture"
+	"graphics.gd/classdb/Input"
+	"graphics.gd/classdb/Label"
 	"graphics.gd/classdb/Node"
 	"graphics.gd/classdb/Panel"
 	"graphics.gd/classdb/SceneTree"
@@ -28,6 +36,8 @@ import (
 	"graphics.gd/variant/Callable"
 	"graphics.gd/variant/Object"
 	"graphics.gd/variant/Vector2"
+	"the.quetzal.community/aviary/internal/datasize"
+	"the.quetzal.community/aviary/internal/ice/signalling"
 	"the.quetzal.community/aviary/internal/musical"
 	"the.quetzal.community/aviary/internal/networking"
 )
@@ -54,6 +64,15 @@ type FlightPlanner struct {
 	avatarsBuilt  bool
 	switcherReady bool
 
+	// Usage shows the cloud storage taken up by the saved maps, which each
+	// map's tooltip breaks down (see fetchCloudUsage). Reclaim, shown when
+	// devices that no longer edit some of the maps take up storage, lists
+	// their parts and asks before deleting them (see confirmReclaim).
+	Usage      Label.Instance
+	Reclaim    Button.Instance
+	usage      map[string]signalling.WorkUsage // by map name.
+	mapButtons map[string]Control.ID           // by map name.
+
 	client      *Client
 	clientReady sync.WaitGroup
 
@@ -68,25 +87,20 @@ func (fl *FlightPlanner) Reload() {
 		}
 	}
 	fl.Maps.SetColumns(int(fl.AsControl().Size().X/256) - 1)
+	fl.mapButtons = make(map[string]Control.ID)
 	DirAccess.MakeDirAbsolute("user://snaps")
 	for save := range DirAccess.Open("user://snaps").Iter() {
 		if strings.HasSuffix(save, ".png") {
 			fl.processed[save] = struct{}{}
-			fl.Maps.AsNode().AddChild(TextureButton.New().
+			name := strings.TrimSuffix(save, ".png")
+			button := TextureButton.New().
 				SetIgnoreTextureSize(true).
 				SetStretchMode(TextureButton.StretchKeepAspect).
 				AsTextureButton().SetTextureNormal(ImageTexture.CreateFromImage(Image.LoadFromFile("user://snaps/" + save)).AsTexture2D()).
-				AsBaseButton().OnPressed(
-				func() {
-					record, err := base64.RawURLEncoding.DecodeString(strings.TrimSuffix(save, ".png"))
-					if err != nil {
-						Engine.Raise(err)
-						return
-					}
-					fl.replaceTree(NewClientLoading(musical.WorkID(record)))
-				}).
-				AsControl().SetCustomMinimumSize(Vector2.New(256, 256)).AsNode(),
-			)
+				AsBaseButton().OnPressed(func() { fl.openMap(name) }).
+				AsControl().SetCustomMinimumSize(Vector2.New(256, 256))
+			fl.showUsage(name, button)
+			fl.Maps.AsNode().AddChild(button.AsNode())
 		}
 	}
 	if fl.switcherReady {
@@ -95,6 +109,7 @@ func (fl *FlightPlanner) Reload() {
 		fl.showMaps()
 	}
 	go fl.fetchCloudSnaps()
+	go fl.fetchCloudUsage()
 }
 
 // avatarPreviewURI maps an avatar library URI (res://library/.../x.glb) to its
@@ -138,6 +153,24 @@ func (fl *FlightPlanner) buildAvatarSwitcher() {
 	fl.switcherReady = true
 }
 
+// buildUsage adds the (initially hidden) cloud storage usage, and the button to
+// reclaim what stale devices take up of it, under the avatar button, see
+// fetchCloudUsage.
+func (fl *FlightPlanner) buildUsage() {
+	fl.Usage = Label.New()
+	fl.Usage.SetHorizontalAlignment(GUI.HorizontalAlignmentCenter)
+	fl.Usage.AsControl().AddThemeFontSizeOverride("font_size", 14)
+	fl.Usage.AsControl().SetMouseFilter(Control.MouseFilterPass)
+	fl.Usage.AsCanvasItem().SetVisible(false)
+	fl.Keys.AsNode().GetParent().AddChild(fl.Usage.AsNode())
+	fl.Reclaim = Button.New()
+	fl.Reclaim.AsControl().AddThemeFontSizeOverride("font_size", 14)
+	fl.Reclaim.AsControl().SetTooltipText(fmt.Sprintf("Merge the parts of maps written by devices that haven't synced them for %d days into this device's, and delete them from the cloud", staleAfter/(24*time.Hour)))
+	fl.Reclaim.AsBaseButton().OnPressed(fl.confirmReclaim)
+	fl.Reclaim.AsCanvasItem().SetVisible(false)
+	fl.Keys.AsNode().GetParent().AddChild(fl.Reclaim.AsNode())
+}
+
 // showMaps restores the saved-games view (and hides the avatar picker).
 func (fl *FlightPlanner) showMaps() {
 	fl.avatarPanel.AsCanvasItem().SetVisible(false)
@@ -241,16 +274,9 @@ func (fl *FlightPlanner) fetchCloudSnaps() {
 				SetIgnoreTextureSize(true).
 				SetStretchMode(TextureButton.StretchKeepAspect).
 				AsTextureButton().SetTextureNormal(ImageTexture.CreateFromImage(image).AsTexture2D()).
-				AsBaseButton().OnPressed(
-				func() {
-					record, err := base64.RawURLEncoding.DecodeString(string(save))
-					if err != nil {
-						Engine.Raise(err)
-						return
-					}
-					fl.replaceTree(NewClientLoading(musical.WorkID(record)))
-				}).
+				AsBaseButton().OnPressed(func() { fl.openMap(string(save)) }).
 				AsControl().SetCustomMinimumSize(Vector2.New(256, 256))
+			fl.showUsage(string(save), mapButton)
 			select {
 			case fl.on_process <- func() {
 				fl.Maps.AsNode().AddChild(mapButton.AsNode())
@@ -263,6 +289,202 @@ func (fl *FlightPlanner) fetchCloudSnaps() {
 	}
 }
 
+// fetchCloudUsage shows how much cloud storage the saved maps take up, in total
+// and (as its tooltip) for each map.
+func (fl *FlightPlanner) fetchCloudUsage() {
+	fl.clientReady.Wait()
+	fl.client.clientReady.Wait()
+	if !UserState.Aviary.TogetherUntil.After(time.Now()) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	usage, err := signalling.StorageUsage(ctx, fl.client.signalling)
+	if err != nil {
+		Engine.Raise(err)
+		return
+	}
+	Callable.Defer(Callable.New(func() {
+		fl.usage = make(map[string]signalling.WorkUsage, len(usage.Works))
+		for _, work := range usage.Works {
+			fl.usage[string(work.Work)] = work
+			if button, ok := fl.mapButtons[string(work.Work)].Instance(); ok {
+				fl.showUsage(string(work.Work), button)
+			}
+		}
+		devices := "1 device"
+		if len(usage.Devices) != 1 {
+			devices = fmt.Sprintf("%d devices", len(usage.Devices))
+		}
+		fl.Usage.SetText(fmt.Sprintf("%s in the cloud, from %s", datasize.ByteSize(usage.Size).HumanReadable(), devices))
+		fl.Usage.AsCanvasItem().SetVisible(true)
+		var stale int64
+		for _, work := range usage.Works {
+			for _, part := range staleParts(work) {
+				stale += work.Parts[part].Size
+			}
+		}
+		fl.Reclaim.SetText(fmt.Sprintf("Reclaim %s from idle devices", datasize.ByteSize(stale).HumanReadable()))
+		fl.Reclaim.AsCanvasItem().SetVisible(stale > 0)
+	}))
+}
+
+// showUsage notes the map's button, and shows how much cloud storage the map
+// takes up as its tooltip, once known.
+func (fl *FlightPlanner) showUsage(name string, button Control.Instance) {
+	fl.mapButtons[name] = button.ID()
+	if usage, ok := fl.usage[name]; ok {
+		button.SetTooltipText(describeUsage(usage))
+	}
+}
+
+// confirmReclaim lists the parts of the maps written by devices that haven't
+// synced them for staleAfter (other than those of the map being edited), and
+// reclaims them once the user confirms that they may be deleted.
+func (fl *FlightPlanner) confirmReclaim() {
+	stale := make(map[musical.WorkID][]signalling.PartID)
+	var lines []string
+	for _, name := range slices.Sorted(maps.Keys(fl.usage)) {
+		record, err := base64.RawURLEncoding.DecodeString(name)
+		if err != nil || musical.WorkID(record) == fl.client.record {
+			continue
+		}
+		usage := fl.usage[name]
+		parts := staleParts(usage)
+		if len(parts) == 0 {
+			continue
+		}
+		stale[musical.WorkID(record)] = parts
+		lines = append(lines, "Map "+name+":")
+		for _, part := range parts {
+			lines = append(lines, fmt.Sprintf("    %s, from device %s, last synced %s",
+				datasize.ByteSize(usage.Parts[part].Size).HumanReadable(), part.Device(), usage.Devices[part.Device()].Synced.Local().Format("2 Jan 2006")))
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+	dialog := ConfirmationDialog.New()
+	dialog.AsWindow().SetTitle("Reclaim cloud storage")
+	dialog.AsAcceptDialog().SetDialogText(fmt.Sprintf("These parts were written by devices that haven't synced their map for %d days. "+
+		"Their records will be merged into this device's part of the map, and then they will be deleted from the cloud.\n\n%s",
+		staleAfter/(24*time.Hour), strings.Join(lines, "\n")))
+	dialog.AsAcceptDialog().SetOkButtonText("Delete")
+	dialog.AsAcceptDialog().OnConfirmed(func() {
+		dialog.AsNode().QueueFree()
+		go fl.reclaim(stale)
+	})
+	dialog.AsAcceptDialog().OnCanceled(func() { dialog.AsNode().QueueFree() })
+	fl.AsNode().AddChild(dialog.AsNode())
+	dialog.AsWindow().PopupCentered()
+}
+
+// reclaim merges and deletes the confirmed stale parts of each work, see
+// reclaimStale.
+func (fl *FlightPlanner) reclaim(stale map[musical.WorkID][]signalling.PartID) {
+	fl.clientReady.Wait()
+	fl.client.clientReady.Wait()
+	if !UserState.Aviary.TogetherUntil.After(time.Now()) {
+		return
+	}
+	for work, parts := range stale {
+		if fl.client.record == work {
+			Engine.Raise(errors.New("cannot reclaim the storage of the map being edited"))
+			continue
+		}
+		if _, err := reclaimStale(fl.client.signalling, work, parts); err != nil {
+			Engine.Raise(err)
+		}
+	}
+	go fl.fetchCloudUsage()
+}
+
+// openMap loads the saved map named name. With Ctrl held, it forks the map into
+// a new one instead (with Ctrl+Shift, reattributed to this device), with only
+// Shift held, it lists the map's checkpoints.
+func (fl *FlightPlanner) openMap(name string) {
+	record, err := base64.RawURLEncoding.DecodeString(name)
+	if err != nil {
+		Engine.Raise(err)
+		return
+	}
+	if Input.IsKeyPressed(Input.KeyCtrl) {
+		rewrite := Input.IsKeyPressed(Input.KeyShift)
+		go fl.fork(func(community signalling.API, cloud bool) (musical.WorkID, error) {
+			return forkWork(community, cloud, musical.WorkID(record), rewrite)
+		})
+		return
+	}
+	if Input.IsKeyPressed(Input.KeyShift) {
+		go fl.fetchCheckpoints(musical.WorkID(record))
+		return
+	}
+	fl.replaceTree(NewClientLoading(musical.WorkID(record)))
+}
+
+// fetchCheckpoints replaces the maps with the checkpoints of the work, newest
+// first. Clicking a checkpoint opens the work as it was at the checkpoint, with
+// Shift held, it forks the checkpoint into a new map instead.
+func (fl *FlightPlanner) fetchCheckpoints(work musical.WorkID) {
+	fl.clientReady.Wait()
+	fl.client.clientReady.Wait()
+	cloud := UserState.Aviary.TogetherUntil.After(time.Now())
+	var checkpoints []signalling.Checkpoint
+	var err error
+	if cloud {
+		checkpoints, err = syncCheckpoints(fl.client.signalling, work)
+	} else {
+		checkpoints, err = loadCheckpoints(base64.RawURLEncoding.EncodeToString(work[:]))
+	}
+	if err != nil {
+		Engine.Raise(err)
+	}
+	slices.Reverse(checkpoints)
+	Callable.Defer(Callable.New(func() {
+		for i, child := range fl.Maps.AsNode().GetChildren() {
+			if i > 0 {
+				child.QueueFree()
+			}
+		}
+		for _, checkpoint := range checkpoints {
+			var image = Image.New()
+			if len(checkpoint.Thumbnail) > 0 {
+				image.LoadPngFromBuffer(checkpoint.Thumbnail)
+			}
+			fl.Maps.AsNode().AddChild(TextureButton.New().
+				SetIgnoreTextureSize(true).
+				SetStretchMode(TextureButton.StretchKeepAspect).
+				AsTextureButton().SetTextureNormal(ImageTexture.CreateFromImage(image).AsTexture2D()).
+				AsBaseButton().OnPressed(func() {
+				if Input.IsKeyPressed(Input.KeyShift) {
+					go fl.fork(func(community signalling.API, cloud bool) (musical.WorkID, error) {
+						return forkCheckpoint(community, cloud, work, checkpoint, false)
+					})
+					return
+				}
+				fl.replaceTree(NewClientAtCheckpoint(work, checkpoint))
+			}).
+				AsControl().SetTooltipText(checkpoint.Name).
+				AsControl().SetCustomMinimumSize(Vector2.New(256, 256)).AsNode(),
+			)
+		}
+	}))
+}
+
+// fork loads the new map made by the given fork operation.
+func (fl *FlightPlanner) fork(operation func(community signalling.API, cloud bool) (musical.WorkID, error)) {
+	fl.clientReady.Wait()
+	fl.client.clientReady.Wait()
+	fork, err := operation(fl.client.signalling, UserState.Aviary.TogetherUntil.After(time.Now()))
+	if err != nil {
+		Engine.Raise(err)
+		return
+	}
+	Callable.Defer(Callable.New(func() {
+		fl.replaceTree(NewClientLoading(fork))
+	}))
+}
+
 func (fl *FlightPlanner) replaceTree(fresh *Client) {
 	replaceSceneTree(fl.AsNode(), fresh)
 }
@@ -271,6 +493,8 @@ func (fl *FlightPlanner) replaceTree(fresh *Client) {
 // Client. Shared by the flight planner's load/join/new-save buttons
 // and the cloud login's session-swap path.
 func replaceSceneTree(anchor Node.Instance, fresh *Client) {
+	// the library resources still being prefetched are for the scene left.
+	libraryPrefetch.Cancel()
 	for _, child := range SceneTree.Get(anchor).Root().AsNode().GetChildren() {
 		child.QueueFree()
 	}
@@ -282,12 +506,18 @@ func (fl *FlightPlanner) Ready() {
 	fl.on_process = make(chan func(), 10)
 	fl.processed = make(map[string]struct{})
 	fl.buildAvatarSwitcher()
+	fl.buildUsage()
 	fl.Reload()
 	fl.Code.SetText("")
 	fl.Back.AsBaseButton().OnPressed(func() {
 		fl.AsCanvasItem().SetVisible(false)
 	})
 	fl.Plus.AsBaseButton().OnPressed(func() {
+		// Shift+click imports a world from a .aviary archive instead.
+		if Input.IsKeyPressed(Input.KeyShift) {
+			fl.importWorkDialog()
+			return
+		}
 		var record musical.WorkID
 		if _, err := rand.Read(record[:]); err != nil {
 			Engine.Raise(err)
@@ -303,8 +533,8 @@ func (fl *FlightPlanner) Ready() {
 				safe += string(char)
 			}
 		}
-		if len(safe) > 6 {
-			safe = safe[:6]
+		if len(safe) > joinCodeLength+roomPasswordLength {
+			safe = safe[:joinCodeLength+roomPasswordLength]
 		}
 		if text != safe {
 			fl.Code.SetText(safe)
@@ -324,9 +554,10 @@ func (fl *FlightPlanner) Ready() {
 			})
 		case ">":
 			Object.To[BaseButton.Instance](key).OnPressed(func() {
+				code, password := splitJoinCode(fl.Code.Text())
 				fresh := NewClientJoining()
 				fl.replaceTree(fresh)
-				go fresh.apiJoin(networking.Code(fl.Code.Text()))
+				go fresh.apiJoin(code, password)
 			})
 		default:
 			Object.To[BaseButton.Instance](key).OnPressed(func() {
@@ -337,3 +568,19 @@ func (fl *FlightPlanner) Ready() {
 		}
 	}
 }
+
+// joinCodeLength is the number of digits in a join code; any digits entered
+// after it are the room password (up to roomPasswordLength of them).
+const (
+	joinCodeLength     = 6
+	roomPasswordLength = 6
+)
+
+// splitJoinCode separates the digits entered on the dialpad into the join code
+// and the room password that follows it.
+func splitJoinCode(digits string) (networking.Code, string) {
+	if len(digits) <= joinCodeLength {
+		return networking.Code(digits), ""
+	}
+	return networking.Code(digits[:joinCodeLength]), digits[joinCodeLength:]
+}
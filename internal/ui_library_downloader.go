@@ -1,14 +1,13 @@
 package internal
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
 	"path/filepath"
-	"runtime"
 	"strconv"
+	"time"
 
 	"graphics.gd/classdb/Control"
 	"graphics.gd/classdb/DirAccess"
@@ -23,6 +22,7 @@ import (
 	"graphics.gd/variant/Callable"
 	"graphics.gd/variant/Float"
 	"the.quetzal.community/aviary/internal/datasize"
+	"the.quetzal.community/aviary/internal/download"
 )
 
 type LibraryDownloader struct {
@@ -37,15 +37,18 @@ type LibraryDownloader struct {
 
 	Progress ProgressBar.Instance
 
-	downloading      bool
-	total            datasize.ByteSize
-	bytes_downloaded chan datasize.ByteSize
-	done             chan struct{}
+	downloading bool
+	cancel      context.CancelFunc // the download, once it has started.
+	total       datasize.ByteSize
+	progress    chan download.Progress
+	done        chan struct{}
+	failed      chan error
 }
 
 func (dl *LibraryDownloader) Ready() {
-	dl.bytes_downloaded = make(chan datasize.ByteSize, 1)
+	dl.progress = make(chan download.Progress, 1)
 	dl.done = make(chan struct{}, 1)
+	dl.failed = make(chan error, 1)
 	dl.Progress.AsCanvasItem().SetVisible(false)
 	// HEAD the .pck off-thread so a slow / unreachable host can't
 	// block the splash-screen Ready. setContentLength touches UI
@@ -71,22 +74,16 @@ func (dl *LibraryDownloader) Ready() {
 		Callable.Defer(Callable.New(func() { dl.setContentLength(resp) }))
 	}()
 	dl.DownloadButton.AsBaseButton().OnPressed(func() {
-		runtime.LockOSThread()
-		defer runtime.UnlockOSThread()
 		if dl.downloading {
 			return
 		}
 		dl.downloading = true
 		dl.DownloadButton.Pointer.AsCanvasItem().SetVisible(false)
 		dl.Progress.AsCanvasItem().SetVisible(true)
-		resp, err := http.Get("https://vpk.quetzal.community/preview.pck")
-		if err != nil {
-			Engine.Raise(err)
-			return
-		}
-		dl.setContentLength(resp)
-		go dl.download(resp.Body)
 		dl.DownloadButton.Size.SetText("Downloading...")
+		ctx, cancel := context.WithCancel(context.Background())
+		dl.cancel = cancel
+		go dl.download(ctx)
 	})
 }
 
@@ -101,47 +98,55 @@ func (dl *LibraryDownloader) setContentLength(resp *http.Response) {
 	dl.Progress.AsRange().SetMaxValue(Float.X(contentLength))
 }
 
-type bytesCounter struct {
-	count  datasize.ByteSize
-	Notify chan datasize.ByteSize
-}
-
-func (wc *bytesCounter) Write(p []byte) (int, error) {
-	n := len(p)
-	wc.count += datasize.ByteSize(n)
-	select {
-	case wc.Notify <- wc.count:
-	default:
-	}
-	return n, nil
-}
-
-func (dl *LibraryDownloader) download(body io.ReadCloser) {
-	defer body.Close()
-	counter := &bytesCounter{
-		Notify: dl.bytes_downloaded,
-	}
-	reader := io.TeeReader(body, counter)
-	fmt.Println("Starting download of preview.pck...")
-	library, err := os.Create(filepath.Join(UserDataDir, "preview.pck"))
+// download preview.pck into place, resuming any download of it that was
+// interrupted before (see [download.File]).
+func (dl *LibraryDownloader) download(ctx context.Context) {
+	fmt.Println("Downloading preview.pck to", UserDataDir)
+	err := download.File{
+		URL:      "https://vpk.quetzal.community/preview.pck",
+		Manifest: "https://vpk.quetzal.community/preview.pck.sha256",
+		Path:     filepath.Join(UserDataDir, "preview.pck"),
+		Progress: func(progress download.Progress) {
+			select {
+			case dl.progress <- progress:
+			default:
+			}
+		},
+	}.Fetch(ctx)
 	if err != nil {
-		Engine.Raise(err)
-		return
-	}
-	fmt.Println("Downloading preview.pck to", library.Name())
-	defer library.Close()
-	if _, err := io.Copy(library, reader); err != nil {
-		Engine.Raise(err)
+		dl.failed <- err
 		return
 	}
 	close(dl.done)
 }
 
+// ExitTree stops any download, which resumes from where it was left the next
+// time it is started.
+func (dl *LibraryDownloader) ExitTree() {
+	if dl.cancel != nil {
+		dl.cancel()
+	}
+}
+
 func (dl *LibraryDownloader) Process(delta Float.X) {
 	select {
-	case bytes := <-dl.bytes_downloaded:
-		dl.Progress.AsRange().SetValue(Float.X(bytes))
-		dl.DownloadButton.Size.SetText((dl.total - bytes).HumanReadable())
+	case progress := <-dl.progress:
+		if progress.Total > 0 {
+			dl.total = datasize.ByteSize(progress.Total)
+			dl.Progress.AsRange().SetMaxValue(Float.X(progress.Total))
+		}
+		dl.Progress.AsRange().SetValue(Float.X(progress.Done))
+		left := (dl.total - datasize.ByteSize(progress.Done)).HumanReadable()
+		if progress.ETA > 0 {
+			left = fmt.Sprintf("%s (%s/s, %s)", left, datasize.ByteSize(progress.Rate).HumanReadable(), progress.ETA.Round(time.Second))
+		}
+		dl.DownloadButton.Size.SetText(left)
+	case err := <-dl.failed:
+		// what was downloaded is kept, for the next press to resume from.
+		Engine.Raise(err)
+		dl.downloading = false
+		dl.DownloadButton.Pointer.AsCanvasItem().SetVisible(true)
+		dl.DownloadButton.Size.SetText(dl.total.HumanReadable())
 	case <-dl.done:
 		if FileAccess.FileExists("res://preview.pck.backup") {
 			DirAccess.RemoveAbsolute("res://preview.pck.backup")
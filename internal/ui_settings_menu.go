@@ -1,13 +1,17 @@
 package internal
 
 import (
+	"strings"
+
 	"graphics.gd/classdb/Control"
 	"graphics.gd/classdb/HBoxContainer"
 	"graphics.gd/classdb/HSlider"
 	"graphics.gd/classdb/Image"
 	"graphics.gd/classdb/ImageTexture"
+	"graphics.gd/classdb/LineEdit"
 	"graphics.gd/classdb/Node"
 	"graphics.gd/classdb/Range"
+	"graphics.gd/classdb/SpinBox"
 	"graphics.gd/classdb/Texture2D"
 	"graphics.gd/classdb/TextureButton"
 	"graphics.gd/variant/Color"
@@ -78,12 +82,13 @@ func (ui *UI) buildSettingsMenu() {
 	launchQ.Apply(ui.AsNode())
 
 	ui.buildLicenseToggles()
+	ui.buildRoomAccess()
 }
 
 // buildLicenseToggles appends a row of the three Creative Commons license
 // badges (CC0, CC-BY, CC-BY-SA) below the quality slider. Each badge is a
 // toggle: switching one off hides every library author publishing under
-// that license from the design explorer (see authorLicense), dimming the
+// that license from the design explorer (see library.License), dimming the
 // badge to show the off state. The choice persists in
 // UserState.HiddenLicenses. The badges are the official Creative Commons
 // button SVGs (res://ui/license_*.svg).
@@ -138,6 +143,56 @@ func (ui *UI) buildLicenseToggles() {
 	}
 }
 
+// buildRoomAccess appends a row controlling who may join a shared scene: a
+// numeric room password, which joiners enter on the dialpad after the join
+// code, and the maximum number of joiners (0 for no limit). Both persist in
+// UserState and apply from the next time the join code is shared.
+func (ui *UI) buildRoomAccess() {
+	types := ui.SettingsMenu.AsNode().GetNode("SettingsTypes")
+	if types == Node.Nil {
+		return
+	}
+	row := HBoxContainer.New()
+	row.AsNode().SetName("RoomRow")
+	types.AddChild(row.AsNode())
+
+	password := LineEdit.New().
+		SetPlaceholderText("Room password").
+		SetMaxLength(roomPasswordLength).
+		SetSecret(true).
+		SetText(UserState.RoomPassword)
+	password.AsControl().
+		SetSizeFlagsHorizontal(Control.SizeExpandFill).
+		SetTooltipText("Digits that joiners must enter after the join code (leave empty for an open room)")
+	password.OnTextChanged(func(text string) {
+		safe := ""
+		for _, char := range text {
+			if strings.ContainsRune("0123456789", char) {
+				safe += string(char)
+			}
+		}
+		if safe != text {
+			password.SetText(safe)
+		}
+		UserState.RoomPassword = safe
+		if ui.client != nil {
+			ui.client.saveUserState()
+		}
+	})
+	row.AsNode().AddChild(password.AsNode())
+
+	limit := SpinBox.New().SetPrefix("max")
+	limit.AsRange().SetMinValue(0).SetMaxValue(255).SetValue(Float.X(UserState.MaxJoiners))
+	limit.AsControl().SetTooltipText("Most joiners allowed at once (0 for no limit)")
+	Range.Instance(limit.AsRange()).OnValueChanged(func(value Float.X) {
+		UserState.MaxJoiners = int(value)
+		if ui.client != nil {
+			ui.client.saveUserState()
+		}
+	})
+	row.AsNode().AddChild(limit.AsNode())
+}
+
 // toggleSettings rolls the Settings menu in and out from behind the
 // Toolbar triangle, sharing the Rollout helper with the editor switcher.
 func (ui *UI) toggleSettings() {
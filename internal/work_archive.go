@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"graphics.gd/classdb/DisplayServer"
+	"graphics.gd/classdb/Engine"
+	"graphics.gd/variant/Callable"
+	"runtime.link/api/xray"
+	"the.quetzal.community/aviary/internal/archive"
+	"the.quetzal.community/aviary/internal/ice/signalling"
+	"the.quetzal.community/aviary/internal/musical"
+)
+
+// exportWork writes a .aviary archive of the work (see archive.Export) to
+// path, with every part of it, both local and (when available) in the cloud.
+// The credits for the library artwork it uses are written alongside, as
+// Markdown (see library.Report), ready to paste into a video's description.
+func exportWork(community signalling.API, cloud bool, work musical.WorkID, path string) error {
+	name := base64.RawURLEncoding.EncodeToString(work[:])
+	offsets, err := workOffsets(community, cloud, name)
+	if err != nil {
+		return err
+	}
+	own := openOutbox(name, UserState.Device).Part
+	ids := slices.Sorted(maps.Keys(offsets))
+	// our own part first, like OpenCloud.
+	if i := slices.Index(ids, own); i > 0 {
+		ids = append(append([]signalling.PartID{own}, ids[:i]...), ids[i+1:]...)
+	}
+	parts := make([]archive.Part, len(ids))
+	for i, part := range ids {
+		parts[i] = archive.Part{Name: string(part), Open: func() (io.ReadCloser, error) {
+			return checkpointPrefix(community, cloud, name, part, offsets[part])
+		}}
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return xray.New(err)
+	}
+	defer file.Close()
+	manifest, err := archive.Export(file, UserDataDir, work, parts)
+	if err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return xray.New(err)
+	}
+	credits := strings.TrimSuffix(path, filepath.Ext(path)) + ".credits.md"
+	if err := os.WriteFile(credits, []byte(manifest.Attribution().Markdown()), 0666); err != nil {
+		return xray.New(err)
+	}
+	return nil
+}
+
+// saveUpload keeps the file uploaded into a design of the work, under
+// saves/<work>/blobs, so that it is archived along with the work (see
+// archive.Export).
+func saveUpload(work musical.WorkID, upload musical.Upload) error {
+	dir := UserDataDir + "/saves/" + base64.RawURLEncoding.EncodeToString(work[:]) + "/blobs"
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return xray.New(err)
+	}
+	file, err := os.Create(dir + "/" + archive.BlobName(upload.Design))
+	if err != nil {
+		return xray.New(err)
+	}
+	if _, err := io.Copy(file, upload.Upload); err != nil {
+		file.Close()
+		return xray.New(err)
+	}
+	if err := file.Close(); err != nil {
+		return xray.New(err)
+	}
+	return nil
+}
+
+// importWork validates the .aviary archive at path and registers its work as
+// a local work of this device, to be uploaded like any other once cloud saves
+// are available.
+func importWork(path string) (musical.WorkID, error) {
+	r, err := archive.OpenReader(path)
+	if err != nil {
+		return musical.WorkID{}, err
+	}
+	defer r.Close()
+	work, err := r.Install(UserDataDir, UserState.Device)
+	if err != nil {
+		return work, err
+	}
+	if _, err := openOutbox(r.Manifest.Work, UserState.Device).wrote(); err != nil {
+		Engine.Raise(err)
+	}
+	return work, nil
+}
+
+// ExportWork prompts the user with an OS-native file save dialog and writes
+// the whole work being edited to the chosen path as a .aviary archive, so
+// that it can be handed to someone offline.
+func (world *Client) ExportWork() {
+	if !DisplayServer.HasFeature(DisplayServer.FeatureNativeDialogFile) {
+		fmt.Fprintln(os.Stderr, "ExportWork: native file dialog unavailable on this platform")
+		return
+	}
+	startDir, _ := os.UserHomeDir()
+	work := world.record
+	err := DisplayServer.FileDialogShow(
+		"Export world",
+		startDir,
+		"aviary-"+base64.RawURLEncoding.EncodeToString(work[:])+archive.Extension,
+		false, // show_hidden
+		DisplayServer.FileDialogModeSaveFile,
+		[]string{"*" + archive.Extension + ";Aviary world"},
+		func(status bool, selected_paths []string, _ int) {
+			if !status || len(selected_paths) == 0 {
+				return // user cancelled
+			}
+			dst := selected_paths[0]
+			if !strings.HasSuffix(strings.ToLower(dst), archive.Extension) {
+				dst += archive.Extension
+			}
+			go func() {
+				if err := exportWork(world.signalling, UserState.Aviary.TogetherUntil.After(time.Now()), work, dst); err != nil {
+					os.Remove(dst)
+					Engine.Raise(fmt.Errorf("export failed: %w", err))
+					return
+				}
+				fmt.Println("Exported", dst)
+			}()
+		},
+		DisplayServer.MainWindowId,
+	)
+	if err != nil {
+		Engine.Raise(fmt.Errorf("file dialog failed: %w", err))
+	}
+}
+
+// importWorkDialog prompts the user with an OS-native file open dialog for a
+// .aviary archive, and loads the imported work.
+func (fl *FlightPlanner) importWorkDialog() {
+	if !DisplayServer.HasFeature(DisplayServer.FeatureNativeDialogFile) {
+		fmt.Fprintln(os.Stderr, "importWorkDialog: native file dialog unavailable on this platform")
+		return
+	}
+	startDir, _ := os.UserHomeDir()
+	err := DisplayServer.FileDialogShow(
+		"Import world",
+		startDir,
+		"",
+		false, // show_hidden
+		DisplayServer.FileDialogModeOpenFile,
+		[]string{"*" + archive.Extension + ";Aviary world"},
+		func(status bool, selected_paths []string, _ int) {
+			if !status || len(selected_paths) == 0 {
+				return // user cancelled
+			}
+			src := filepath.Clean(selected_paths[0])
+			go func() {
+				work, err := importWork(src)
+				if err != nil {
+					Engine.Raise(fmt.Errorf("import failed: %w", err))
+					return
+				}
+				Callable.Defer(Callable.New(func() {
+					fl.replaceTree(NewClientLoading(work))
+				}))
+			}()
+		},
+		DisplayServer.MainWindowId,
+	)
+	if err != nil {
+		Engine.Raise(fmt.Errorf("file dialog failed: %w", err))
+	}
+}